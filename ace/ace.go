@@ -11,12 +11,24 @@ func init() {
 }
 
 func New(module, name string, rpavf, wpavf float64) AceStruct {
-	return AceStruct{Regex{module, name}, rpavf, wpavf}
+	return AceStruct{Regex{Module: module, Name: name}, rpavf, wpavf}
+}
+
+// NewInst returns an ACE struct selecting the instance subtrees whose paths
+// match inst.
+func NewInst(inst string, rpavf, wpavf float64) AceStruct {
+	return AceStruct{Regex{Inst: inst}, rpavf, wpavf}
 }
 
 type Regex struct {
 	Module string `json:"module"`
 	Name   string `json:"name"`
+
+	// Inst selects whole instance subtrees, such as register file macros, by
+	// matching their hierarchical instance paths. Each subtree is one ACE
+	// structure whose ports are its ACE nodes. Module and Name are not used
+	// along with it.
+	Inst string `json:"inst"`
 }
 
 type AceStruct struct {
@@ -61,3 +61,11 @@ func TestThree(t *testing.T) {
 		t.Errorf("Expecting a slice with 2 AceStruct. Got %d", len(a))
 	}
 }
+
+func TestInst(t *testing.T) {
+	str := `[ { "sel": {"inst": "^top/core/rf0$"}, "rpavf": 0.5, "wpavf": 0.25 } ]`
+	a := Load(strings.NewReader(str))
+	if len(a) != 1 || a[0].Selector.Inst != "^top/core/rf0$" || a[0].Selector.Module != "" {
+		t.Errorf("Expecting one AceStruct selecting instance ^top/core/rf0$. Got %v", a)
+	}
+}
@@ -0,0 +1,272 @@
+// Package archive packs all the collections of a cache into a single gzipped
+// tar file so that a parsed and built design can be moved to a site that has
+// no access to the originating mongo server.
+//
+// The archive holds a manifest.json entry followed by one entry per
+// collection. Each collection entry is the concatenation of the raw BSON
+// documents of the collection, the same layout that mongodump uses.
+package archive
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	"sart/meta"
+)
+
+const manifestName = "manifest.json"
+
+var BadArchive error = fmt.Errorf("-!- Bad Archive Error")
+
+////////////////////////////////////////////////////////////////////////////////
+
+type Manifest struct {
+	Schema      int          `json:"schema"`
+	Cache       string       `json:"cache"`
+	Created     time.Time    `json:"created"`
+	Collections []Collection `json:"collections"`
+}
+
+// Collection describes one archived collection. The suffix is the collection
+// name without the cache name so that an archive can be imported under a
+// different cache name.
+type Collection struct {
+	Suffix string `json:"suffix"`
+	Docs   int    `json:"docs"`
+}
+
+func (m Manifest) String() (str string) {
+	str += fmt.Sprintf("cache:%q schema:%d created:%v", m.Cache, m.Schema,
+		m.Created.Format(time.RFC3339))
+	for _, c := range m.Collections {
+		str += fmt.Sprintf(" %s:%d", c.Suffix, c.Docs)
+	}
+	return
+}
+
+////////////////////////////////////////////////////////////////////////////////
+
+// Writer gathers the documents of each collection before the archive is
+// written out. A tar entry needs its size up front, so documents are spooled to
+// temporary files till Close.
+type Writer struct {
+	manifest Manifest
+	dir      string
+	spools   []*os.File
+}
+
+func NewWriter(cache string) (*Writer, error) {
+	dir, err := ioutil.TempDir("", "sart-archive-")
+	if err != nil {
+		return nil, err
+	}
+
+	w := &Writer{
+		manifest: Manifest{
+			Schema:  meta.Current,
+			Cache:   cache,
+			Created: time.Now(),
+		},
+		dir: dir,
+	}
+	return w, nil
+}
+
+// Collection starts a new collection entry. All subsequent WriteDoc calls add
+// documents to it.
+func (w *Writer) Collection(suffix string) error {
+	f, err := os.Create(filepath.Join(w.dir, fmt.Sprintf("%03d", len(w.spools))))
+	if err != nil {
+		return err
+	}
+	w.spools = append(w.spools, f)
+	w.manifest.Collections = append(w.manifest.Collections, Collection{Suffix: suffix})
+	return nil
+}
+
+// WriteDoc adds one raw BSON document to the current collection.
+func (w *Writer) WriteDoc(doc []byte) error {
+	if len(w.spools) == 0 {
+		return fmt.Errorf("WriteDoc called before Collection")
+	}
+	_, err := w.spools[len(w.spools)-1].Write(doc)
+	if err != nil {
+		return err
+	}
+	w.manifest.Collections[len(w.spools)-1].Docs++
+	return nil
+}
+
+func (w Writer) Manifest() Manifest {
+	return w.manifest
+}
+
+// Close writes the manifest and all collections to out as a gzipped tar and
+// removes the temporary spool files.
+func (w *Writer) Close(out io.Writer) (err error) {
+	defer w.Abort()
+
+	gz := gzip.NewWriter(out)
+	tw := tar.NewWriter(gz)
+
+	bytes, err := json.MarshalIndent(w.manifest, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	err = tw.WriteHeader(&tar.Header{
+		Name:    manifestName,
+		Mode:    0644,
+		Size:    int64(len(bytes)),
+		ModTime: w.manifest.Created,
+	})
+	if err != nil {
+		return err
+	}
+	if _, err = tw.Write(bytes); err != nil {
+		return err
+	}
+
+	for i, f := range w.spools {
+		size, err := f.Seek(0, io.SeekCurrent)
+		if err != nil {
+			return err
+		}
+		if _, err = f.Seek(0, io.SeekStart); err != nil {
+			return err
+		}
+
+		err = tw.WriteHeader(&tar.Header{
+			Name:    w.manifest.Collections[i].Suffix + ".bson",
+			Mode:    0644,
+			Size:    size,
+			ModTime: w.manifest.Created,
+		})
+		if err != nil {
+			return err
+		}
+		if _, err = io.Copy(tw, f); err != nil {
+			return err
+		}
+		f.Close()
+	}
+
+	if err = tw.Close(); err != nil {
+		return err
+	}
+	return gz.Close()
+}
+
+// Abort closes and removes the temporary spool files without writing anything.
+// Once Close has run it does nothing, so it can be deferred as soon as the
+// Writer is made.
+func (w *Writer) Abort() {
+	for _, f := range w.spools {
+		f.Close()
+	}
+	w.spools = nil
+	os.RemoveAll(w.dir)
+}
+
+////////////////////////////////////////////////////////////////////////////////
+
+// Reader walks through the collections of an archive in the order they were
+// written.
+type Reader struct {
+	manifest Manifest
+	tr       *tar.Reader
+	next     int
+}
+
+// NewReader reads the manifest of the archive and checks its schema version.
+// Archives from older trees are accepted and are migrated on import; archives
+// from newer trees are refused.
+func NewReader(in io.Reader) (*Reader, error) {
+	gz, err := gzip.NewReader(in)
+	if err != nil {
+		return nil, err
+	}
+
+	r := &Reader{tr: tar.NewReader(gz)}
+
+	hdr, err := r.tr.Next()
+	if err != nil {
+		return nil, err
+	}
+	if hdr.Name != manifestName {
+		return nil, fmt.Errorf("%v: expecting %s, found %s", BadArchive,
+			manifestName, hdr.Name)
+	}
+
+	err = json.NewDecoder(r.tr).Decode(&r.manifest)
+	if err != nil {
+		return nil, err
+	}
+
+	if r.manifest.Schema > meta.Current {
+		return nil, fmt.Errorf("%v: schema version %d, this tool knows up to %d",
+			BadArchive, r.manifest.Schema, meta.Current)
+	}
+
+	return r, nil
+}
+
+func (r Reader) Manifest() Manifest {
+	return r.manifest
+}
+
+// Next advances to the next collection and returns its suffix. It returns
+// io.EOF when there are no more collections.
+func (r *Reader) Next() (string, error) {
+	hdr, err := r.tr.Next()
+	if err != nil {
+		return "", err
+	}
+	if r.next >= len(r.manifest.Collections) {
+		return "", fmt.Errorf("%v: unexpected entry %s", BadArchive, hdr.Name)
+	}
+
+	suffix := r.manifest.Collections[r.next].Suffix
+	if hdr.Name != suffix+".bson" {
+		return "", fmt.Errorf("%v: expecting %s.bson, found %s", BadArchive,
+			suffix, hdr.Name)
+	}
+	r.next++
+
+	return suffix, nil
+}
+
+// ReadDoc returns the next raw BSON document of the current collection. It
+// returns io.EOF at the end of the collection.
+func (r *Reader) ReadDoc() ([]byte, error) {
+	var size [4]byte
+
+	_, err := io.ReadFull(r.tr, size[:])
+	if err != nil {
+		return nil, err
+	}
+
+	// The first four bytes of a BSON document hold its total length, little
+	// endian, including the four bytes themselves.
+	length := binary.LittleEndian.Uint32(size[:])
+	if length < 5 {
+		return nil, fmt.Errorf("%v: document length %d", BadArchive, length)
+	}
+
+	doc := make([]byte, length)
+	copy(doc, size[:])
+
+	_, err = io.ReadFull(r.tr, doc[4:])
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	return doc, err
+}
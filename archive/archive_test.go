@@ -0,0 +1,137 @@
+package archive
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"testing"
+
+	"sart/meta"
+
+	"gopkg.in/mgo.v2/bson"
+)
+
+func TestRoundTrip(t *testing.T) {
+	testcases := []struct {
+		suffix string
+		docs   []bson.M
+	}{
+		{"_ports", []bson.M{{"module": "a", "name": "p0", "pos": 0}}},
+		{"_insts", []bson.M{}},
+		{"_nnodes", []bson.M{
+			{"module": "a", "name": "n0", "rpace": "00"},
+			{"module": "a", "name": "n1", "rpace": "01"},
+		}},
+	}
+
+	w, err := NewWriter("test")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range testcases {
+		if err := w.Collection(tc.suffix); err != nil {
+			t.Fatal(err)
+		}
+		for _, doc := range tc.docs {
+			bytes, err := bson.Marshal(doc)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := w.WriteDoc(bytes); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := w.Close(&buf); err != nil {
+		t.Fatal(err)
+	}
+
+	r, err := NewReader(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	m := r.Manifest()
+	if m.Cache != "test" || m.Schema != meta.Current {
+		t.Errorf("Unexpected manifest %v", m)
+	}
+	if len(m.Collections) != len(testcases) {
+		t.Fatalf("Expecting %d collections. Got %d", len(testcases), len(m.Collections))
+	}
+
+	for i, tc := range testcases {
+		if m.Collections[i].Docs != len(tc.docs) {
+			t.Errorf("Expecting %d docs in %s. Got %d", len(tc.docs), tc.suffix,
+				m.Collections[i].Docs)
+		}
+
+		suffix, err := r.Next()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if suffix != tc.suffix {
+			t.Errorf("Expecting collection %s. Got %s", tc.suffix, suffix)
+		}
+
+		for _, exp := range tc.docs {
+			doc, err := r.ReadDoc()
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got bson.M
+			if err := bson.Unmarshal(doc, &got); err != nil {
+				t.Fatal(err)
+			}
+			if got["name"] != exp["name"] {
+				t.Errorf("Expecting doc %v. Got %v", exp, got)
+			}
+		}
+
+		if _, err := r.ReadDoc(); err != io.EOF {
+			t.Errorf("Expecting io.EOF at end of %s. Got %v", tc.suffix, err)
+		}
+	}
+
+	if _, err := r.Next(); err != io.EOF {
+		t.Errorf("Expecting io.EOF after last collection. Got %v", err)
+	}
+}
+
+func TestAbort(t *testing.T) {
+	w, err := NewWriter("test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Collection("_ports"); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.WriteDoc([]byte{5, 0, 0, 0, 0}); err != nil {
+		t.Fatal(err)
+	}
+
+	w.Abort()
+	if _, err := os.Stat(w.dir); !os.IsNotExist(err) {
+		t.Errorf("Expecting %s to be removed. Got %v", w.dir, err)
+	}
+	w.Abort()
+}
+
+func TestBadSchema(t *testing.T) {
+	w, err := NewWriter("test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.manifest.Schema = meta.Current + 1
+
+	var buf bytes.Buffer
+	if err := w.Close(&buf); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := NewReader(&buf); err == nil {
+		t.Errorf("Expecting an error for schema version %d", meta.Current+1)
+	}
+}
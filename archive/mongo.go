@@ -0,0 +1,169 @@
+package archive
+
+import (
+	"context"
+	"io"
+	"log"
+	"strings"
+
+	"sart/bulk"
+	"sart/meta"
+	"sart/netlist"
+	"sart/rtl"
+
+	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// collections lists every collection that makes up cache: the rtl module
+// collections followed by the built netlist collections. Nodes carry the ACE
+// terms from the last walk, so run results travel along with the netlist.
+func collections(cache string) (colls []string) {
+	colls = append(colls, rtl.Collections(cache)...)
+	colls = append(colls, netlist.Collections(cache)...)
+	return
+}
+
+// Export writes all collections of cache to out. Only caches at the current
+// schema version can be exported.
+func Export(s *mgo.Session, cache string, out io.Writer) (Manifest, error) {
+	sess := s.Copy()
+	defer sess.Close()
+
+	err := meta.Check(sess, cache, false)
+	if err != nil {
+		return Manifest{}, err
+	}
+
+	w, err := NewWriter(cache)
+	if err != nil {
+		return Manifest{}, err
+	}
+	defer w.Abort()
+
+	for _, coll := range collections(cache) {
+		suffix := strings.TrimPrefix(coll, cache)
+
+		err = w.Collection(suffix)
+		if err != nil {
+			return Manifest{}, err
+		}
+
+		iter := sess.DB("").C(coll).Find(nil).Iter()
+
+		var raw bson.Raw
+		for iter.Next(&raw) {
+			err = w.WriteDoc(raw.Data)
+			if err != nil {
+				iter.Close()
+				return Manifest{}, err
+			}
+		}
+
+		err = iter.Close()
+		if err != nil {
+			return Manifest{}, err
+		}
+
+		log.Printf("Exported %s", coll)
+	}
+
+	return w.Manifest(), w.Close(out)
+}
+
+// Import replaces the collections of cache with the contents of the archive
+// in. The cache name need not match the one the archive was exported from. An
+// archive with an older schema version is migrated once imported. The cache
+// stays marked incomplete unless the import runs to completion; it stops
+// early when ctx is cancelled.
+func Import(ctx context.Context, s *mgo.Session, cache string, in io.Reader) (Manifest, error) {
+	sess := s.Copy()
+	defer sess.Close()
+
+	r, err := NewReader(in)
+	if err != nil {
+		return Manifest{}, err
+	}
+
+	for _, coll := range append(collections(cache), meta.Collection(cache)) {
+		err = sess.DB("").C(coll).DropCollection()
+		if err != nil {
+			log.Println(err)
+		}
+	}
+
+	err = meta.Begin(sess, cache, "import")
+	if err != nil {
+		return r.Manifest(), err
+	}
+
+	// Indexes are created before the documents go in so that the uniqueness
+	// constraints hold for imported documents as well.
+	rtl.EnsureIndexes(sess, cache)
+	netlist.EnsureIndexes(sess, cache)
+
+	writer := bulk.New(ctx, sess, bulk.Workers, bulk.BatchSize)
+
+	for err == nil {
+		var suffix string
+		suffix, err = r.Next()
+		if err == io.EOF {
+			err = nil
+			break
+		}
+		if err != nil {
+			break
+		}
+
+		coll := cache + suffix
+		var count int
+		count, err = importCollection(writer, coll, r)
+
+		log.Printf("Imported %s: %d", coll, count)
+	}
+
+	// Whatever stopped the import, the queued documents are either written
+	// or dropped before returning.
+	writer.Done()
+	stats, werr := writer.Wait()
+	log.Println("Import inserts:", stats)
+	if err == nil {
+		err = werr
+	}
+	if err != nil {
+		return r.Manifest(), err
+	}
+
+	m := r.Manifest()
+
+	err = meta.SetVersion(sess, cache, m.Schema)
+	if err != nil {
+		return m, err
+	}
+
+	err = meta.Finish(sess, cache, "import")
+	if err != nil {
+		return m, err
+	}
+
+	return m, meta.Check(sess, cache, true)
+}
+
+func importCollection(writer *bulk.Writer, coll string, r *Reader) (count int, err error) {
+	for {
+		if err := writer.Err(); err != nil {
+			return count, err
+		}
+
+		doc, err := r.ReadDoc()
+		if err == io.EOF {
+			return count, nil
+		}
+		if err != nil {
+			return count, err
+		}
+
+		writer.Insert(coll, bson.Raw{Kind: 0x03, Data: doc})
+		count++
+	}
+}
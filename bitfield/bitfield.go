@@ -3,12 +3,22 @@ package bitfield
 import (
 	"fmt"
 	"log"
+	"math/bits"
+	"sort"
 
 	"gopkg.in/mgo.v2/bson"
 )
 
+// BitField is a fixed-size set of bit positions. It is held sparse, as the
+// sorted positions of its set bits, for as long as that takes less memory than
+// the bits themselves, and dense, as 64-bit words, beyond that. A new bitfield
+// holds no storage at all until a bit is set. A nil bitfield reads as one with
+// no bits set, so that nodes need not carry one until they gain terms; only
+// methods that set bits need a bitfield of their own.
 type BitField struct {
-	Fields []byte
+	words []uint64 // Dense bits; nil while sparse
+	size  int      // Length in bytes
+	idx   []int32  // Sorted positions of the set bits while sparse
 }
 
 func New(size int) *BitField {
@@ -21,18 +31,21 @@ func New(size int) *BitField {
 	numbytes := (size-1)/8 + 1
 
 	f := &BitField{
-		Fields: make([]byte, numbytes, numbytes),
+		size: numbytes,
 	}
 
 	return f
 }
 
-func (f BitField) String() string {
-	return fmt.Sprintf("%x", f.Fields)
+func (f *BitField) String() string {
+	if f == nil {
+		return ""
+	}
+	return fmt.Sprintf("%x", f.bytes())
 }
 
 func (f BitField) length() int {
-	return len(f.Fields)
+	return f.size
 }
 
 func (f BitField) locate(pos int) (byt int, bit uint8) {
@@ -45,76 +58,434 @@ func posof(byt int, bit uint8) int {
 	return (byt << 3) | int(bit)
 }
 
+// numwords returns the number of words that hold the bits of f when dense.
+func (f BitField) numwords() int {
+	return (f.size + 7) / 8
+}
+
+// isSparse reports whether f holds the positions of its set bits rather than
+// the bits themselves.
+func (f BitField) isSparse() bool {
+	return f.words == nil
+}
+
+// fitsSparse reports whether count positions take less memory than the bits
+// of f. Positions take four bytes each.
+func (f BitField) fitsSparse(count int) bool {
+	return 4*count < f.size
+}
+
+// bytes returns the bits of f a byte at a time, lowest first, as they are
+// printed and saved.
+func (f BitField) bytes() []byte {
+	b := make([]byte, f.size)
+	f.Each(func(pos int) {
+		byt, bit := f.locate(pos)
+		b[byt] |= 1 << bit
+	})
+	return b
+}
+
+// setBytes makes f hold the bits in b, laid out as bytes returns them.
+func (f *BitField) setBytes(b []byte) {
+	f.size, f.idx = len(b), nil
+	f.words = make([]uint64, f.numwords())
+	for i, byt := range b {
+		f.words[i/8] |= uint64(byt) << (8 * uint(i%8))
+	}
+	f.shrink()
+}
+
+// densify switches f to holding its bits.
+func (f *BitField) densify() {
+	if !f.isSparse() {
+		return
+	}
+	f.words = make([]uint64, f.numwords())
+	for _, pos := range f.idx {
+		f.words[pos>>6] |= 1 << uint(pos&63)
+	}
+	f.idx = nil
+}
+
+// shrink switches dense f back to sparse once it holds well below the number
+// of positions at which it went dense, so that a bitfield on the edge does not
+// flip back and forth.
+func (f *BitField) shrink() {
+	if f.isSparse() || !f.fitsSparse(2*f.PopCount()) {
+		return
+	}
+	var idx []int32
+	f.Each(func(pos int) {
+		idx = append(idx, int32(pos))
+	})
+	f.words, f.idx = nil, idx
+}
+
+// search returns where pos is or would go in the positions of sparse f.
+func (f BitField) search(pos int) (i int, found bool) {
+	i = sort.Search(len(f.idx), func(i int) bool { return int(f.idx[i]) >= pos })
+	return i, i < len(f.idx) && int(f.idx[i]) == pos
+}
+
 func (f *BitField) Set(positions ...int) {
 	for _, pos := range positions {
-		byt, bit := f.locate(pos)
+		byt, _ := f.locate(pos)
 		if byt > f.length()-1 {
 			log.Panicf("BitField can set max pos %d. Attempting %d.",
 				f.length()*8-1, pos)
 		}
-		f.Fields[byt] |= (1 << bit)
+
+		if f.isSparse() {
+			i, found := f.search(pos)
+			if found {
+				continue
+			}
+			if f.fitsSparse(len(f.idx) + 1) {
+				f.idx = append(f.idx, 0)
+				copy(f.idx[i+1:], f.idx[i:])
+				f.idx[i] = int32(pos)
+				continue
+			}
+			f.densify()
+		}
+		f.words[pos>>6] |= 1 << uint(pos&63)
 	}
 }
 
 func (f *BitField) SetBitsOf(b BitField) {
+	f.OrChanged(&b)
+}
+
+// OrChanged sets the bits of b in f and reports whether any of them was not
+// already set.
+func (f *BitField) OrChanged(b *BitField) (changed bool) {
+	if b == nil {
+		return false
+	}
 	if f.length() != b.length() {
-		log.Panic("SetBitsOf: mismatch in lengths")
+		log.Panic("OrChanged: mismatch in lengths")
 	}
-	for i := range f.Fields {
-		f.Fields[i] |= b.Fields[i]
+
+	if b.isSparse() {
+		for _, pos := range b.idx {
+			if !f.IsSet(int(pos)) {
+				f.Set(int(pos))
+				changed = true
+			}
+		}
+		return
+	}
+
+	if f.isSparse() {
+		if b.AllUnset() {
+			return false
+		}
+		f.densify()
+	}
+	for i, w := range b.words {
+		if f.words[i]|w != f.words[i] {
+			f.words[i] |= w
+			changed = true
+		}
+	}
+	return
+}
+
+// And clears the bits of f that are not set in b.
+func (f *BitField) And(b *BitField) {
+	if f == nil {
+		return
+	}
+	if b == nil {
+		f.words, f.idx = nil, nil
+		return
+	}
+	if f.length() != b.length() {
+		log.Panic("And: mismatch in lengths")
+	}
+
+	switch {
+	case f.isSparse():
+		f.filter(func(pos int) bool { return b.IsSet(pos) })
+	case b.isSparse():
+		// What is left is a subset of b, which fits sparse
+		var idx []int32
+		for _, pos := range b.idx {
+			if f.IsSet(int(pos)) {
+				idx = append(idx, pos)
+			}
+		}
+		f.words, f.idx = nil, idx
+	default:
+		for i, w := range b.words {
+			f.words[i] &= w
+		}
+		f.shrink()
 	}
 }
 
+// AndNot clears the bits of f that are set in b.
+func (f *BitField) AndNot(b *BitField) {
+	if f == nil || b == nil {
+		return
+	}
+	if f.length() != b.length() {
+		log.Panic("AndNot: mismatch in lengths")
+	}
+
+	switch {
+	case f.isSparse():
+		f.filter(func(pos int) bool { return !b.IsSet(pos) })
+	case b.isSparse():
+		for _, pos := range b.idx {
+			f.words[pos>>6] &^= 1 << uint(pos&63)
+		}
+		f.shrink()
+	default:
+		for i, w := range b.words {
+			f.words[i] &^= w
+		}
+		f.shrink()
+	}
+}
+
+// filter keeps the positions of sparse f for which keep is true.
+func (f *BitField) filter(keep func(pos int) bool) {
+	idx := f.idx[:0]
+	for _, pos := range f.idx {
+		if keep(int(pos)) {
+			idx = append(idx, pos)
+		}
+	}
+	f.idx = idx
+}
+
 func (f *BitField) Unset(positions ...int) {
 	for _, pos := range positions {
-		byt, bit := f.locate(pos)
+		byt, _ := f.locate(pos)
 		if byt > f.length()-1 {
 			log.Panicf("BitField can unset max pos %d. Attempting %d.",
 				f.length()*8-1, pos)
 		}
-		f.Fields[byt] &= ^(1 << bit)
+
+		if f.isSparse() {
+			if i, found := f.search(pos); found {
+				f.idx = append(f.idx[:i], f.idx[i+1:]...)
+			}
+			continue
+		}
+		f.words[pos>>6] &^= 1 << uint(pos&63)
+	}
+
+	if len(positions) > 0 {
+		f.shrink()
 	}
 }
 
-func (f BitField) Test() (setpositions []int) {
-	for i := range f.Fields {
-		for j := uint8(0); j < 8; j++ {
-			mask := uint8(1) << j
-			if f.Fields[i]&mask != 0 {
-				setpositions = append(setpositions, posof(i, j))
+// Equal reports whether f and b are the same size and have the same bits set,
+// however each is held.
+func (f *BitField) Equal(b *BitField) bool {
+	if f == nil || b == nil {
+		return f.AllUnset() && b.AllUnset()
+	}
+	if f.length() != b.length() || f.PopCount() != b.PopCount() {
+		return false
+	}
+
+	switch {
+	case f.isSparse() && b.isSparse():
+		for i, pos := range f.idx {
+			if b.idx[i] != pos {
+				return false
+			}
+		}
+	case !f.isSparse() && !b.isSparse():
+		for i, w := range f.words {
+			if b.words[i] != w {
+				return false
+			}
+		}
+	case f.isSparse():
+		for _, pos := range f.idx {
+			if !b.IsSet(int(pos)) {
+				return false
 			}
 		}
+	default:
+		return b.Equal(f)
 	}
+	return true
+}
+
+// PopCount returns the number of bits set in f.
+func (f *BitField) PopCount() (n int) {
+	if f == nil {
+		return 0
+	}
+	if f.isSparse() {
+		return len(f.idx)
+	}
+	for _, w := range f.words {
+		n += bits.OnesCount64(w)
+	}
+	return
+}
+
+// Each calls fn with the position of each bit set in f, lowest first.
+func (f *BitField) Each(fn func(pos int)) {
+	if f == nil {
+		return
+	}
+	if f.isSparse() {
+		for _, pos := range f.idx {
+			fn(int(pos))
+		}
+		return
+	}
+
+	for i, w := range f.words {
+		for w != 0 {
+			fn(i<<6 | bits.TrailingZeros64(w))
+			w &= w - 1
+		}
+	}
+}
+
+func (f *BitField) Test() (setpositions []int) {
+	f.Each(func(pos int) {
+		setpositions = append(setpositions, pos)
+	})
 	return
 }
 
-func (f BitField) AllUnset() bool {
-	var acc byte
-	for _, b := range f.Fields {
-		acc |= b
+// IsSet reports whether bit pos is set. Positions beyond the end of f are
+// never set.
+func (f *BitField) IsSet(pos int) bool {
+	if f == nil {
+		return false
+	}
+	if f.isSparse() {
+		_, found := f.search(pos)
+		return found
+	}
+	w := pos >> 6
+	return w < len(f.words) && f.words[w]&(1<<uint(pos&63)) != 0
+}
+
+func (f *BitField) AllUnset() bool {
+	if f == nil {
+		return true
+	}
+	if f.isSparse() {
+		return len(f.idx) == 0
+	}
+	var acc uint64
+	for _, w := range f.words {
+		acc |= w
 	}
 	return acc == 0
 }
 
-// GetBSON makes BitField implement bson.Getter
-func (f BitField) GetBSON() (interface{}, error) {
-    // We want to save the bitfield as a simple hexadecimal string
-	return f.String(), nil
+// Clone returns a bitfield of the same size as f with the same bits set, held
+// the same way. The clone of nil is nil.
+func (f *BitField) Clone() *BitField {
+	if f == nil {
+		return nil
+	}
+	c := &BitField{
+		size: f.size,
+	}
+	if f.isSparse() {
+		if len(f.idx) > 0 {
+			c.idx = append([]int32(nil), f.idx...)
+		}
+		return c
+	}
+	c.words = append([]uint64(nil), f.words...)
+	return c
+}
+
+// Copy is Clone.
+func (f *BitField) Copy() *BitField {
+	return f.Clone()
+}
+
+// sparse is the BSON layout of a bitfield with few bits set: its length in
+// bytes and the positions of the set bits.
+type sparse struct {
+	Len int   `bson:"len"`
+	Idx []int `bson:"idx"`
 }
 
-// SetBSON makes BitField implement bson.Setter
+// GetBSON makes BitField implement bson.Getter. A nil bitfield is saved as
+// null.
+func (f *BitField) GetBSON() (interface{}, error) {
+	if f == nil {
+		return nil, nil
+	}
+	// Most bitfields have only a handful of bits set. Saving their positions is
+	// much smaller than the bitfield itself. Otherwise save the raw bytes.
+	positions := f.Test()
+	if f.fitsSparse(len(positions)) {
+		return sparse{f.length(), positions}, nil
+	}
+	return bson.Binary{Kind: 0x00, Data: f.bytes()}, nil
+}
+
+// SetBSON makes BitField implement bson.Setter. Null reads back as a nil
+// bitfield.
 func (f *BitField) SetBSON(raw bson.Raw) error {
+	switch raw.Kind {
+	case 0x0A:
+		return bson.SetZero
+
+	case 0x02:
+		// Older caches saved the bitfield as a hexadecimal string
+		return f.setHex(raw)
+
+	case 0x03:
+		var s sparse
+		err := raw.Unmarshal(&s)
+		if err != nil {
+			return err
+		}
+		f.words, f.idx, f.size = nil, nil, s.Len
+		for _, pos := range s.Idx {
+			if pos < 0 || pos >= 8*s.Len {
+				return fmt.Errorf("bitfield position %d out of range for length %d",
+					pos, s.Len)
+			}
+		}
+		f.Set(s.Idx...)
+		return nil
+
+	case 0x05:
+		var b bson.Binary
+		err := raw.Unmarshal(&b)
+		if err != nil {
+			return err
+		}
+		f.setBytes(b.Data)
+		return nil
+	}
+
+	return fmt.Errorf("cannot unmarshal BSON kind 0x%02x into a bitfield", raw.Kind)
+}
+
+func (f *BitField) setHex(raw bson.Raw) error {
 	var str string
     // We need to unmarshal the raw data into a string before it can be
-    // interpreted. It will unmarshal into a string because we encoded it as a
-    // string in the Getter - GetBSON.
+    // interpreted.
 	err := raw.Unmarshal(&str)
 	if err != nil {
 		return err
 	}
-	_, err = fmt.Sscanf(str, "%x", &f.Fields) // Opposite of what BitField.String() does
+	var b []byte
+	_, err = fmt.Sscanf(str, "%x", &b) // Opposite of what BitField.String() does
     if err != nil {
         return err
     }
+    f.setBytes(b)
     return nil
 }
@@ -3,6 +3,8 @@ package bitfield
 import (
 	"fmt"
 	"testing"
+
+	"gopkg.in/mgo.v2/bson"
 )
 
 func TestNew(t *testing.T) {
@@ -99,9 +101,9 @@ func TestSet(t *testing.T) {
 
 		byt, _ := f.locate(testcase.pos)
 
-		if f.Fields[byt] != testcase.exp_byte {
+		if byteAt(f, byt) != testcase.exp_byte {
 			t.Errorf("Exepecting byte 0x%x for pos:%d. Got 0x%x", testcase.exp_byte,
-				testcase.pos, f.Fields[byt])
+				testcase.pos, byteAt(f, byt))
 		}
 	}
 }
@@ -124,7 +126,7 @@ func TestSetMultiple(t *testing.T) {
 		f.Set(testcase.pos...)
 		// }
 
-		word := uint16(f.Fields[1])<<8 | uint16(f.Fields[0])
+		word := uint16(byteAt(f, 1))<<8 | uint16(byteAt(f, 0))
 
 		if word != testcase.exp_word {
 			t.Errorf("Expecting 0x%x for %v. Got 0x%x", testcase.exp_word,
@@ -150,7 +152,7 @@ func TestUnset(t *testing.T) {
 
 		f.Unset(testcase.unsetpos...)
 
-		word := uint16(f.Fields[1])<<8 | uint16(f.Fields[0])
+		word := uint16(byteAt(f, 1))<<8 | uint16(byteAt(f, 0))
 
 		if word != testcase.exp_word {
 			t.Errorf("Expecting 0x%x for set:%v and unset:%v. Got 0x%x",
@@ -213,6 +215,44 @@ func TestAllUnset(t *testing.T) {
 	}
 }
 
+func TestIsSet(t *testing.T) {
+	f := New(20)
+	f.Set(3, 17)
+
+	for _, testcase := range []struct {
+		pos int
+		exp bool
+	}{
+		{3, true},
+		{17, true},
+		{4, false},
+		{16, false},
+		{23, false},
+		{100, false},
+	} {
+		if f.IsSet(testcase.pos) != testcase.exp {
+			t.Errorf("Expecting IsSet(%d) to return %v with %q",
+				testcase.pos, testcase.exp, f)
+		}
+	}
+}
+
+func TestCopy(t *testing.T) {
+	f := New(20)
+	f.Set(1, 9)
+
+	c := f.Copy()
+	if c.String() != f.String() {
+		t.Errorf("Expecting copy %q. Got %q", f, c)
+	}
+
+	// The copy must not share storage with the original
+	c.Set(17)
+	if f.String() != "020200" {
+		t.Errorf("Setting a bit in the copy changed the original to %q", f)
+	}
+}
+
 func TestString(t *testing.T) {
 	for _, test := range []struct {
 		bits []int
@@ -250,3 +290,357 @@ func ExampleString() {
     // 030000
     // 030200
 }
+
+func TestBSON(t *testing.T) {
+	type doc struct {
+		F *BitField `bson:"f"`
+	}
+
+	for _, test := range []struct {
+		size int
+		bits []int
+		kind byte
+	}{
+		{20, []int{5}, 0x05},          // 3 bytes: even one index does not pay off
+		{200, []int{}, 0x03},          // 25 bytes, nothing set
+		{200, []int{1, 7, 199}, 0x03}, // 25 bytes, 3 indexes take 12
+		{64, []int{0, 1, 2, 3}, 0x05}, // 8 bytes, 4 indexes would take 16
+	} {
+		f := New(test.size)
+		f.Set(test.bits...)
+
+		bytes, err := bson.Marshal(doc{f})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var raw bson.M
+		if err := bson.Unmarshal(bytes, &raw); err != nil {
+			t.Fatal(err)
+		}
+		var kind byte
+		switch raw["f"].(type) {
+		case []byte:
+			kind = 0x05
+		case bson.M:
+			kind = 0x03
+		}
+		if kind != test.kind {
+			t.Errorf("Expecting BSON kind 0x%02x for %v of size %d. Got %T",
+				test.kind, test.bits, test.size, raw["f"])
+		}
+
+		var got doc
+		if err := bson.Unmarshal(bytes, &got); err != nil {
+			t.Fatal(err)
+		}
+		if got.F.String() != f.String() {
+			t.Errorf("Expecting %q after round trip. Got %q", f, got.F)
+		}
+	}
+}
+
+func TestBSONHex(t *testing.T) {
+	// Caches from before schema version 2 hold bitfields as hex strings
+	bytes, err := bson.Marshal(bson.M{"f": "060100"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var got struct {
+		F *BitField `bson:"f"`
+	}
+	if err := bson.Unmarshal(bytes, &got); err != nil {
+		t.Fatal(err)
+	}
+
+	exp := New(20)
+	exp.Set(1, 2, 8)
+	if got.F.String() != exp.String() {
+		t.Errorf("Expecting %q from hex string. Got %q", exp, got.F)
+	}
+}
+
+func TestNil(t *testing.T) {
+	// Nodes leave their bitfields nil until they gain a term
+	var f *BitField
+	if !f.AllUnset() || f.IsSet(0) || f.PopCount() != 0 || len(f.Test()) != 0 {
+		t.Errorf("Expecting a nil bitfield to have no bits set. Got %v", f.Test())
+	}
+	if f.Clone() != nil || f.String() != "" {
+		t.Errorf("Expecting a nil clone and an empty string. Got %v %q", f.Clone(), f)
+	}
+	if !f.Equal(New(8)) || !New(8).Equal(f) {
+		t.Errorf("Expecting a nil bitfield to equal an empty one")
+	}
+
+	// Nil arguments are empty too
+	g := New(8)
+	g.Set(1, 2)
+	if g.OrChanged(nil) || g.Equal(nil) {
+		t.Errorf("Expecting nil to change nothing and differ from %v", g.Test())
+	}
+	if g.AndNot(nil); g.PopCount() != 2 {
+		t.Errorf("Expecting AndNot nil to keep all bits. Got %v", g.Test())
+	}
+	if g.And(nil); !g.AllUnset() {
+		t.Errorf("Expecting And nil to clear all bits. Got %v", g.Test())
+	}
+
+	type doc struct {
+		F *BitField `bson:"f"`
+	}
+	bytes, err := bson.Marshal(doc{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := doc{New(8)}
+	if err := bson.Unmarshal(bytes, &got); err != nil {
+		t.Fatal(err)
+	}
+	if got.F != nil {
+		t.Errorf("Expecting nil after round trip. Got %q", got.F)
+	}
+}
+
+func TestSparse(t *testing.T) {
+	// 100 bytes: sparse up to 24 positions, dense from 25, sparse again below 13
+	f := New(800)
+	if f.words != nil || f.idx != nil {
+		t.Errorf("Expecting a new bitfield to hold no storage. Got %v", f)
+	}
+
+	var positions []int
+	for i := 0; i < 24; i++ {
+		positions = append(positions, 799-i*3)
+	}
+	f.Set(positions...)
+	if !f.isSparse() || len(f.idx) != 24 {
+		t.Fatalf("Expecting 24 positions held sparse. Got %v", f.Test())
+	}
+	for i := 1; i < len(f.idx); i++ {
+		if f.idx[i-1] >= f.idx[i] {
+			t.Fatalf("Expecting sorted positions. Got %v", f.idx)
+		}
+	}
+
+	f.Set(0)
+	if f.isSparse() || len(f.Test()) != 25 || !f.IsSet(0) || !f.IsSet(799) {
+		t.Fatalf("Expecting 25 positions to go dense. Got %v", f.Test())
+	}
+
+	f.Unset(positions[:12]...)
+	if f.isSparse() {
+		t.Errorf("Expecting 13 positions to stay dense")
+	}
+	f.Unset(positions[12])
+	if !f.isSparse() || len(f.Test()) != 12 || f.IsSet(positions[12]) {
+		t.Errorf("Expecting 12 positions to go back to sparse. Got %v", f.Test())
+	}
+}
+
+func TestSparseSetBitsOf(t *testing.T) {
+	for _, test := range []struct {
+		dst, src []int
+	}{
+		{[]int{1, 500}, []int{2, 500}}, // Sparse into sparse
+		{[]int{1}, make([]int, 0)},     // Nothing to add
+		{[]int{3}, seq(0, 60)},         // Dense into sparse
+		{seq(100, 150), []int{7, 120}}, // Sparse into dense
+		{seq(0, 30), seq(20, 60)},      // Sparse into sparse, going dense
+	} {
+		dst, src := New(800), New(800)
+		dst.Set(test.dst...)
+		src.Set(test.src...)
+
+		exp := New(800)
+		exp.Set(test.dst...)
+		exp.Set(test.src...)
+
+		dst.SetBitsOf(*src)
+		if dst.String() != exp.String() {
+			t.Errorf("Expecting %v | %v to be %v. Got %v", test.dst, test.src,
+				exp.Test(), dst.Test())
+		}
+	}
+}
+
+func TestSparseCopy(t *testing.T) {
+	f := New(800)
+	f.Set(5, 700)
+
+	c := f.Copy()
+	c.Set(6)
+	if f.IsSet(6) || !c.IsSet(5) || !c.IsSet(700) {
+		t.Errorf("Expecting an independent copy. Got %v from %v", c.Test(), f.Test())
+	}
+}
+
+func seq(from, to int) (positions []int) {
+	for i := from; i < to; i++ {
+		positions = append(positions, i)
+	}
+	return
+}
+
+// byteAt returns byte i of f as it is printed and saved.
+func byteAt(f *BitField, i int) byte {
+	return f.bytes()[i]
+}
+
+// sparseAndDense returns two bitfields of size bits with positions set, one
+// held sparse and the other dense.
+func sparseAndDense(size int, positions []int) (sparse, dense *BitField) {
+	sparse, dense = New(size), New(size)
+	sparse.Set(positions...)
+	dense.Set(positions...)
+	dense.densify()
+	return
+}
+
+func TestWordOps(t *testing.T) {
+	for _, test := range []struct {
+		a, b   []int
+		or     []int
+		and    []int
+		andnot []int
+	}{
+		{[]int{1, 64, 700}, []int{2, 64}, []int{1, 2, 64, 700}, []int{64}, []int{1, 700}},
+		{[]int{}, []int{5}, []int{5}, nil, nil},
+		{seq(0, 40), seq(30, 50), seq(0, 50), seq(30, 40), seq(0, 30)},
+	} {
+		as, ad := sparseAndDense(800, test.a)
+		bs, bd := sparseAndDense(800, test.b)
+
+		for _, a := range []*BitField{as, ad} {
+			for _, b := range []*BitField{bs, bd} {
+				layout := fmt.Sprintf("%v (sparse %v) op %v (sparse %v)",
+					test.a, a.isSparse(), test.b, b.isSparse())
+
+				for _, op := range []struct {
+					name string
+					do   func(f *BitField)
+					exp  []int
+				}{
+					{"OrChanged", func(f *BitField) { f.OrChanged(b) }, test.or},
+					{"And", func(f *BitField) { f.And(b) }, test.and},
+					{"AndNot", func(f *BitField) { f.AndNot(b) }, test.andnot},
+				} {
+					f := a.Clone()
+					op.do(f)
+
+					exp := New(800)
+					exp.Set(op.exp...)
+					if !f.Equal(exp) || !exp.Equal(f) {
+						t.Errorf("%s: %s: expecting %v. Got %v", op.name, layout, op.exp, f.Test())
+					}
+					if f.PopCount() != len(op.exp) {
+						t.Errorf("%s: %s: expecting %d bits set. Got %d", op.name, layout,
+							len(op.exp), f.PopCount())
+					}
+				}
+
+				f := a.Clone()
+				changed := f.OrChanged(b)
+				if changed != (len(test.or) != len(test.a)) {
+					t.Errorf("OrChanged: %s: expecting changed %v. Got %v", layout, !changed, changed)
+				}
+				if f.OrChanged(b) {
+					t.Errorf("OrChanged: %s: expecting no change the second time", layout)
+				}
+			}
+		}
+	}
+}
+
+func TestEqual(t *testing.T) {
+	as, ad := sparseAndDense(800, []int{3, 400})
+	for _, test := range []struct {
+		a, b *BitField
+		exp  bool
+	}{
+		{as, ad, true},
+		{ad, as, true},
+		{as, New(800), false},
+		{as, New(808), false},
+	} {
+		if test.a.Equal(test.b) != test.exp {
+			t.Errorf("Expecting %v equal to %v to be %v", test.a.Test(), test.b.Test(), test.exp)
+		}
+	}
+}
+
+func TestEach(t *testing.T) {
+	positions := []int{0, 63, 64, 127, 128, 799}
+	sparse, dense := sparseAndDense(800, positions)
+	for _, f := range []*BitField{sparse, dense} {
+		var got []int
+		f.Each(func(pos int) { got = append(got, pos) })
+		if fmt.Sprint(got) != fmt.Sprint(positions) {
+			t.Errorf("Expecting %v. Got %v", positions, got)
+		}
+	}
+}
+
+////////////////////////////////////////////////////////////////////////////////
+
+// benchFields returns two dense bitfields of 20k bits, as walks see them with
+// many ACE structs, where b adds nothing to a.
+func benchFields() (a, b *BitField) {
+	a, b = New(20000), New(20000)
+	for pos := 0; pos < 20000; pos += 7 {
+		a.Set(pos)
+		if pos%2 == 0 {
+			b.Set(pos)
+		}
+	}
+	return
+}
+
+// BenchmarkOrHex detects change the way walks used to, by printing the
+// bitfield before and after.
+func BenchmarkOrHex(b *testing.B) {
+	f, g := benchFields()
+	for i := 0; i < b.N; i++ {
+		before := f.String()
+		f.SetBitsOf(*g)
+		_ = f.String() != before
+	}
+}
+
+func BenchmarkOrChanged(b *testing.B) {
+	f, g := benchFields()
+	for i := 0; i < b.N; i++ {
+		f.OrChanged(g)
+	}
+}
+
+func BenchmarkOrChangedSparse(b *testing.B) {
+	f, g := New(20000), New(20000)
+	f.Set(1, 500, 19999)
+	g.Set(500)
+	for i := 0; i < b.N; i++ {
+		f.OrChanged(g)
+	}
+}
+
+func BenchmarkClone(b *testing.B) {
+	f, _ := benchFields()
+	for i := 0; i < b.N; i++ {
+		f.Clone()
+	}
+}
+
+func BenchmarkPopCount(b *testing.B) {
+	f, _ := benchFields()
+	for i := 0; i < b.N; i++ {
+		f.PopCount()
+	}
+}
+
+func BenchmarkEach(b *testing.B) {
+	f, _ := benchFields()
+	for i := 0; i < b.N; i++ {
+		f.Each(func(int) {})
+	}
+}
@@ -0,0 +1,263 @@
+// Package bulk batches inserts and updates into mongo bulk operations that are
+// run by a pool of workers, so that saving millions of documents does not cost
+// millions of round trips.
+//
+// A Writer stops on the first failed write or when its context is cancelled.
+// Documents queued after that are dropped and the error is returned by Wait.
+package bulk
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"gopkg.in/mgo.v2"
+)
+
+// Defaults used by the rtl and netlist packages when they start a Writer.
+// Commands override them from their configuration.
+var (
+	Workers   = 8
+	BatchSize = 1000
+)
+
+type op struct {
+	coll string
+	doc  interface{} // Document to insert, or the update if sel is non-nil
+	sel  interface{}
+}
+
+// Writer queues documents and writes them out in batches of up to batch
+// operations per collection.
+type Writer struct {
+	session *mgo.Session
+	batch   int
+	ops     chan op
+	wg      sync.WaitGroup
+	start   time.Time
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	errc   chan error // Holds the first error encountered by any worker
+	mu     sync.Mutex
+	err    error
+
+	inserted int64
+	updated  int64
+	batches  int64
+}
+
+func New(ctx context.Context, s *mgo.Session, workers, batch int) *Writer {
+	if workers < 1 {
+		workers = 1
+	}
+	if batch < 1 {
+		batch = 1
+	}
+
+	w := &Writer{
+		session: s,
+		batch:   batch,
+		ops:     make(chan op, workers*batch),
+		start:   time.Now(),
+		errc:    make(chan error, 1),
+	}
+	w.ctx, w.cancel = context.WithCancel(ctx)
+
+	for i := 0; i < workers; i++ {
+		w.wg.Add(1)
+		go w.worker()
+	}
+
+	return w
+}
+
+// Insert queues doc for insertion into collection coll.
+func (w *Writer) Insert(coll string, doc interface{}) {
+	w.queue(op{coll: coll, doc: doc})
+}
+
+// Update queues an update of the document matching sel in collection coll.
+// As with mgo.Collection.Update, upd is either a replacement document or an
+// update with operators.
+func (w *Writer) Update(coll string, sel, upd interface{}) {
+	w.queue(op{coll: coll, doc: upd, sel: sel})
+}
+
+func (w *Writer) queue(o op) {
+	select {
+	case w.ops <- o:
+	case <-w.ctx.Done():
+		// Writer has stopped. Drop the document; Wait reports why.
+	}
+}
+
+// Done is to be invoked when there are no more documents to write.
+func (w *Writer) Done() {
+	close(w.ops)
+}
+
+// Wait waits till all queued documents have been written and returns the
+// throughput statistics. Wait is to be invoked after Done. The error is that
+// of the first failed write, or the context's error if it was cancelled
+// before all documents were written.
+func (w *Writer) Wait() (Stats, error) {
+	w.wg.Wait()
+	w.cancel()
+	return w.Stats(), w.Err()
+}
+
+// Err returns the error that stopped the writer, if it has stopped.
+func (w *Writer) Err() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.err == nil {
+		select {
+		case w.err = <-w.errc:
+		default:
+		}
+	}
+	return w.err
+}
+
+// fail records err if it is the first error and stops all workers.
+func (w *Writer) fail(err error) {
+	select {
+	case w.errc <- err:
+	default:
+	}
+	w.cancel()
+}
+
+func (w *Writer) Stats() Stats {
+	return Stats{
+		Inserted: int(atomic.LoadInt64(&w.inserted)),
+		Updated:  int(atomic.LoadInt64(&w.updated)),
+		Batches:  int(atomic.LoadInt64(&w.batches)),
+		Elapsed:  time.Since(w.start),
+	}
+}
+
+////////////////////////////////////////////////////////////////////////////////
+
+// pending is a bulk operation being filled up for one collection
+type pending struct {
+	bulk    *mgo.Bulk
+	inserts int
+	updates int
+}
+
+func (p pending) size() int {
+	return p.inserts + p.updates
+}
+
+func (w *Writer) worker() {
+	s := w.session.Copy()
+	defer s.Close()
+
+	defer w.wg.Done()
+
+	batches := make(map[string]*pending)
+
+	for {
+		var o op
+		var ok bool
+
+		select {
+		case o, ok = <-w.ops:
+		case <-w.ctx.Done():
+			w.stopped()
+			return
+		}
+
+		if !ok {
+			break
+		}
+
+		p, found := batches[o.coll]
+		if !found {
+			p = w.newPending(s, o.coll)
+			batches[o.coll] = p
+		}
+
+		if o.sel == nil {
+			p.bulk.Insert(o.doc)
+			p.inserts++
+		} else {
+			p.bulk.Update(o.sel, o.doc)
+			p.updates++
+		}
+
+		if p.size() >= w.batch {
+			if !w.run(o.coll, p) {
+				return
+			}
+			batches[o.coll] = w.newPending(s, o.coll)
+		}
+	}
+
+	for coll, p := range batches {
+		if p.size() > 0 && !w.run(coll, p) {
+			return
+		}
+	}
+}
+
+// stopped records why a worker gave up on its pending documents. A worker
+// only sees a cancelled context that it did not cancel itself when the parent
+// context was cancelled, e.g. on SIGINT.
+func (w *Writer) stopped() {
+	if err := w.ctx.Err(); err != nil {
+		w.fail(err)
+	}
+}
+
+func (w *Writer) newPending(s *mgo.Session, coll string) *pending {
+	b := s.DB("").C(coll).Bulk()
+	b.Unordered()
+	return &pending{bulk: b}
+}
+
+// run writes out a batch and reports whether the worker should carry on.
+func (w *Writer) run(coll string, p *pending) bool {
+	if w.ctx.Err() != nil {
+		w.stopped()
+		return false
+	}
+
+	_, err := p.bulk.Run()
+	if err != nil {
+		w.fail(fmt.Errorf("bulk write to %s: %v", coll, err))
+		return false
+	}
+
+	atomic.AddInt64(&w.inserted, int64(p.inserts))
+	atomic.AddInt64(&w.updated, int64(p.updates))
+	atomic.AddInt64(&w.batches, 1)
+	return true
+}
+
+////////////////////////////////////////////////////////////////////////////////
+
+type Stats struct {
+	Inserted int
+	Updated  int
+	Batches  int
+	Elapsed  time.Duration
+}
+
+// Rate returns the number of documents written per second.
+func (s Stats) Rate() float64 {
+	if s.Elapsed <= 0 {
+		return 0
+	}
+	return float64(s.Inserted+s.Updated) / s.Elapsed.Seconds()
+}
+
+func (s Stats) String() string {
+	return fmt.Sprintf("inserted:%d updated:%d batches:%d elapsed:%v rate:%.0f docs/s",
+		s.Inserted, s.Updated, s.Batches, s.Elapsed.Round(time.Millisecond), s.Rate())
+}
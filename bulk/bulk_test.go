@@ -0,0 +1,60 @@
+package bulk
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestRate(t *testing.T) {
+	testcases := []struct {
+		stats Stats
+		exp   float64
+	}{
+		{Stats{}, 0},
+		{Stats{Inserted: 100, Elapsed: time.Second}, 100},
+		{Stats{Inserted: 100, Updated: 50, Elapsed: 500 * time.Millisecond}, 300},
+	}
+
+	for _, tc := range testcases {
+		if got := tc.stats.Rate(); got != tc.exp {
+			t.Errorf("Expecting rate %v for %v. Got %v", tc.exp, tc.stats, got)
+		}
+	}
+}
+
+func TestString(t *testing.T) {
+	s := Stats{Inserted: 2000, Updated: 0, Batches: 2, Elapsed: 2 * time.Second}
+	exp := "inserted:2000 updated:0 batches:2 elapsed:2s rate:1000 docs/s"
+	if s.String() != exp {
+		t.Errorf("Expecting %q. Got %q", exp, s.String())
+	}
+}
+
+func TestFirstError(t *testing.T) {
+	w := &Writer{
+		ops:  make(chan op),
+		errc: make(chan error, 1),
+	}
+	w.ctx, w.cancel = context.WithCancel(context.Background())
+
+	if w.Err() != nil {
+		t.Errorf("Expecting no error on a fresh writer. Got %v", w.Err())
+	}
+
+	first := errors.New("first")
+	w.fail(first)
+	w.fail(errors.New("second"))
+
+	if w.Err() != first {
+		t.Errorf("Expecting the first error. Got %v", w.Err())
+	}
+	if w.Err() != first {
+		t.Errorf("Expecting Err to keep returning the first error. Got %v", w.Err())
+	}
+
+	// Nothing reads ops. Queueing must not block once the writer has stopped.
+	w.Insert("coll", struct{}{})
+	w.Update("coll", struct{}{}, struct{}{})
+}
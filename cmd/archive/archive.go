@@ -0,0 +1,84 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"sart/archive"
+	"sart/bulk"
+	"sart/config"
+)
+
+func main() {
+	var export, import_ string
+
+	cfg := config.Flags(flag.CommandLine)
+
+	flag.StringVar(&export, "export", "", "path of archive file to write the cache to")
+	flag.StringVar(&import_, "import", "", "path of archive file to read the cache from")
+
+	flag.Parse()
+
+	log.SetFlags(log.Lshortfile)
+
+	err := cfg.Load()
+	if err != nil {
+		log.Fatal(err)
+	}
+	bulk.Workers, bulk.BatchSize = cfg.Workers, cfg.Batch
+	cache := cfg.Cache
+
+	if cache == "" || (export == "") == (import_ == "") {
+		flag.PrintDefaults()
+		log.Fatal("Need -cache and exactly one of -export or -import")
+	}
+
+	log.SetOutput(os.Stdout)
+
+	session, err := cfg.Dial()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if export != "" {
+		file, err := os.Create(export)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		manifest, err := archive.Export(session, cache, file)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		err = file.Close()
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		log.Println("Exported", manifest)
+		return
+	}
+
+	file, err := os.Open(import_)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer file.Close()
+
+	// An interrupt stops the import cleanly and leaves the cache marked
+	// incomplete.
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	manifest, err := archive.Import(ctx, session, cache, file)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	log.Println("Imported", manifest)
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+
+	"sart/config"
+	"sart/meta"
+	"sart/netlist"
+	"sart/rtl"
+)
+
+// entry is how a cone node is exported as json
+type entry struct {
+	Name  string `json:"name"`
+	Type  string `json:"type"`
+	Kind  string `json:"kind"`
+	Depth int    `json:"depth"`
+}
+
+func main() {
+	var top, node, dir, stop, kinds, format, outpath string
+	var depth int
+
+	cfg := config.Flags(flag.CommandLine)
+
+	flag.StringVar(&top, "top", "", "name of top cell of the built netlist")
+	flag.StringVar(&node, "node", "", "full name of the node to start from")
+	flag.StringVar(&dir, "dir", "in", "direction of the cone: in for fan-in, out for fan-out")
+	flag.IntVar(&depth, "depth", 0, "maximum number of links from the node; 0 for no limit")
+	flag.StringVar(&stop, "stop", "", "comma separated kinds not to go through: seqn, ace")
+	flag.StringVar(&kinds, "kinds", "all", "comma separated kinds of node to report: prim, seqn, port, wire, ace, clock")
+	flag.StringVar(&format, "format", "text", "output format: text or json")
+	flag.StringVar(&outpath, "out", "", "path to write the cone to; stdout otherwise")
+
+	flag.Parse()
+
+	log.SetFlags(0)
+
+	err := cfg.Load()
+	if err != nil {
+		log.Fatal(err)
+	}
+	cache := cfg.Cache
+
+	if cache == "" || top == "" || node == "" {
+		flag.PrintDefaults()
+		log.Fatal("Insufficient arguments")
+	}
+
+	opt := netlist.ConeOptions{Depth: depth}
+	opt.Kinds, err = netlist.ParseKinds(kinds)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if stop != "" {
+		for _, k := range strings.Split(stop, ",") {
+			switch k {
+			case "seqn":
+				opt.StopAtSeqn = true
+			case "ace":
+				opt.StopAtAce = true
+			default:
+				log.Fatalf("Cannot stop at %q nodes", k)
+			}
+		}
+	}
+	if dir != "in" && dir != "out" {
+		log.Fatalf("Unknown direction %q", dir)
+	}
+	if format != "text" && format != "json" {
+		log.Fatalf("Unknown format %q", format)
+	}
+
+	session, err := cfg.Dial()
+	if err != nil {
+		log.Fatal(err)
+	}
+	err = meta.Check(session, cache, cfg.Migrate)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	rstore := rtl.NewStore(context.Background(), session, cache, false)
+	store := netlist.NewStore(context.Background(), session, rstore, cache, false)
+
+	g := store.Load(top).Graph()
+
+	id, found := g.ID(node)
+	if !found {
+		log.Fatalf("No node %q under %q", node, top)
+	}
+
+	var cone []netlist.ConeNode
+	if dir == "in" {
+		cone = g.FanIn(id, opt)
+	} else {
+		cone = g.FanOut(id, opt)
+	}
+	log.Printf("Fan-%s cone of %s: %d nodes", dir, node, len(cone))
+
+	var out io.Writer = os.Stdout
+	if outpath != "" {
+		file, err := os.Create(outpath)
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer file.Close()
+		out = file
+	}
+
+	err = write(out, format, cone)
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+func write(out io.Writer, format string, cone []netlist.ConeNode) error {
+	if format == "json" {
+		entries := []entry{}
+		for _, c := range cone {
+			entries = append(entries, entry{c.Node.Fullname(), c.Node.Type,
+				c.Node.Kind().String(), c.Depth})
+		}
+		enc := json.NewEncoder(out)
+		enc.SetIndent("", "  ")
+		return enc.Encode(entries)
+	}
+
+	for _, c := range cone {
+		_, err := fmt.Fprintf(out, "%d\t%s\t%s\t%s\n", c.Depth, c.Node.Fullname(),
+			c.Node.Type, c.Node.Kind())
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
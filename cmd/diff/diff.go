@@ -0,0 +1,141 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"io"
+	"log"
+	"os"
+
+	"sart/config"
+	"sart/diff"
+	"sart/meta"
+	"sart/netlist"
+	"sart/rtl"
+)
+
+func main() {
+	var before, top, outpath string
+	var nets bool
+
+	cfg := config.Flags(flag.CommandLine)
+
+	flag.StringVar(&before, "before", "", "name of the older cache, to compare -cache against")
+	flag.StringVar(&top, "top", "", "name of top cell to summarise changes under")
+	flag.BoolVar(&nets, "netlist", false, "also compare the built netlists of modules under top")
+	flag.StringVar(&outpath, "out", "", "path to write the change list to as json")
+
+	flag.Parse()
+
+	log.SetFlags(0)
+
+	err := cfg.Load()
+	if err != nil {
+		log.Fatal(err)
+	}
+	after := cfg.Cache
+
+	if after == "" || before == "" {
+		flag.PrintDefaults()
+		log.Fatal("Insufficient arguments")
+	}
+	if nets && top == "" {
+		log.Fatal("-netlist needs -top")
+	}
+
+	session, err := cfg.Dial()
+	if err != nil {
+		log.Fatal(err)
+	}
+	for _, cname := range []string{before, after} {
+		err = meta.Check(session, cname, cfg.Migrate)
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	bstore := rtl.NewStore(context.Background(), session, before, false)
+	astore := rtl.NewStore(context.Background(), session, after, false)
+	bmods := rtl.NewModuleCache(bstore, cfg.ModCache<<20)
+	amods := rtl.NewModuleCache(astore, cfg.ModCache<<20)
+
+	changes := diff.Caches(bstore.ModuleNames(), astore.ModuleNames(), bmods, amods)
+
+	if nets {
+		bnets := netlist.NewStore(context.Background(), session, bmods, before, false)
+		anets := netlist.NewStore(context.Background(), session, amods, after, false)
+
+		bhier, ahier := hierarchy(top, bmods), hierarchy(top, amods)
+		for mname := range ahier {
+			if bhier[mname] {
+				changes = append(changes, diff.Templates(mname,
+					bnets.Template(mname), anets.Template(mname))...)
+			}
+		}
+		diff.Sort(changes)
+	}
+	log.Printf("%d changes from %q to %q", len(changes), before, after)
+
+	if top != "" {
+		err = diff.Summary(os.Stdout, top, changes, bmods, amods)
+	} else {
+		for _, c := range changes {
+			if _, err = os.Stdout.WriteString(c.String() + "\n"); err != nil {
+				break
+			}
+		}
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if outpath != "" {
+		err = write(outpath, changes)
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+}
+
+// hierarchy returns top and every module it instantiates, at any depth.
+func hierarchy(top string, modules rtl.ModuleLoader) map[string]bool {
+	seen := map[string]bool{top: true}
+	queue := []string{top}
+
+	for len(queue) > 0 {
+		mname := queue[0]
+		queue = queue[1:]
+
+		for _, inst := range modules.LoadModule(mname).Insts {
+			if !inst.IsPrim && !seen[inst.Type] {
+				seen[inst.Type] = true
+				queue = append(queue, inst.Type)
+			}
+		}
+	}
+
+	return seen
+}
+
+// write writes the change list to the file at path.
+func write(path string, changes []diff.Change) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	err = encode(file, changes)
+	if cerr := file.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+func encode(out io.Writer, changes []diff.Change) error {
+	if changes == nil {
+		changes = []diff.Change{}
+	}
+	enc := json.NewEncoder(out)
+	enc.SetIndent("", "  ")
+	return enc.Encode(changes)
+}
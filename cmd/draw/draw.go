@@ -0,0 +1,97 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"io"
+	"log"
+	"os"
+
+	"sart/config"
+	"sart/draw"
+	"sart/meta"
+	"sart/netlist"
+	"sart/rtl"
+)
+
+func main() {
+	var top, net, format, outpath string
+	var depth int
+	var collapse, seq bool
+
+	cfg := config.Flags(flag.CommandLine)
+
+	flag.StringVar(&top, "top", "", "name of top cell of the built netlist")
+	flag.StringVar(&net, "net", "", "full name of the subnet to draw; the top cell otherwise")
+	flag.IntVar(&depth, "depth", 1, "levels of netlists whose insides are drawn; 0 for no limit")
+	flag.BoolVar(&collapse, "collapse", false, "leave out combinational prims")
+	flag.BoolVar(&seq, "seq", false, "draw only sequential and ACE nodes, linked through any depth of logic")
+	flag.StringVar(&format, "format", "dot", "output format: dot or graphml")
+	flag.StringVar(&outpath, "out", "", "path to write the drawing to; stdout otherwise")
+
+	flag.Parse()
+
+	log.SetFlags(0)
+
+	err := cfg.Load()
+	if err != nil {
+		log.Fatal(err)
+	}
+	cache := cfg.Cache
+
+	if cache == "" || top == "" {
+		flag.PrintDefaults()
+		log.Fatal("Insufficient arguments")
+	}
+	if format != "dot" && format != "graphml" {
+		log.Fatalf("Unknown format %q", format)
+	}
+	if net == "" {
+		net = top
+	}
+
+	session, err := cfg.Dial()
+	if err != nil {
+		log.Fatal(err)
+	}
+	err = meta.Check(session, cache, cfg.Migrate)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	rstore := rtl.NewStore(context.Background(), session, cache, false)
+	store := netlist.NewStore(context.Background(), session, rstore, cache, false)
+
+	n := store.Load(top).Subnet(net)
+	if n == nil {
+		log.Fatalf("No subnet %q under %q", net, top)
+	}
+
+	var p *draw.Picture
+	if seq {
+		p = draw.Seq(net, netlist.NewSeqGraph(n.Graph()))
+	} else {
+		p = draw.New(n, draw.Options{Depth: depth, Collapse: collapse})
+	}
+	log.Printf("Drawing %s: %d nodes %d links in %d netlists", net,
+		len(p.Nodes), len(p.Edges), len(p.Clusters))
+
+	var out io.Writer = os.Stdout
+	if outpath != "" {
+		file, err := os.Create(outpath)
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer file.Close()
+		out = file
+	}
+
+	if format == "dot" {
+		err = p.WriteDOT(out)
+	} else {
+		err = p.WriteGraphML(out)
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+}
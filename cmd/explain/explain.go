@@ -0,0 +1,150 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+
+	"sart/config"
+	"sart/meta"
+	"sart/netlist"
+	"sart/rtl"
+)
+
+// explanation is how the paths to a node are exported as json
+type explanation struct {
+	Node  string          `json:"node"`
+	Port  string          `json:"port"`
+	Term  int             `json:"term"`
+	Paths [][]netlist.Hop `json:"paths"`
+}
+
+func main() {
+	var top, node, port, format, outpath string
+	var term, limit, depth int
+	var all bool
+
+	cfg := config.Flags(flag.CommandLine)
+
+	flag.StringVar(&top, "top", "", "name of top cell of the built netlist")
+	flag.StringVar(&node, "node", "", "full name of the node to explain")
+	flag.IntVar(&term, "term", -1, "index of the ACE struct whose term to explain")
+	flag.StringVar(&port, "port", "r", "port of the term: r for read, w for write")
+	flag.BoolVar(&all, "all", false, "find every path, not just a shortest one")
+	flag.IntVar(&limit, "limit", netlist.DefaultExplainLimit, "most paths to find with -all")
+	flag.IntVar(&depth, "depth", 0, "longest path, in links, to consider with -all; 0 for the default")
+	flag.StringVar(&format, "format", "text", "output format: text or json")
+	flag.StringVar(&outpath, "out", "", "path to write the paths to; stdout otherwise")
+
+	flag.Parse()
+
+	log.SetFlags(0)
+
+	err := cfg.Load()
+	if err != nil {
+		log.Fatal(err)
+	}
+	cache := cfg.Cache
+
+	if cache == "" || top == "" || node == "" || term < 0 {
+		flag.PrintDefaults()
+		log.Fatal("Insufficient arguments")
+	}
+
+	opt := netlist.ExplainOptions{All: all, Limit: limit, Depth: depth}
+	switch port {
+	case "r":
+	case "w":
+		opt.Write = true
+	default:
+		log.Fatalf("Unknown port %q", port)
+	}
+	if format != "text" && format != "json" {
+		log.Fatalf("Unknown format %q", format)
+	}
+
+	session, err := cfg.Dial()
+	if err != nil {
+		log.Fatal(err)
+	}
+	err = meta.Check(session, cache, cfg.Migrate)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	rstore := rtl.NewStore(context.Background(), session, cache, false)
+	store := netlist.NewStore(context.Background(), session, rstore, cache, false)
+
+	g := store.Load(top).Graph()
+
+	id, found := g.ID(node)
+	if !found {
+		log.Fatalf("No node %q under %q", node, top)
+	}
+
+	paths := g.Explain(id, term, opt)
+	if len(paths) == 0 {
+		log.Printf("%s does not carry %s term %d", node, port, term)
+	} else {
+		log.Printf("%s term %d of %s: %d paths", port, term, node, len(paths))
+	}
+
+	var out io.Writer = os.Stdout
+	if outpath != "" {
+		file, err := os.Create(outpath)
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer file.Close()
+		out = file
+	}
+
+	x := explanation{node, port, term, [][]netlist.Hop{}}
+	for _, path := range paths {
+		x.Paths = append(x.Paths, g.Hops(path))
+	}
+
+	err = write(out, format, x)
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+// write prints each path hop by hop, from the ACE node the term came from to
+// the node being explained. Arrows point the way the signal goes, which for a
+// write-port term is against the way the term went.
+func write(out io.Writer, format string, x explanation) error {
+	if format == "json" {
+		enc := json.NewEncoder(out)
+		enc.SetIndent("", "  ")
+		return enc.Encode(x)
+	}
+
+	arrow := "->"
+	if x.Port == "w" {
+		arrow = "<-"
+	}
+
+	for i, hops := range x.Paths {
+		_, err := fmt.Fprintf(out, "path %d: %d links\n", i, len(hops)-1)
+		if err != nil {
+			return err
+		}
+		for j, h := range hops {
+			lead := "  "
+			if j > 0 {
+				lead = arrow
+			}
+			_, err := fmt.Fprintf(out, "  %s %s\t%s\t%s\tin %s (%s)\n", lead, h.Node,
+				h.Type, h.Kind, h.Netlist, h.Module)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
@@ -10,7 +10,8 @@ import (
 	"gopkg.in/mgo.v2"
 	"gopkg.in/mgo.v2/bson"
 
-	"sart/rtl"
+	"sart/config"
+	"sart/meta"
 )
 
 type SafeCounterMap struct {
@@ -38,7 +39,7 @@ func Print(prefix string, level int, top string) {
 
 	// log.Printf("%s%s", prefix, shorttop)
 
-	q := session.DB("sart").C(cache + "_insts").Find(bson.M{"module": top})
+	q := session.DB("").C(cache + "_insts").Find(bson.M{"module": top})
 
 	iter := q.Iter()
 
@@ -50,7 +51,7 @@ func Print(prefix string, level int, top string) {
 	}
 }
 
-var server, cache, top string
+var cache, top string
 var session *mgo.Session
 
 // var threads int
@@ -58,8 +59,8 @@ var session *mgo.Session
 var upto int
 
 func main() {
-	flag.StringVar(&server, "server", "localhost", "name of mongodb server")
-	flag.StringVar(&cache, "cache", "", "name of cache to save module info")
+	cfg := config.Flags(flag.CommandLine)
+
 	flag.StringVar(&top, "top", "", "name of top cell to explore")
 	flag.IntVar(&upto, "upto", 1, "depth to which hierarchy is sought. -1 for full hierarchy")
 
@@ -68,17 +69,25 @@ func main() {
 	log.SetFlags(log.Lshortfile)
 	log.SetFlags(0)
 
+	err := cfg.Load()
+	if err != nil {
+		log.Fatal(err)
+	}
+	cache = cfg.Cache
+
 	if cache == "" || top == "" {
 		flag.PrintDefaults()
 		log.Fatal("Insufficient arguments")
 	}
 
-	var err error
-	session, err = mgo.Dial(server)
+	session, err = cfg.Dial()
+	if err != nil {
+		log.Fatal(err)
+	}
+	err = meta.Check(session, cache, cfg.Migrate)
 	if err != nil {
 		log.Fatal(err)
 	}
-	rtl.InitMgo(session, cache, false)
 
 	log.SetOutput(os.Stdout)
 
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"log"
+	"os"
+	"sort"
+
+	"sart/config"
+	"sart/lint"
+	"sart/meta"
+	"sart/netlist"
+	"sart/rtl"
+)
+
+func main() {
+	var top, rulespath, outpath string
+
+	cfg := config.Flags(flag.CommandLine)
+
+	flag.StringVar(&top, "top", "", "name of top cell of the built netlist")
+	flag.StringVar(&rulespath, "rules", "", "path to json file with rule severities and waivers")
+	flag.StringVar(&outpath, "out", "", "path to write the json report to; stdout otherwise")
+
+	flag.Parse()
+
+	log.SetFlags(0)
+
+	err := cfg.Load()
+	if err != nil {
+		log.Fatal(err)
+	}
+	cache := cfg.Cache
+
+	if cache == "" || top == "" {
+		flag.PrintDefaults()
+		log.Fatal("Insufficient arguments")
+	}
+
+	rules := lint.Default()
+	if rulespath != "" {
+		file, err := os.Open(rulespath)
+		if err != nil {
+			log.Fatal(err)
+		}
+		rules, err = lint.LoadRules(file)
+		file.Close()
+		if err != nil {
+			log.Fatalf("%s: %v", rulespath, err)
+		}
+	}
+
+	session, err := cfg.Dial()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	err = meta.Check(session, cache, cfg.Migrate)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	rstore := rtl.NewStore(context.Background(), session, cache, false)
+	modules := rtl.NewModuleCache(rstore, cfg.ModCache<<20)
+	store := netlist.NewStore(context.Background(), session, modules, cache, false)
+
+	// Lint every module under top once, in a stable order
+	report := lint.NewReport(top)
+	for _, mname := range hierarchy(top, modules) {
+		n := store.Template(mname)
+		report.Add(rules.Module(n, modules.LoadModule(mname), modules))
+	}
+
+	err = write(report, outpath)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	log.Printf("Linted %d modules: %v, %d waived", report.Modules, report.Counts,
+		report.Waived)
+
+	// Unwaived errors fail the run so that lint can gate a flow
+	if report.Errors() > 0 {
+		os.Exit(1)
+	}
+}
+
+// write writes the report to the file at path, or to stdout if path is empty.
+func write(report *lint.Report, path string) error {
+	if path == "" {
+		return report.Write(os.Stdout)
+	}
+
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	err = report.Write(file)
+	if cerr := file.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// hierarchy returns the names of top and every module it instantiates, at any
+// depth, sorted.
+func hierarchy(top string, modules rtl.ModuleLoader) (names []string) {
+	seen := map[string]bool{top: true}
+	queue := []string{top}
+
+	for len(queue) > 0 {
+		mname := queue[0]
+		queue = queue[1:]
+		names = append(names, mname)
+
+		for _, inst := range modules.LoadModule(mname).Insts {
+			if !inst.IsPrim && !seen[inst.Type] {
+				seen[inst.Type] = true
+				queue = append(queue, inst.Type)
+			}
+		}
+	}
+
+	sort.Strings(names)
+	return
+}
@@ -1,30 +1,37 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"io/ioutil"
 	"log"
 	"os"
+	"os/signal"
 	"strings"
 	"sync"
+	"syscall"
 
 	"gopkg.in/mgo.v2"
 	"gopkg.in/mgo.v2/bson"
 
 	// "sart/parse"
+	"sart/bulk"
+	"sart/config"
+	"sart/meta"
+	"sart/netlist"
 	"sart/parsesp"
 	"sart/rtl"
 	"sart/set"
 )
 
-func parseWorker(wg *sync.WaitGroup, jobs <-chan string) {
+func parseWorker(wg *sync.WaitGroup, jobs <-chan string, st *rtl.Store) {
 	for path := range jobs {
 		file, err := os.Open(path)
 		if err != nil {
 			log.Fatal(err)
 		}
 
-		parsesp.New(path, file)
+		parsesp.New(st, path, file)
 
 		file.Close()
 	}
@@ -33,8 +40,8 @@ func parseWorker(wg *sync.WaitGroup, jobs <-chan string) {
 
 func updateWorker(wg *sync.WaitGroup, jobs <-chan string) {
 	sess := session.Copy()
-	inst := sess.DB("sart").C(cache + "_insts")
-	conn := sess.DB("sart").C(cache + "_conns")
+	inst := sess.DB("").C(cache + "_insts")
+	conn := sess.DB("").C(cache + "_conns")
 	for itype := range jobs {
 		_, err := inst.UpdateAll(
 			bson.M{"type": itype}, // Selector
@@ -63,7 +70,7 @@ type connTypeUpdateJob struct {
 
 func connTypeUpdateWorker(wg *sync.WaitGroup, jobs <-chan connTypeUpdateJob) {
 	sess := session.Copy()
-	conn := sess.DB("sart").C(cache + "_conns")
+	conn := sess.DB("").C(cache + "_conns")
 	for job := range jobs {
 		_, err := conn.UpdateAll(
 			bson.M{"itype": job.Module, "pos": job.Pos}, // Selector
@@ -78,7 +85,7 @@ func connTypeUpdateWorker(wg *sync.WaitGroup, jobs <-chan connTypeUpdateJob) {
 
 func prmpUpdateWorker(wg *sync.WaitGroup, jobs <-chan string) {
 	sess := session.Copy()
-	inst := sess.DB("sart").C(cache + "_insts")
+	inst := sess.DB("").C(cache + "_insts")
 	for job := range jobs {
 		_, err := inst.UpdateAll(
 			bson.M{"module": job}, // Selector
@@ -98,23 +105,38 @@ func prmpUpdateWorker(wg *sync.WaitGroup, jobs <-chan string) {
 	wg.Done()
 }
 
+// checkpoint stops the run if it has been interrupted. The cache stays marked
+// incomplete.
+func checkpoint(ctx context.Context) {
+	if ctx.Err() != nil {
+		log.Fatal("Load stopped: ", ctx.Err())
+	}
+}
+
 var session *mgo.Session
 var cache string
 
 func main() {
-	var path, server string
+	var path string
 	var threads int
 	var noparse, qonly bool
 
+	cfg := config.Flags(flag.CommandLine)
+
 	flag.StringVar(&path, "path", "", "path to folder with netlist files")
-	flag.StringVar(&server, "server", "localhost", "name of mongodb server")
-	flag.StringVar(&cache, "cache", "", "name of cache to save module info")
 	flag.IntVar(&threads, "threads", 2, "number of parallel threads to spawn")
 	flag.BoolVar(&noparse, "noparse", false, "include to skip parse step")
 	flag.BoolVar(&qonly, "qismatonly", false, "include to skip sart steps")
 
 	flag.Parse()
 
+	err := cfg.Load()
+	if err != nil {
+		log.Fatal(err)
+	}
+	bulk.Workers, bulk.BatchSize = cfg.Workers, cfg.Batch
+	cache = cfg.Cache
+
 	if path == "" || cache == "" {
 		flag.PrintDefaults()
 		log.Fatal("Insufficient arguments")
@@ -124,13 +146,34 @@ func main() {
 
 	// Connect to MongoDB //////////////////////////////////////////////////////
 
-	var err error
+	session, err = cfg.Dial()
+	if err != nil {
+		log.Fatal(err)
+	}
+	// An interrupt stops the mongo workers cleanly and leaves the cache
+	// marked incomplete.
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	store := rtl.NewStore(ctx, session, cache, !noparse)
+
+	// A cache parsed from scratch has the current layout once the netlist
+	// built from its old modules is dropped. One that is reused has to be
+	// checked, and migrated if asked to.
+	if noparse {
+		err = meta.Check(session, cache, cfg.Migrate)
+	} else {
+		netlist.NewStore(ctx, session, store, cache, true).Close()
+		err = meta.Stamp(session, cache)
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
 
-	session, err = mgo.Dial(server)
+	err = meta.Begin(session, cache, "load")
 	if err != nil {
 		log.Fatal(err)
 	}
-	rtl.InitMgo(session, cache, !noparse)
 
 	log.SetOutput(os.Stdout)
 
@@ -148,7 +191,7 @@ func main() {
 		parsejobs := make(chan string, 100)
 
 		for i := 0; i < threads; i++ {
-			go parseWorker(&parsewg, parsejobs)
+			go parseWorker(&parsewg, parsejobs, store)
 			parsewg.Add(1)
 		}
 
@@ -157,6 +200,10 @@ func main() {
 		count = 0
 		total = len(files)
 		for _, file := range files {
+			if ctx.Err() != nil {
+				break
+			}
+
 			filename := file.Name()
 			count++
 
@@ -174,11 +221,19 @@ func main() {
 		close(parsejobs)
 		parsewg.Wait()
 
-		rtl.DoneMgo() // Signal no more mongo insert jobs
-		rtl.WaitMgo() // Wait for all insert jobs to complete
+		store.Done() // Signal no more mongo insert jobs
+
+		// Wait for all insert jobs to complete
+		if err := store.Wait(); err != nil {
+			log.Fatal("Load stopped: ", err)
+		}
 	}
 
 	if qonly {
+		err = meta.Finish(session, cache, "load")
+		if err != nil {
+			log.Fatal(err)
+		}
 		return
 	}
 
@@ -189,12 +244,13 @@ func main() {
 	// module definition was not found as primitives.
 	////////////////////////////////////////////////////////////////////////////
 
+	checkpoint(ctx)
 	log.Println("Marking primitives..")
 
 	// In the instance collection, a list of all distinct types is the universe
 	// of everything that has been instantiated at least once.
 	var allmodules []interface{}
-	err = session.DB("sart").C(cache+"_insts").Find(nil).Distinct("type", &allmodules)
+	err = session.DB("").C(cache+"_insts").Find(nil).Distinct("type", &allmodules)
 	if err != nil {
 		log.Fatal(err)
 	}
@@ -202,7 +258,7 @@ func main() {
 	// These are modules that have instantiations inside them. I.e there is a
 	// SUBCKT definition
 	var instmodules []interface{}
-	err = session.DB("sart").C(cache+"_insts").Find(nil).Distinct("module", &instmodules)
+	err = session.DB("").C(cache+"_insts").Find(nil).Distinct("module", &instmodules)
 	if err != nil {
 		log.Fatal(err)
 	}
@@ -238,11 +294,12 @@ func main() {
 
 	////////////////////////////////////////////////////////////////////////////
 
+	checkpoint(ctx)
 	log.Println("Marking primitive parents..")
 
 	// These are modules that have 'X' instantiations inside them.
 	var primparents []interface{}
-	err = session.DB("sart").C(cache+"_insts").Find(bson.M{"name": bson.RegEx{"^X", ""}}).Distinct("module", &primparents)
+	err = session.DB("").C(cache+"_insts").Find(bson.M{"name": bson.RegEx{"^X", ""}}).Distinct("module", &primparents)
 	if err != nil {
 		log.Fatal(err)
 	}
@@ -274,9 +331,10 @@ func main() {
 
 	////////////////////////////////////////////////////////////////////////////
 
+	checkpoint(ctx)
 	log.Println("Marking sequentials..")
 
-	clog, err := session.DB("sart").C(cache+"_insts").UpdateAll(
+	clog, err := session.DB("").C(cache+"_insts").UpdateAll(
 		// everything that starts with ec0f or ec0l
 		bson.M{"type": bson.RegEx{"ec0[fl]", ""}}, // Selector interface
 		bson.M{"$set": bson.M{"isseq": true}},     // Updater  interface
@@ -308,9 +366,10 @@ func main() {
 
 	////////////////////////////////////////////////////////////////////////////
 
+	checkpoint(ctx)
 	log.Println("Marking conn outputs and inouts..")
 
-	findq := session.DB("sart").C(cache + "_ports").Find(
+	findq := session.DB("").C(cache + "_ports").Find(
 		bson.M{
 			"$or": []bson.M{
 				bson.M{"type": "OUTPUT"},
@@ -362,7 +421,7 @@ func main() {
 	log.Printf("Done. Updated %d outputs and %d inouts", outcount, inocount)
 
 	sess := session.Copy()
-	conn := sess.DB("sart").C(cache + "_conns")
+	conn := sess.DB("").C(cache + "_conns")
 
 	xtors := []string{
 		"n",
@@ -383,4 +442,9 @@ func main() {
 		}
 		log.Printf("Updated %d outputs in prim %q", ci.Updated, xtor)
 	}
+
+	err = meta.Finish(session, cache, "load")
+	if err != nil {
+		log.Fatal(err)
+	}
 }
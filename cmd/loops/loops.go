@@ -0,0 +1,116 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+
+	"sart/config"
+	"sart/meta"
+	"sart/netlist"
+	"sart/rtl"
+)
+
+// entry is how a loop is exported as json
+type entry struct {
+	Size    int      `json:"size"`
+	Netlist string   `json:"netlist"`
+	Module  string   `json:"module"`
+	Nodes   []string `json:"nodes"`
+}
+
+func main() {
+	var top, format, outpath string
+	var min int
+
+	cfg := config.Flags(flag.CommandLine)
+
+	flag.StringVar(&top, "top", "", "name of top cell of the built netlist")
+	flag.IntVar(&min, "min", 1, "smallest loop, in nodes, to report")
+	flag.StringVar(&format, "format", "text", "output format: text or json")
+	flag.StringVar(&outpath, "out", "", "path to write the loops to; stdout otherwise")
+
+	flag.Parse()
+
+	log.SetFlags(0)
+
+	err := cfg.Load()
+	if err != nil {
+		log.Fatal(err)
+	}
+	cache := cfg.Cache
+
+	if cache == "" || top == "" {
+		flag.PrintDefaults()
+		log.Fatal("Insufficient arguments")
+	}
+	if format != "text" && format != "json" {
+		log.Fatalf("Unknown format %q", format)
+	}
+
+	session, err := cfg.Dial()
+	if err != nil {
+		log.Fatal(err)
+	}
+	err = meta.Check(session, cache, cfg.Migrate)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	rstore := rtl.NewStore(context.Background(), session, cache, false)
+	store := netlist.NewStore(context.Background(), session, rstore, cache, false)
+
+	g := store.Load(top).Graph()
+
+	entries := []entry{}
+	nodes := 0
+	for _, loop := range g.Loops() {
+		if len(loop.Nodes) < min {
+			continue
+		}
+		e := entry{len(loop.Nodes), loop.Netlist, loop.Module, nil}
+		for _, id := range loop.Nodes {
+			e.Nodes = append(e.Nodes, g.Name(id))
+		}
+		entries = append(entries, e)
+		nodes += e.Size
+	}
+	log.Printf("%d combinational loops holding %d of %d nodes", len(entries), nodes, g.Len())
+
+	var out io.Writer = os.Stdout
+	if outpath != "" {
+		file, err := os.Create(outpath)
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer file.Close()
+		out = file
+	}
+
+	err = write(out, format, entries)
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+func write(out io.Writer, format string, entries []entry) error {
+	if format == "json" {
+		enc := json.NewEncoder(out)
+		enc.SetIndent("", "  ")
+		return enc.Encode(entries)
+	}
+
+	for _, e := range entries {
+		_, err := fmt.Fprintf(out, "%d\t%s (%s)\t%s\n", e.Size, e.Netlist, e.Module,
+			strings.Join(e.Nodes, " "))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
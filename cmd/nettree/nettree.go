@@ -1,22 +1,24 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"log"
 	"os"
 	"sart/ace"
+	"sart/bulk"
+	"sart/config"
+	"sart/meta"
 	"sart/netlist"
 	"sart/rtl"
 	"time"
-
-	mgo "gopkg.in/mgo.v2"
 )
 
 func NetTree(prefix string, level int, n *netlist.Netlist) {
 	if level > 2 {
 		return
 	}
-	log.Printf("%s%s %v", prefix, n.Shortname(), n.Stats(acestructs, 0, 0))
+	log.Printf("%s%s %v", prefix, n.Shortname(), n.Stats(acestructs))
 	for s := range n.Subnets {
 		NetTree(prefix+"|   ", level+1, n.Subnets[s])
 	}
@@ -25,18 +27,24 @@ func NetTree(prefix string, level int, n *netlist.Netlist) {
 var acestructs []ace.AceStruct
 
 func main() {
-	var cache, top, server, acepath string
-	var session *mgo.Session
+	var top, acepath string
+
+	cfg := config.Flags(flag.CommandLine)
 
-	flag.StringVar(&cache, "cache", "", "name of cache from which to fetch netlist")
 	flag.StringVar(&top, "top", "", "name of top cell to start traversing")
-	flag.StringVar(&server, "server", "localhost", "name of mongodb server")
 	flag.StringVar(&acepath, "ace", "", "path to ace structs file (req.)")
 
 	flag.Parse()
 
 	log.SetFlags(log.Lshortfile)
 
+	err := cfg.Load()
+	if err != nil {
+		log.Fatal(err)
+	}
+	bulk.Workers, bulk.BatchSize = cfg.Workers, cfg.Batch
+	cache := cfg.Cache
+
 	if cache == "" || top == "" || acepath == "" {
 		flag.PrintDefaults()
 		log.Fatal("Insufficient arguments")
@@ -50,18 +58,21 @@ func main() {
 	acestructs = ace.Load(file)
 	log.Printf("Found %d ACE structs.", len(acestructs))
 
-	session, err = mgo.Dial(server)
+	session, err := cfg.Dial()
+	if err != nil {
+		log.Fatal(err)
+	}
+	err = meta.Check(session, cache, cfg.Migrate)
 	if err != nil {
 		log.Fatal(err)
 	}
-	rtl.InitMgo(session, cache, false)
 
-	netlist.InitMgo(session, cache, false)
+	rstore := rtl.NewStore(context.Background(), session, cache, false)
+	store := netlist.NewStore(context.Background(), session, rstore, cache, false)
 
-	n := netlist.NewNetlist(top)
 	log.Printf("Loading netlist %s..", top)
 	start := time.Now()
-	n.Load()
+	n := store.Load(top)
 	log.Println("Done. Time elapsed:", time.Since(start))
 
 	log.SetOutput(os.Stdout)
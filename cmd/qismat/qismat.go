@@ -1,15 +1,19 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"fmt"
 	"io"
 	"log"
 	"os"
+	"sart/config"
+	"sart/meta"
+	"sart/rtl"
 	"sart/typespecs"
+	"sort"
 
 	"gopkg.in/mgo.v2"
-	"gopkg.in/mgo.v2/bson"
 )
 
 type Instance struct {
@@ -139,22 +143,28 @@ func Load(prefix, name string) *Instance {
 		Children: []*Instance{},
 	}
 
-	iter := session.DB("sart").C(cache + "_insts").Find(bson.M{"module": name}).Iter()
+	m := modules.LoadModule(name)
 
-	var i bson.M
+	// Visit instances by name so that children are reported in a stable order
+	var inames []string
+	for iname := range m.Insts {
+		inames = append(inames, iname)
+	}
+	sort.Strings(inames)
 
-	for iter.Next(&i) {
-		itype := i["type"].(string)
+	for _, iname := range inames {
+		i := m.Insts[iname]
+		itype := i.Type
 		switch ts.Match(itype) {
 		case "Reg":
 			inst.AddReg(itype)
 		case "Flop":
-			if !i["isseq"].(bool) {
+			if !i.IsSeq {
 				log.Printf("Classified as flop: %s", itype)
 			}
 			inst.AddSeq(itype)
 		case "Latch":
-			if !i["isseq"].(bool) {
+			if !i.IsSeq {
 				log.Printf("Classified as latch: %s", itype)
 			}
 			inst.AddSeq(itype)
@@ -165,8 +175,8 @@ func Load(prefix, name string) *Instance {
 			// log.Println("Cma:", itype)
 			inst.AddCma(itype)
 		default:
-			if i["isprim"].(bool) {
-				log.Println("EBB?:", itype, prefix, i["name"])
+			if i.IsPrim {
+				log.Println("EBB?:", itype, prefix, i.Name)
 				break
 			}
 
@@ -175,17 +185,17 @@ func Load(prefix, name string) *Instance {
 				inst.AddChild(c)
 			}
 		}
-		// if i["isseq"].(bool) {
+		// if i.IsSeq {
 		// 	inst.AddSeq(itype)
 		// } else if ts.Match(itype) == "EBB" {
 		// 	inst.AddCma(itype)
-		// } else if i["isprim"].(bool) && ts.Match(itype) == "Comb" {
+		// } else if i.IsPrim && ts.Match(itype) == "Comb" {
 		// 	inst.AddCom(itype)
-		// } else if i["isprim"].(bool) && !strings.HasPrefix(itype, "ec0") {
+		// } else if i.IsPrim && !strings.HasPrefix(itype, "ec0") {
 		// 	// Primitives have no children. If name does not start with ec0, it
 		// 	// means that these don't have netlists elaborated. These are most
 		// 	// likely full-custom EBBs.
-		// 	log.Println("EBB?:", itype, prefix, i["name"])
+		// 	log.Println("EBB?:", itype, prefix, i.Name)
 		// } else {
 		//	c := Load(prefix, itype)
 		//	if c != nil {
@@ -206,13 +216,17 @@ func Load(prefix, name string) *Instance {
 var session *mgo.Session
 var cache string
 
+// modules hands out module definitions. Load visits every instance, so the
+// definitions of cells used many times are kept in memory.
+var modules *rtl.ModuleCache
+
 var ts typespecs.TypeSpecs
 
 func main() {
-	var server, top, tspec string
+	var top, tspec string
+
+	cfg := config.Flags(flag.CommandLine)
 
-	flag.StringVar(&server, "server", "localhost", "name of mongodb server")
-	flag.StringVar(&cache, "cache", "", "name of cache to save module info")
 	flag.StringVar(&top, "top", "", "name of instantiated top cell")
 	flag.StringVar(&tspec, "tspec", "", "path to json file with type specifications")
 
@@ -220,6 +234,12 @@ func main() {
 
 	log.SetFlags(log.Lshortfile)
 
+	err := cfg.Load()
+	if err != nil {
+		log.Fatal(err)
+	}
+	cache = cfg.Cache
+
 	if cache == "" || top == "" {
 		flag.PrintDefaults()
 		log.Fatal("Insufficient arguments")
@@ -236,14 +256,21 @@ func main() {
 
 	// Connect to MongoDB //////////////////////////////////////////////////////
 
-	var err error
+	session, err = cfg.Dial()
+	if err != nil {
+		log.Fatal(err)
+	}
 
-	session, err = mgo.Dial(server)
+	err = meta.Check(session, cache, cfg.Migrate)
 	if err != nil {
 		log.Fatal(err)
 	}
 
+	store := rtl.NewStore(context.Background(), session, cache, false)
+	modules = rtl.NewModuleCache(store, cfg.ModCache<<20)
+
 	inst := Load("", top)
+	log.Println("Module cache:", modules.Stats())
 	if inst != nil {
 		log.SetFlags(0)
 
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+
+	"sart/ace"
+	"sart/bitfield"
+	"sart/config"
+	"sart/meta"
+	"sart/netlist"
+	"sart/rtl"
+)
+
+func main() {
+	var top, acepath string
+
+	cfg := config.Flags(flag.CommandLine)
+
+	flag.StringVar(&top, "top", "", "name of top cell of the built netlist")
+	flag.StringVar(&acepath, "ace", "", "path to ace structs file, to describe ACE terms")
+
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: %s [flags] pattern...\n\n", os.Args[0])
+		fmt.Fprintln(os.Stderr, "Patterns are full node names, starting with the top cell. A component")
+		fmt.Fprintln(os.Stderr, "may be a shell pattern, ~regex, or ** for any number of levels.")
+		fmt.Fprintln(os.Stderr)
+		flag.PrintDefaults()
+	}
+
+	flag.Parse()
+
+	log.SetFlags(0)
+
+	err := cfg.Load()
+	if err != nil {
+		log.Fatal(err)
+	}
+	cache := cfg.Cache
+
+	if cache == "" || top == "" || flag.NArg() == 0 {
+		flag.Usage()
+		log.Fatal("Insufficient arguments")
+	}
+
+	var acestructs []ace.AceStruct
+	if acepath != "" {
+		file, err := os.Open(acepath)
+		if err != nil {
+			log.Fatal(err)
+		}
+		acestructs = ace.Load(file)
+		file.Close()
+	}
+
+	session, err := cfg.Dial()
+	if err != nil {
+		log.Fatal(err)
+	}
+	err = meta.Check(session, cache, cfg.Migrate)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	rstore := rtl.NewStore(context.Background(), session, cache, false)
+	store := netlist.NewStore(context.Background(), session, rstore, cache, false)
+
+	n := store.Load(top)
+
+	for _, pattern := range flag.Args() {
+		matches, err := n.Resolve(pattern)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if len(matches) == 0 {
+			log.Printf("%s: no match", pattern)
+			continue
+		}
+		for _, m := range matches {
+			fmt.Println(describe(m, acestructs))
+		}
+	}
+}
+
+// describe returns the node of match m on one line, followed by a line for
+// each of its ACE terms.
+func describe(m netlist.Match, acestructs []ace.AceStruct) string {
+	node := m.Node
+
+	var kind []string
+	switch {
+	case node.IsPrim:
+		kind = append(kind, "prim")
+	case node.IsPort:
+		kind = append(kind, "port")
+	case node.IsWire:
+		kind = append(kind, "wire")
+	}
+	if node.IsSeqn {
+		kind = append(kind, "seqn")
+	}
+	if node.IsAce {
+		kind = append(kind, "ace")
+	}
+
+	str := fmt.Sprintf("%s %s [%s] in %s (%s)", node.Fullname(), node.Type,
+		strings.Join(kind, " "), m.Netlist.Name, m.Netlist.Type)
+	str += terms("r", node.RpAce, acestructs)
+	str += terms("w", node.WpAce, acestructs)
+	return str
+}
+
+func terms(port string, bf *bitfield.BitField, acestructs []ace.AceStruct) (str string) {
+	for _, pos := range bf.Test() {
+		str += fmt.Sprintf("\n    %s %d", port, pos)
+		if pos < len(acestructs) {
+			a := acestructs[pos]
+			if a.Selector.Inst != "" {
+				str += fmt.Sprintf(" inst:%q", a.Selector.Inst)
+			} else {
+				str += fmt.Sprintf(" module:%q name:%q", a.Selector.Module, a.Selector.Name)
+			}
+			str += fmt.Sprintf(" rpavf:%g wpavf:%g", a.Rpavf, a.Wpavf)
+		}
+	}
+	return
+}
@@ -1,35 +1,47 @@
 package main
 
 import (
+	"context"
+	"encoding/json"
 	"flag"
 	"io"
 	"log"
 	"os"
+	"os/signal"
+	"syscall"
 	"time"
 
 	"sart/ace"
+	"sart/bulk"
+	"sart/config"
+	"sart/meta"
 	"sart/netlist"
 	"sart/rtl"
-
-	"gopkg.in/mgo.v2"
 )
 
 func main() {
-	var cache, top, acepath, logp, server string
+	var top, acepath, logp, clockpath, clockreport, bbpath string
 
-	var debug, nobuild, nowalk bool
+	var debug, nobuild, nowalk, seqwalk, condense, orderlog, tolerant bool
 
 	// Command line switches ///////////////////////////////////////////////////
 
-	flag.StringVar(&cache, "cache", "", "name of cache from which to fetch module info. (req.)")
+	cfg := config.Flags(flag.CommandLine)
+
 	flag.StringVar(&top, "top", "", "name of topcell on which to run sart")
 	flag.StringVar(&acepath, "ace", "", "path to ace structs file (req.)")
 	flag.StringVar(&logp, "log", "", "path to file where log messages should be redirected")
-	flag.StringVar(&server, "server", "localhost", "name of mongodb server")
+	flag.StringVar(&clockpath, "clocks", "", "path to clock spec file, to keep walks off clock and reset networks")
+	flag.StringVar(&clockreport, "clockreport", "", "path to write the clock and reset nodes found to, as json")
+	flag.StringVar(&bbpath, "blackbox", "", "path to black box spec file, to build listed modules from port transfer models")
 
 	flag.BoolVar(&debug, "debug", false, "enable debug mode")
 	flag.BoolVar(&nobuild, "nobuild", false, "use to skip netlist build step")
 	flag.BoolVar(&nowalk, "nowalk", false, "use to skip netlist walk steps")
+	flag.BoolVar(&seqwalk, "seqwalk", false, "walk between sequential and ACE nodes first, then fill in the combinational logic")
+	flag.BoolVar(&condense, "condense", false, "walk with each combinational loop condensed into one node")
+	flag.BoolVar(&orderlog, "orderlog", false, "log built modules in depth-first order")
+	flag.BoolVar(&tolerant, "tolerant", false, "carry on building past instances with mismatched ports")
 
 	flag.Parse()
 
@@ -42,6 +54,13 @@ func main() {
 
 	// Check for minimum arguments /////////////////////////////////////////////
 
+	err := cfg.Load()
+	if err != nil {
+		log.Fatal(err)
+	}
+	bulk.Workers, bulk.BatchSize = cfg.Workers, cfg.Batch
+	cache := cfg.Cache
+
 	if cache == "" || acepath == "" {
 		flag.PrintDefaults()
 		log.Fatal("Insufficient arguments")
@@ -49,12 +68,23 @@ func main() {
 
 	// Connect to mongo and initialize package rtl's mongo connection //////////
 
-	session, err := mgo.Dial(server)
+	session, err := cfg.Dial()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	err = meta.Check(session, cache, cfg.Migrate)
 	if err != nil {
 		log.Fatal(err)
 	}
 
-	rtl.InitMgo(session, cache, false)
+	// An interrupt stops the mongo workers cleanly. Whatever stage was running
+	// leaves the cache marked incomplete.
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	rstore := rtl.NewStore(ctx, session, cache, false)
+	modules := rtl.NewModuleCache(rstore, cfg.ModCache<<20)
 
 	// If a log file is specified redirect log messages to it; stdout otherwise
 
@@ -80,40 +110,93 @@ func main() {
 	acestructs := ace.Load(file)
 	log.Printf("Found %d ACE structs.", len(acestructs))
 
-	// Build netlist if needed, otherwise simply initialize package netlist's
-	// mongo connection so that a netlist can be loaded
+	// Load black box spec /////////////////////////////////////////////////////
+
+	// Arcs of black boxes that put out a fixed ACE value are marked through
+	// ACE structs of their own, which have to be in place before building as
+	// they size the bitfields.
+	var blackboxes netlist.BlackBoxSpec
+	if bbpath != "" {
+		file, err := os.Open(bbpath)
+		if err != nil {
+			log.Fatal(err)
+		}
+		blackboxes, err = netlist.LoadBlackBoxSpec(file)
+		file.Close()
+		if err != nil {
+			log.Fatal(bbpath, ": ", err)
+		}
+
+		fixed := blackboxes.AceStructs()
+		acestructs = append(acestructs, fixed...)
+		log.Printf("Found %d black boxes, adding %d fixed ACE structs.", len(blackboxes), len(fixed))
+	}
+
+	// Build netlist if needed, otherwise simply open the netlist store so
+	// that a netlist can be loaded
 
 	var start time.Time
+	var store *netlist.Store
 
 	if nobuild {
-		netlist.InitMgo(session, cache, false)
+		store = netlist.NewStore(ctx, session, modules, cache, false)
 	} else {
-		netlist.InitMgo(session, cache, true)
+		store = netlist.NewStore(ctx, session, modules, cache, true)
+		store.OrderedLog = orderlog
+		store.Tolerant = tolerant
+		store.BlackBoxes = blackboxes
+
+		err = meta.Begin(session, cache, "build")
+		if err != nil {
+			log.Fatal(err)
+		}
 
 		log.Println("Building netlist..")
 
 		start = time.Now()
-		nl := netlist.New("", top, top, len(acestructs), 0)
+		nl := store.New(top)
 		log.Println(nl)
 
-		netlist.DoneMgo()
-		netlist.WaitMgo()
+		store.Done()
+		err = store.Wait()
+		if err != nil {
+			log.Fatal("Netlist build stopped: ", err)
+		}
 		log.Println("Netlist built. Elapsed:", time.Since(start))
+		log.Println("Module cache:", modules.Stats())
+
+		if ms := store.Mismatches(); len(ms) > 0 {
+			log.Printf("%d module pairs with mismatched ports:", len(ms))
+			err = netlist.WriteMismatches(logw, ms)
+			if err != nil {
+				log.Fatal(err)
+			}
+		}
+
+		err = meta.Finish(session, cache, "build")
+		if err != nil {
+			log.Fatal(err)
+		}
 	}
 
-	// By this point, the netlist has been built and saved to mongo. Next ACE
-	// nodes need to be marked before starting walks. This has to be done
-	// before loading the netlist from mongo. This is a necessary step unless
+	// By this point, the netlist has been built and saved to mongo. Next the
+	// ACE state of the last walks needs to be reset and ACE nodes marked
+	// before starting walks. Nodes are marked as the netlist is loaded, so
+	// this has to be done before loading. This is a necessary step unless
 	// walks are not needed, i.e. when -nowalk is specified.
 
-	// Reset nodes and mark ACE nodes in mongo /////////////////////////////////
+	// Reset nodes and mark ACE nodes //////////////////////////////////////////
 
 	if !nowalk {
-		log.Println("Reseting nodes and marking ACE nodes..")
+		err = meta.Begin(session, cache, "walk")
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		log.Println("Reseting nodes..")
 		start = time.Now()
-		r, m := netlist.MarkAceNodes(acestructs)
-		log.Printf("%d nodes reset and %d ACE nodes marked. Elapsed: %v", r, m,
-			time.Since(start))
+		r := store.MarkAceNodes(acestructs)
+		log.Printf("%d nodes reset. Elapsed: %v", r, time.Since(start))
 	}
 
 	// Load netlist from mongo /////////////////////////////////////////////////
@@ -121,33 +204,102 @@ func main() {
 	log.Println("Loading netlist..")
 
 	start = time.Now()
-	n := netlist.NewNetlist(top)
-	n.Load()
+	n := store.Load(top)
 	log.Println("Netlist loaded. Elapsed:", time.Since(start))
 	log.Println(n)
 
+	// Mark clock and reset networks ///////////////////////////////////////////
+
+	if !nowalk && clockpath != "" {
+		file, err := os.Open(clockpath)
+		if err != nil {
+			log.Fatal(err)
+		}
+		spec, err := netlist.LoadClockSpec(file)
+		file.Close()
+		if err != nil {
+			log.Fatal(clockpath, ": ", err)
+		}
+
+		log.Println("Marking clock and reset networks..")
+		start = time.Now()
+		excluded := n.Graph().MarkClocks(spec, modules)
+		log.Printf("%d clock and reset nodes excluded from walks. Elapsed: %v",
+			len(excluded), time.Since(start))
+
+		if clockreport != "" {
+			file, err := os.Create(clockreport)
+			if err != nil {
+				log.Fatal(err)
+			}
+			if excluded == nil {
+				excluded = []netlist.Excluded{}
+			}
+			enc := json.NewEncoder(file)
+			enc.SetIndent("", "  ")
+			err = enc.Encode(excluded)
+			if err != nil {
+				log.Fatal(err)
+			}
+			file.Close()
+		}
+	}
+
 	// Start walks /////////////////////////////////////////////////////////////
 
 	if !nowalk {
 		log.Println("Starting walks..")
 		start = time.Now()
-		changed := n.Walk()
 
+		walk := n.Walk
+		switch {
+		case seqwalk:
+			s := netlist.NewSeqGraph(n.Graph())
+			log.Printf("Sequential graph: %d of %d nodes. Elapsed: %v", s.Len(),
+				n.Graph().Len(), time.Since(start))
+			walk = s.Walk
+		case condense:
+			loops := n.Graph().Loops()
+			c := n.Graph().Condense(loops)
+			log.Printf("Condensed %d combinational loops: %d of %d nodes. Elapsed: %v",
+				len(loops), c.Len(), n.Graph().Len(), time.Since(start))
+			walk = c.Walk
+		}
+
+		changed := walk()
 		for changed > 0 {
-			changed = n.Walk()
+			if ctx.Err() != nil {
+				log.Fatal("Walks stopped: ", ctx.Err())
+			}
+			changed = walk()
+		}
+		if seqwalk {
+			// The sequential walks leave the combinational logic in between
+			// untouched. It gets its terms from the nodes that carry them.
+			filled := n.Graph().Fill()
+			log.Printf("Combinational logic filled in: %d changes", filled)
 		}
 		log.Println("Walks complete. Elapsed:", time.Since(start))
+		log.Printf("%d ACE nodes marked.", store.Marked())
 
 		// Update mongo with latest ACE info ///////////////////////////////////
 
 		log.Println("Updating nodes..")
 		start = time.Now()
-		updated := n.Update()
-		netlist.UpdateWait()
+		updated := store.Update(n)
+		err = store.UpdateWait()
+		if err != nil {
+			log.Fatal("Node updates stopped: ", err)
+		}
 		log.Printf("Nodes updated: %d. Elapsed: %v", updated, time.Since(start))
+
+		err = meta.Finish(session, cache, "walk")
+		if err != nil {
+			log.Fatal(err)
+		}
 	}
 
 	// Print stats and quit ////////////////////////////////////////////////////
 
-	log.Println(n.Stats(acestructs, 0, 0))
+	log.Println(n.Stats(acestructs))
 }
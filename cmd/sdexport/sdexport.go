@@ -2,17 +2,18 @@ package main
 
 import (
 	"bufio"
+	"context"
 	"flag"
 	"fmt"
 	"io"
 	"log"
 	"os"
 	"regexp"
+	"sart/config"
+	"sart/meta"
 	"sart/rtl"
 	"strings"
 	"time"
-
-	mgo "gopkg.in/mgo.v2"
 )
 
 var uprefix = "tnt__a0_18ww02d6__"
@@ -166,8 +167,7 @@ func Count(m *rtl.Module, prefix string) {
 			x.Combs[inst.Type]++
 
 		case "Unknown":
-			i := rtl.NewModule(inst.Type)
-			i.Load()
+			i := modules.LoadModule(inst.Type)
 			Count(i, prefix+"|   ")
 
 		default:
@@ -181,17 +181,27 @@ func Count(m *rtl.Module, prefix string) {
 
 var SEQ, REG, COM io.Writer
 
+// modules hands out module definitions. Count asks for the same cell types
+// over and over, so they are kept in memory.
+var modules *rtl.ModuleCache
+
 func main() {
-	var server, cache, top, bbpath, tspec string
+	var top, bbpath, tspec string
+
+	cfg := config.Flags(flag.CommandLine)
 
 	flag.StringVar(&top, "top", "", "name of topcell to report")
-	flag.StringVar(&cache, "cache", "", "name of mongo cache to retrieve module info from")
-	flag.StringVar(&server, "server", "localhost", "name of mongo server (optional)")
 	flag.StringVar(&bbpath, "bb", "", "name of file with list of names to blackbox")
 	flag.StringVar(&tspec, "tspec", "", "path to json file with type specifications")
 
 	flag.Parse()
 
+	err := cfg.Load()
+	if err != nil {
+		log.Fatal(err)
+	}
+	cache := cfg.Cache
+
 	if top == "" || cache == "" {
 		flag.PrintDefaults()
 		log.Fatal("Insufficient arguments.")
@@ -220,12 +230,18 @@ func main() {
 		LoadSpec(tspec)
 	}
 
-	session, err := mgo.Dial(server)
+	session, err := cfg.Dial()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	err = meta.Check(session, cache, cfg.Migrate)
 	if err != nil {
 		log.Fatal(err)
 	}
 
-	rtl.InitMgo(session, cache, false)
+	store := rtl.NewStore(context.Background(), session, cache, false)
+	modules = rtl.NewModuleCache(store, cfg.ModCache<<20)
 
 	log.SetFlags(log.Lshortfile)
 	log.SetOutput(os.Stdout)
@@ -233,15 +249,14 @@ func main() {
 	LoadWidths(session, cache)
 	LoadPrimParents(session, cache)
 
-	m := rtl.NewModule(top)
-
-	m.Load()
+	m := modules.LoadModule(top)
 
 	LUT = make(ModuleTable)
 
 	start := time.Now()
 	Count(m, "")
 	log.Println("Finished counting. Time elapsed:", time.Since(start))
+	log.Println("Module cache:", modules.Stats())
 
 	SEQ, err = os.Create(cache + "_seq.csv")
 	REG, err = os.Create(cache + "_reg.csv")
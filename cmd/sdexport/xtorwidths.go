@@ -32,7 +32,7 @@ func (m PropMap) Print() {
 var props PropMap
 
 func LoadWidths(session *mgo.Session, cache string) {
-	iter := session.DB("sart").C(cache + "_props").Find(bson.M{"key": "W"}).Select(bson.M{"_id": 0}).Iter()
+	iter := session.DB("").C(cache + "_props").Find(bson.M{"key": "W"}).Select(bson.M{"_id": 0}).Iter()
 
 	var result bson.M
 
@@ -63,7 +63,7 @@ var primparents set.Set
 
 func LoadPrimParents(session *mgo.Session, cache string) {
 	var ppresults []interface{}
-	err := session.DB("sart").C(cache+"_insts").Find(bson.M{"isprimparent": true}).Distinct("module", &ppresults)
+	err := session.DB("").C(cache+"_insts").Find(bson.M{"isprimparent": true}).Distinct("module", &ppresults)
 	if err != nil {
 		log.Fatal(err)
 	}
@@ -0,0 +1,179 @@
+package config
+
+import (
+	"flag"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"sart/bulk"
+)
+
+func writeFile(t *testing.T, contents string) string {
+	dir, err := ioutil.TempDir("", "sart-config-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(dir, "sart.json")
+	err = ioutil.WriteFile(path, []byte(contents), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestPrecedence(t *testing.T) {
+	path := writeFile(t, `{"server": "filehost", "db": "filedb", "cache": "filecache"}`)
+	defer os.RemoveAll(filepath.Dir(path))
+
+	testcases := []struct {
+		args []string
+		env  map[string]string
+		exp  Config
+	}{
+		{
+			nil,
+			nil,
+			Config{Server: DefaultServer, DB: DefaultDB},
+		},
+		{
+			[]string{"-config", path},
+			nil,
+			Config{Server: "filehost", DB: "filedb", Cache: "filecache"},
+		},
+		{
+			[]string{"-config", path},
+			map[string]string{EnvDB: "envdb", EnvMigrate: "true"},
+			Config{Server: "filehost", DB: "envdb", Cache: "filecache", Migrate: true},
+		},
+		{
+			[]string{"-config", path, "-workers", "3"},
+			map[string]string{EnvWorkers: "5", EnvBatch: "50", EnvModCache: "64"},
+			Config{Server: "filehost", DB: "filedb", Cache: "filecache", Workers: 3, Batch: 50, ModCache: 64},
+		},
+		{
+			[]string{"-cache", "flagcache", "-db", "flagdb"},
+			map[string]string{EnvConfig: path, EnvDB: "envdb"},
+			Config{Server: "filehost", DB: "flagdb", Cache: "flagcache"},
+		},
+	}
+
+	for i, tc := range testcases {
+		for key, val := range tc.env {
+			os.Setenv(key, val)
+		}
+
+		fs := flag.NewFlagSet("test", flag.ContinueOnError)
+		c := Flags(fs)
+		if err := fs.Parse(tc.args); err != nil {
+			t.Fatal(err)
+		}
+		if err := c.Load(); err != nil {
+			t.Errorf("Test %d: unexpected error %v", i, err)
+		}
+
+		if tc.exp.Workers == 0 {
+			tc.exp.Workers = bulk.Workers
+		}
+		if tc.exp.Batch == 0 {
+			tc.exp.Batch = bulk.BatchSize
+		}
+		if tc.exp.ModCache == 0 {
+			tc.exp.ModCache = DefaultModCache
+		}
+
+		if c.Server != tc.exp.Server || c.DB != tc.exp.DB ||
+			c.Cache != tc.exp.Cache || c.Migrate != tc.exp.Migrate ||
+			c.Workers != tc.exp.Workers || c.Batch != tc.exp.Batch ||
+			c.ModCache != tc.exp.ModCache {
+			t.Errorf("Test %d: expecting %v migrate:%v. Got %v migrate:%v", i,
+				tc.exp, tc.exp.Migrate, c, c.Migrate)
+		}
+
+		for key := range tc.env {
+			os.Unsetenv(key)
+		}
+	}
+}
+
+func TestStripOptions(t *testing.T) {
+	testcases := []struct {
+		uri     string
+		exp     string
+		connect time.Duration
+		socket  time.Duration
+		fail    bool
+	}{
+		{"localhost", "localhost", DefaultConnectTimeout, DefaultSocketTimeout, false},
+		{"mongodb://a,b/admin?replicaSet=rs0", "mongodb://a,b/admin?replicaSet=rs0",
+			DefaultConnectTimeout, DefaultSocketTimeout, false},
+		{"mongodb://a/?connectTimeoutMS=500&socketTimeoutMS=2000", "mongodb://a/",
+			500 * time.Millisecond, 2 * time.Second, false},
+		{"mongodb://a/?ssl=false&replicaSet=rs0", "mongodb://a/?replicaSet=rs0",
+			DefaultConnectTimeout, DefaultSocketTimeout, false},
+		{"mongodb://a/?tls=true", "", 0, 0, true},
+		{"mongodb://a/?connectTimeoutMS=x", "", 0, 0, true},
+	}
+
+	for _, tc := range testcases {
+		uri, connect, socket, err := stripOptions(tc.uri)
+		if tc.fail {
+			if err == nil {
+				t.Errorf("Expecting %q to fail. Got %q", tc.uri, uri)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("Unexpected error for %q: %v", tc.uri, err)
+			continue
+		}
+		if uri != tc.exp || connect != tc.connect || socket != tc.socket {
+			t.Errorf("Expecting %q %v %v for %q. Got %q %v %v", tc.exp, tc.connect,
+				tc.socket, tc.uri, uri, connect, socket)
+		}
+	}
+}
+
+func TestDialInfo(t *testing.T) {
+	testcases := []struct {
+		uri    string
+		source string
+	}{
+		{"localhost", ""},
+		{"mongodb://user:secret@a/", "admin"},
+		{"mongodb://user:secret@a/users", "users"},
+		{"mongodb://user:secret@a/users?authSource=auth", "auth"},
+	}
+
+	for _, tc := range testcases {
+		info, _, err := Config{Server: tc.uri, DB: "sart"}.dialInfo()
+		if err != nil {
+			t.Errorf("Unexpected error for %q: %v", tc.uri, err)
+			continue
+		}
+		if info.Source != tc.source || info.Database != "sart" {
+			t.Errorf("Expecting source %q and database sart for %q. Got %q %q",
+				tc.source, tc.uri, info.Source, info.Database)
+		}
+	}
+}
+
+func TestRedact(t *testing.T) {
+	testcases := []struct {
+		uri string
+		exp string
+	}{
+		{"localhost", "localhost"},
+		{"mongodb://a/sart", "mongodb://a/sart"},
+		{"mongodb://user@a/sart", "mongodb://user@a/sart"},
+		{"mongodb://user:secret@a/sart", "mongodb://user:xxxxx@a/sart"},
+	}
+
+	for _, tc := range testcases {
+		if got := redact(tc.uri); got != tc.exp {
+			t.Errorf("Expecting %q for %q. Got %q", tc.exp, tc.uri, got)
+		}
+	}
+}
@@ -0,0 +1,132 @@
+package config
+
+import (
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/mgo.v2"
+)
+
+// Timeouts used when the URI does not specify any. These match mgo.Dial.
+const (
+	DefaultConnectTimeout = 10 * time.Second
+	DefaultSocketTimeout  = time.Minute
+)
+
+// Dial connects to the configured server. The returned session has the
+// configured database as its default, so that packages using the session
+// reach it through session.DB("").
+//
+// On top of the options that mgo understands (replicaSet, authSource,
+// authMechanism, maxPoolSize, connect) the URI may carry connectTimeoutMS,
+// socketTimeoutMS and ssl or tls. TLS is not supported, so ssl and tls are
+// only accepted when set to false.
+func (c Config) Dial() (*mgo.Session, error) {
+	info, socket, err := c.dialInfo()
+	if err != nil {
+		return nil, err
+	}
+
+	session, err := mgo.DialWithInfo(info)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", redact(c.Server), err)
+	}
+
+	session.SetSyncTimeout(socket)
+	session.SetSocketTimeout(socket)
+
+	return session, nil
+}
+
+// dialInfo returns what mgo needs to dial c.Server, along with the socket
+// timeout.
+func (c Config) dialInfo() (*mgo.DialInfo, time.Duration, error) {
+	uri, connect, socket, err := stripOptions(c.Server)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	info, err := mgo.ParseURL(uri)
+	if err != nil {
+		return nil, 0, fmt.Errorf("%s: %v", redact(c.Server), err)
+	}
+
+	// Credentials are checked against authSource, or else the database in the
+	// URI, or else admin, as the MongoDB URI spec has it. The data itself
+	// lives in the configured database.
+	if info.Source == "" && info.Username != "" {
+		info.Source = info.Database
+		if info.Source == "" {
+			info.Source = "admin"
+		}
+	}
+	info.Database = c.DB
+	info.Timeout = connect
+
+	return info, socket, nil
+}
+
+// stripOptions removes the URI options that mgo.ParseURL would reject and
+// returns them interpreted.
+func stripOptions(uri string) (stripped string, connect, socket time.Duration, err error) {
+	connect = DefaultConnectTimeout
+	socket = DefaultSocketTimeout
+
+	parts := strings.SplitN(uri, "?", 2)
+	if len(parts) == 1 {
+		return uri, connect, socket, nil
+	}
+
+	query, err := url.ParseQuery(parts[1])
+	if err != nil {
+		return "", 0, 0, err
+	}
+
+	for key, vals := range query {
+		val := vals[len(vals)-1]
+
+		switch key {
+		case "connectTimeoutMS", "socketTimeoutMS":
+			ms, err := strconv.Atoi(val)
+			if err != nil || ms < 0 {
+				return "", 0, 0, fmt.Errorf("bad value for %s: %s", key, val)
+			}
+			if key == "connectTimeoutMS" {
+				connect = time.Duration(ms) * time.Millisecond
+			} else {
+				socket = time.Duration(ms) * time.Millisecond
+			}
+		case "ssl", "tls":
+			if b, err := strconv.ParseBool(val); err != nil || b {
+				return "", 0, 0, fmt.Errorf("unsupported connection URI option: %s=%s", key, val)
+			}
+		default:
+			continue
+		}
+		query.Del(key)
+	}
+
+	stripped = parts[0]
+	if len(query) > 0 {
+		stripped += "?" + query.Encode()
+	}
+	return
+}
+
+// redact hides the password in a connection URI so that it can be logged.
+func redact(uri string) string {
+	at := strings.LastIndex(uri, "@")
+	scheme := strings.Index(uri, "://")
+	if at < 0 || scheme < 0 || at < scheme {
+		return uri
+	}
+
+	userinfo := uri[scheme+3 : at]
+	if colon := strings.Index(userinfo, ":"); colon >= 0 {
+		userinfo = userinfo[:colon] + ":xxxxx"
+	}
+	return uri[:scheme+3] + userinfo + uri[at:]
+}
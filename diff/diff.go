@@ -0,0 +1,344 @@
+// Package diff compares two caches structurally: the module definitions they
+// hold and, optionally, the netlist templates built from them. Changes are
+// listed one by one, to be read by other tools, and summarised along the
+// hierarchy of a top module, to be read by people.
+package diff
+
+import (
+	"fmt"
+	"io"
+	"sart/netlist"
+	"sart/rtl"
+	"sort"
+	"strings"
+)
+
+// Kinds of change
+const (
+	ModuleAdded   = "module-added"
+	ModuleRemoved = "module-removed"
+	PortAdded     = "port-added"
+	PortRemoved   = "port-removed"
+	PortMoved     = "port-moved"     // Position in the port list changed
+	PortDirection = "port-direction" // Input, output or inout changed
+	InstAdded     = "inst-added"
+	InstRemoved   = "inst-removed"
+	InstType      = "inst-type" // Instance of a different module
+	ConnAdded     = "conn-added"
+	ConnRemoved   = "conn-removed"
+	ConnChanged   = "conn-changed" // Pin hooked up to a different signal
+	SeqnAdded     = "seqn-added"
+	SeqnRemoved   = "seqn-removed"
+	NodeAdded     = "node-added"
+	NodeRemoved   = "node-removed"
+	NodeType      = "node-type"
+	LinkAdded     = "link-added"
+	LinkRemoved   = "link-removed"
+)
+
+type Change struct {
+	Kind   string `json:"kind"`
+	Module string `json:"module"`
+	Name   string `json:"name,omitempty"`   // Port, instance, pin, node or link
+	Before string `json:"before,omitempty"` // In the older cache
+	After  string `json:"after,omitempty"`  // In the newer cache
+}
+
+func (c Change) String() string {
+	str := c.Kind + " " + c.Module
+	if c.Name != "" {
+		str += " " + c.Name
+	}
+	if c.Before != "" || c.After != "" {
+		str += fmt.Sprintf(": %q -> %q", c.Before, c.After)
+	}
+	return str
+}
+
+// Caches compares the modules of two caches. The names of the modules in each
+// are given, and their definitions fetched from before and after.
+func Caches(beforenames, afternames []string, before, after rtl.ModuleLoader) (changes []Change) {
+	inbefore := make(map[string]bool)
+	for _, name := range beforenames {
+		inbefore[name] = true
+	}
+	inafter := make(map[string]bool)
+	for _, name := range afternames {
+		inafter[name] = true
+	}
+
+	for _, name := range beforenames {
+		if !inafter[name] {
+			changes = append(changes, Change{Kind: ModuleRemoved, Module: name})
+		}
+	}
+	for _, name := range afternames {
+		if !inbefore[name] {
+			changes = append(changes, Change{Kind: ModuleAdded, Module: name})
+			continue
+		}
+		changes = append(changes, Modules(before.LoadModule(name), after.LoadModule(name))...)
+	}
+
+	Sort(changes)
+	return
+}
+
+// Modules compares two definitions of the same module.
+func Modules(before, after *rtl.Module) (changes []Change) {
+	add := func(kind, name, o, n string) {
+		changes = append(changes, Change{kind, after.Name, name, o, n})
+	}
+
+	for name, op := range before.Ports {
+		np, found := after.Ports[name]
+		switch {
+		case !found:
+			add(PortRemoved, name, "", "")
+		case op.Pos != np.Pos:
+			add(PortMoved, name, fmt.Sprint(op.Pos), fmt.Sprint(np.Pos))
+		}
+		if found && op.Type != np.Type {
+			add(PortDirection, name, op.Type, np.Type)
+		}
+	}
+	for name := range after.Ports {
+		if _, found := before.Ports[name]; !found {
+			add(PortAdded, name, "", "")
+		}
+	}
+
+	for iname, oi := range before.Insts {
+		ni, found := after.Insts[iname]
+		if !found {
+			add(InstRemoved, iname, oi.Type, "")
+			if oi.IsSeq {
+				add(SeqnRemoved, iname, oi.Type, "")
+			}
+			continue
+		}
+		if oi.Type != ni.Type {
+			add(InstType, iname, oi.Type, ni.Type)
+		}
+		switch {
+		case ni.IsSeq && !oi.IsSeq:
+			add(SeqnAdded, iname, "", ni.Type)
+		case oi.IsSeq && !ni.IsSeq:
+			add(SeqnRemoved, iname, oi.Type, "")
+		}
+		changes = append(changes, conns(after.Name, iname, before.Conns[iname], after.Conns[iname])...)
+	}
+	for iname, ni := range after.Insts {
+		if _, found := before.Insts[iname]; found {
+			continue
+		}
+		add(InstAdded, iname, "", ni.Type)
+		if ni.IsSeq {
+			add(SeqnAdded, iname, "", ni.Type)
+		}
+	}
+
+	Sort(changes)
+	return
+}
+
+// conns compares the connections of instance iname of module mname, pin by
+// pin. Pins are known by position, as that is how instances are hooked up.
+func conns(mname, iname string, before, after []*rtl.Conn) (changes []Change) {
+	bypos := func(conns []*rtl.Conn) map[int]string {
+		m := make(map[int]string)
+		for _, c := range conns {
+			m[c.Pos] = c.Actual
+		}
+		return m
+	}
+	oc, nc := bypos(before), bypos(after)
+
+	pin := func(pos int) string {
+		return fmt.Sprintf("%s:%d", iname, pos)
+	}
+	for pos, oa := range oc {
+		na, found := nc[pos]
+		switch {
+		case !found:
+			changes = append(changes, Change{ConnRemoved, mname, pin(pos), oa, ""})
+		case oa != na:
+			changes = append(changes, Change{ConnChanged, mname, pin(pos), oa, na})
+		}
+	}
+	for pos, na := range nc {
+		if _, found := oc[pos]; !found {
+			changes = append(changes, Change{ConnAdded, mname, pin(pos), "", na})
+		}
+	}
+	return
+}
+
+// Templates compares the netlist templates of module mname built from two
+// caches: their nodes and links, by local name.
+func Templates(mname string, before, after *netlist.Netlist) (changes []Change) {
+	local := func(fullname string) string {
+		return strings.TrimPrefix(fullname, mname+"/")
+	}
+
+	nodes := func(n *netlist.Netlist) map[string]string {
+		m := make(map[string]string)
+		for fullname, node := range n.Nodes {
+			m[local(fullname)] = node.Type
+		}
+		return m
+	}
+	on, nn := nodes(before), nodes(after)
+	for name, ot := range on {
+		nt, found := nn[name]
+		switch {
+		case !found:
+			changes = append(changes, Change{NodeRemoved, mname, name, ot, ""})
+		case ot != nt:
+			changes = append(changes, Change{NodeType, mname, name, ot, nt})
+		}
+	}
+	for name, nt := range nn {
+		if _, found := on[name]; !found {
+			changes = append(changes, Change{NodeAdded, mname, name, "", nt})
+		}
+	}
+
+	links := func(n *netlist.Netlist) map[string]bool {
+		m := make(map[string]bool)
+		for lname, rnodes := range n.Links {
+			for _, r := range rnodes {
+				m[local(lname)+" -> "+local(r.Fullname())] = true
+			}
+		}
+		return m
+	}
+	ol, nl := links(before), links(after)
+	for link := range ol {
+		if !nl[link] {
+			changes = append(changes, Change{Kind: LinkRemoved, Module: mname, Name: link})
+		}
+	}
+	for link := range nl {
+		if !ol[link] {
+			changes = append(changes, Change{Kind: LinkAdded, Module: mname, Name: link})
+		}
+	}
+
+	Sort(changes)
+	return
+}
+
+// Sort orders changes by module, kind and name so that change lists can be
+// compared from run to run.
+func Sort(changes []Change) {
+	sort.Slice(changes, func(i, j int) bool {
+		a, b := changes[i], changes[j]
+		if a.Module != b.Module {
+			return a.Module < b.Module
+		}
+		if a.Kind != b.Kind {
+			return a.Kind < b.Kind
+		}
+		return a.Name < b.Name
+	})
+}
+
+////////////////////////////////////////////////////////////////////////////////
+
+// Summary writes the hierarchy under module top, as it is in either cache,
+// with the number of changes of each kind in each module and below it. Only
+// modules with changes at or below them are shown, and each module is broken
+// down only the first time it comes up.
+func Summary(w io.Writer, top string, changes []Change, before, after rtl.ModuleLoader) error {
+	own := make(map[string]map[string]int)
+	for _, c := range changes {
+		if own[c.Module] == nil {
+			own[c.Module] = make(map[string]int)
+		}
+		own[c.Module][c.Kind]++
+	}
+
+	// Instances of a module in either cache, by instance name
+	insts := func(mname string) map[string]string {
+		found := make(map[string]string)
+		for _, loader := range []rtl.ModuleLoader{before, after} {
+			for iname, inst := range loader.LoadModule(mname).Insts {
+				if !inst.IsPrim {
+					found[iname] = inst.Type
+				}
+			}
+		}
+		return found
+	}
+
+	// Changes at or below each module, counting each module below once
+	below := make(map[string]int)
+	var count func(mname string, seen map[string]bool) int
+	count = func(mname string, seen map[string]bool) int {
+		if seen[mname] {
+			return 0
+		}
+		seen[mname] = true
+		total := 0
+		for _, n := range own[mname] {
+			total += n
+		}
+		for _, typ := range insts(mname) {
+			total += count(typ, seen)
+		}
+		return total
+	}
+	total := func(mname string) int {
+		if n, found := below[mname]; found {
+			return n
+		}
+		below[mname] = count(mname, make(map[string]bool))
+		return below[mname]
+	}
+
+	shown := make(map[string]bool)
+	var print func(label, mname, indent string) error
+	print = func(label, mname, indent string) error {
+		n := total(mname)
+		if n == 0 {
+			return nil
+		}
+
+		line := fmt.Sprintf("%s%s (%d)", indent, label, n)
+		if kinds := own[mname]; len(kinds) > 0 {
+			var parts []string
+			for kind, k := range kinds {
+				parts = append(parts, fmt.Sprintf("%s:%d", kind, k))
+			}
+			sort.Strings(parts)
+			line += " " + strings.Join(parts, " ")
+		}
+		if shown[mname] {
+			line += " (see above)"
+		}
+		if _, err := fmt.Fprintln(w, line); err != nil {
+			return err
+		}
+		if shown[mname] {
+			return nil
+		}
+		shown[mname] = true
+
+		sub := insts(mname)
+		var inames []string
+		for iname := range sub {
+			inames = append(inames, iname)
+		}
+		sort.Strings(inames)
+		for _, iname := range inames {
+			err := print(iname+":"+sub[iname], sub[iname], indent+"|   ")
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	return print(top, top, "")
+}
@@ -0,0 +1,166 @@
+package diff
+
+import (
+	"bytes"
+	"reflect"
+	"sart/netlist"
+	"sart/rtl"
+	"testing"
+)
+
+type defs map[string]*rtl.Module
+
+func (d defs) LoadModule(name string) *rtl.Module {
+	if m, found := d[name]; found {
+		return m
+	}
+	return rtl.NewModule(name)
+}
+
+// module returns a module with ports a, b and y, an instance u of sub hooked up
+// to a and y, and a flop f.
+func module() *rtl.Module {
+	m := rtl.NewModule("top")
+	for i, name := range []string{"a", "b", "y"} {
+		m.AddNewPort(name, i)
+		m.SetPortType(name, "input")
+	}
+	m.SetPortType("y", "output")
+
+	m.AddNewInst("u", "sub")
+	m.AddNewConn("u", "sub", "a", 0)
+	m.AddNewConn("u", "sub", "y", 1)
+
+	f := rtl.NewInst("top", "f", "DFF")
+	f.IsPrim, f.IsSeq = true, true
+	m.AddInst(f)
+	m.AddNewConn("f", "DFF", "b", 0)
+	return m
+}
+
+func TestModules(t *testing.T) {
+	tests := []struct {
+		name   string
+		change func(m *rtl.Module)
+		want   []Change
+	}{
+		{"same", func(m *rtl.Module) {}, nil},
+		{"port added", func(m *rtl.Module) {
+			m.AddNewPort("c", 3)
+		}, []Change{{PortAdded, "top", "c", "", ""}}},
+		{"port removed and moved", func(m *rtl.Module) {
+			delete(m.Ports, "a")
+			m.Ports["b"].Pos = 0
+		}, []Change{
+			{PortMoved, "top", "b", "1", "0"},
+			{PortRemoved, "top", "a", "", ""},
+		}},
+		{"port direction", func(m *rtl.Module) {
+			m.SetPortType("b", "inout")
+		}, []Change{{PortDirection, "top", "b", "input", "inout"}}},
+		{"inst type", func(m *rtl.Module) {
+			m.Insts["u"].Type = "sub2"
+		}, []Change{{InstType, "top", "u", "sub", "sub2"}}},
+		{"conns", func(m *rtl.Module) {
+			m.Conns["u"][1].Actual = "b"
+			m.Conns["u"] = m.Conns["u"][1:]
+			m.AddNewConn("f", "DFF", "a", 1)
+		}, []Change{
+			{ConnAdded, "top", "f:1", "", "a"},
+			{ConnChanged, "top", "u:1", "y", "b"},
+			{ConnRemoved, "top", "u:0", "a", ""},
+		}},
+		{"new sequential", func(m *rtl.Module) {
+			g := rtl.NewInst("top", "g", "DFF")
+			g.IsPrim, g.IsSeq = true, true
+			m.AddInst(g)
+		}, []Change{
+			{InstAdded, "top", "g", "", "DFF"},
+			{SeqnAdded, "top", "g", "", "DFF"},
+		}},
+		{"sequential removed", func(m *rtl.Module) {
+			delete(m.Insts, "f")
+			delete(m.Conns, "f")
+		}, []Change{
+			{InstRemoved, "top", "f", "DFF", ""},
+			{SeqnRemoved, "top", "f", "DFF", ""},
+		}},
+	}
+
+	for _, test := range tests {
+		m := module()
+		test.change(m)
+		got := Modules(module(), m)
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%s: got %v, want %v", test.name, got, test.want)
+		}
+	}
+}
+
+func TestCaches(t *testing.T) {
+	before := defs{"top": module(), "sub": rtl.NewModule("sub"), "gone": rtl.NewModule("gone")}
+	m := module()
+	m.AddNewPort("c", 3)
+	after := defs{"top": m, "sub": rtl.NewModule("sub"), "fresh": rtl.NewModule("fresh")}
+
+	got := Caches([]string{"gone", "sub", "top"}, []string{"fresh", "sub", "top"}, before, after)
+	want := []Change{
+		{Kind: ModuleAdded, Module: "fresh"},
+		{Kind: ModuleRemoved, Module: "gone"},
+		{Kind: PortAdded, Module: "top", Name: "c"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func TestTemplates(t *testing.T) {
+	build := func(typ string) *netlist.Netlist {
+		n := netlist.NewNetlist("m")
+		n.AddNode(netlist.NewPortNode("m", "a", "INPUT"))
+		n.AddNode(netlist.NewPrimNode("m", "g", typ))
+		n.Connect(n.Nodes["m/a"], n.Nodes["m/g"])
+		return n
+	}
+
+	before, after := build("AND"), build("OR")
+	after.AddNode(netlist.NewWireNode("m", "w"))
+	after.Connect(after.Nodes["m/g"], after.Nodes["m/w"])
+
+	got := Templates("m", before, after)
+	want := []Change{
+		{LinkAdded, "m", "g -> w", "", ""},
+		{NodeAdded, "m", "w", "", "WIRE"},
+		{NodeType, "m", "g", "AND", "OR"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func TestSummary(t *testing.T) {
+	top := module()
+	top.AddNewInst("v", "sub")
+	sub := rtl.NewModule("sub")
+	sub.AddNewInst("l", "leaf")
+	loaders := defs{"top": top, "sub": sub}
+
+	changes := []Change{
+		{Kind: PortAdded, Module: "leaf", Name: "p"},
+		{Kind: InstAdded, Module: "leaf", Name: "i"},
+		{Kind: PortAdded, Module: "top", Name: "c"},
+	}
+
+	var b bytes.Buffer
+	err := Summary(&b, "top", changes, loaders, loaders)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "top (3) port-added:1\n" +
+		"|   u:sub (2)\n" +
+		"|   |   l:leaf (2) inst-added:1 port-added:1\n" +
+		"|   v:sub (2) (see above)\n"
+	if b.String() != want {
+		t.Errorf("got\n%s\nwant\n%s", b.String(), want)
+	}
+}
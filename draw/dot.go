@@ -0,0 +1,74 @@
+package draw
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// WriteDOT writes the picture in the Graphviz DOT language. Each netlist is a
+// cluster, nested as the netlists are, and edges show their labels. Besides
+// colour and shape, every node has the attributes ace, seqn and port, and rp
+// and wp listing its ACE terms.
+func (p *Picture) WriteDOT(w io.Writer) error {
+	out := bufio.NewWriter(w)
+
+	fmt.Fprintf(out, "digraph %s {\n", strconv.Quote(p.Name))
+	fmt.Fprintln(out, "\trankdir=LR;")
+	fmt.Fprintln(out, "\tnode [style=filled];")
+
+	members := make([][]int, len(p.Clusters))
+	for i, v := range p.Nodes {
+		members[v.Cluster] = append(members[v.Cluster], i)
+	}
+	children := make([][]int, len(p.Clusters))
+	for i, c := range p.Clusters {
+		if c.Parent >= 0 {
+			children[c.Parent] = append(children[c.Parent], i)
+		}
+	}
+
+	var cluster func(c int, indent string)
+	cluster = func(c int, indent string) {
+		fmt.Fprintf(out, "%ssubgraph \"cluster_%d\" {\n", indent, c)
+		fmt.Fprintf(out, "%s\tlabel=%s;\n", indent,
+			strconv.Quote(p.Clusters[c].Name+" ("+p.Clusters[c].Type+")"))
+		for _, i := range members[c] {
+			v := p.Nodes[i]
+			fmt.Fprintf(out, "%s\t%d [label=%s, shape=%s, fillcolor=%s, ace=%v, seqn=%v, port=%v, rp=%s, wp=%s];\n",
+				indent, i, strconv.Quote(v.Name+"\n"+v.Type), v.shape(), strconv.Quote(v.colour()),
+				v.IsAce, v.IsSeqn, v.IsPort, strconv.Quote(terms(v.Rp)), strconv.Quote(terms(v.Wp)))
+		}
+		for _, child := range children[c] {
+			cluster(child, indent+"\t")
+		}
+		fmt.Fprintf(out, "%s}\n", indent)
+	}
+	for c := range p.Clusters {
+		if p.Clusters[c].Parent < 0 {
+			cluster(c, "\t")
+		}
+	}
+
+	for _, e := range p.Edges {
+		if e.Label != "" {
+			fmt.Fprintf(out, "\t%d -> %d [label=%s];\n", e.From, e.To, strconv.Quote(e.Label))
+		} else {
+			fmt.Fprintf(out, "\t%d -> %d;\n", e.From, e.To)
+		}
+	}
+	fmt.Fprintln(out, "}")
+
+	return out.Flush()
+}
+
+// terms lists ACE terms separated by spaces.
+func terms(positions []int) string {
+	strs := make([]string, len(positions))
+	for i, pos := range positions {
+		strs[i] = strconv.Itoa(pos)
+	}
+	return strings.Join(strs, " ")
+}
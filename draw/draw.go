@@ -0,0 +1,282 @@
+// Package draw exports the connectivity of a netlist and its subnets as a
+// picture for design reviews: Graphviz DOT, to be laid out by dot, or GraphML,
+// to be opened in a graph editor. Nodes are coloured by what they are and by
+// whether they carry ACE terms, and carry their ACE terms as attributes.
+package draw
+
+import (
+	"log"
+	"sart/netlist"
+	"sort"
+	"strconv"
+)
+
+type Options struct {
+	// Levels of netlists, starting with the one drawn, whose insides are
+	// drawn. Subnets below that are drawn as their ports only. 0 for no
+	// limit.
+	Depth int
+
+	// Leave out prims that are neither sequential nor ACE, linking whatever
+	// drives them straight to whatever they drive.
+	Collapse bool
+}
+
+// Picture is a netlist subtree reduced to what is drawn.
+type Picture struct {
+	Name     string
+	Nodes    []Vertex
+	Edges    []Edge
+	Clusters []Cluster // Index 0 is the netlist drawn, if there is one
+}
+
+type Vertex struct {
+	Name    string // Full name
+	Type    string
+	Kind    string
+	IsAce   bool
+	IsSeqn  bool
+	IsPort  bool
+	Rp, Wp  []int // ACE terms
+	Cluster int   // Netlist holding the node
+
+	kind netlist.Kind
+}
+
+// Edge links two vertices by index.
+type Edge struct {
+	From, To int
+	Label    string
+}
+
+type Cluster struct {
+	Name   string
+	Type   string
+	Parent int // -1 for an outermost netlist
+}
+
+// New returns the picture of netlist n and its subnets. Subnets are expanded
+// down to the depth drawn.
+func New(n *netlist.Netlist, opt Options) *Picture {
+	p := &Picture{Name: n.Name}
+
+	index := make(map[*netlist.Node]int)
+	var links []Edge
+
+	addNode := func(node *netlist.Node, cluster int) {
+		index[node] = len(p.Nodes)
+		p.Nodes = append(p.Nodes, vertex(node, cluster))
+	}
+
+	var add func(n *netlist.Netlist, parent, level int)
+	add = func(n *netlist.Netlist, parent, level int) {
+		cluster := len(p.Clusters)
+		p.Clusters = append(p.Clusters, Cluster{n.Name, n.Type, parent})
+
+		inside := opt.Depth == 0 || level < opt.Depth
+		if inside {
+			n.Expand()
+		}
+
+		for _, name := range sortedNodes(n) {
+			if node := n.Nodes[name]; inside || node.IsPort {
+				addNode(node, cluster)
+			}
+		}
+
+		if !inside {
+			return
+		}
+
+		var names []string
+		for name := range n.Subnets {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			add(n.Subnets[name], cluster, level+1)
+		}
+
+		// Links join nodes of n and ports of its subnets, all in place by now
+		for _, lname := range sortedLinks(n) {
+			l, found := index[n.LocateNode(lname)]
+			if !found {
+				log.Fatalf("Could not locate lnode %q in netlist %q", lname, n.Name)
+			}
+			for _, r := range n.Links[lname] {
+				to, found := index[r]
+				if !found {
+					log.Fatalf("Could not locate rnode %q in netlist %q", r.Fullname(), n.Name)
+				}
+				links = append(links, Edge{From: l, To: to})
+			}
+		}
+	}
+	add(n, -1, 0)
+
+	if opt.Collapse {
+		p.collapse(links)
+	} else {
+		p.Edges = links
+	}
+	p.Edges = dedup(p.Edges)
+
+	return p
+}
+
+// collapse drops the combinational prims of the picture, replacing each chain
+// of them with links from what drives the chain to what it drives.
+func (p *Picture) collapse(links []Edge) {
+	gone := make([]bool, len(p.Nodes))
+	for i, v := range p.Nodes {
+		gone[i] = v.kind == netlist.KindPrim
+	}
+
+	out := make([][]int, len(p.Nodes))
+	for _, e := range links {
+		out[e.From] = append(out[e.From], e.To)
+	}
+
+	// Renumber what is kept
+	renum := make([]int, len(p.Nodes))
+	var kept []Vertex
+	for i, v := range p.Nodes {
+		renum[i] = len(kept)
+		if !gone[i] {
+			kept = append(kept, v)
+		}
+	}
+
+	for u := range p.Nodes {
+		if gone[u] {
+			continue
+		}
+		seen := make(map[int]bool)
+		stack := append([]int(nil), out[u]...)
+		for len(stack) > 0 {
+			v := stack[len(stack)-1]
+			stack = stack[:len(stack)-1]
+			if seen[v] {
+				continue
+			}
+			seen[v] = true
+			if gone[v] {
+				stack = append(stack, out[v]...)
+				continue
+			}
+			p.Edges = append(p.Edges, Edge{From: renum[u], To: renum[v]})
+		}
+	}
+
+	p.Nodes = kept
+}
+
+func vertex(node *netlist.Node, cluster int) Vertex {
+	return Vertex{
+		Name:    node.Fullname(),
+		Type:    node.Type,
+		Kind:    node.Kind().String(),
+		IsAce:   node.IsAce,
+		IsSeqn:  node.IsSeqn,
+		IsPort:  node.IsPort,
+		Rp:      node.RpAce.Test(),
+		Wp:      node.WpAce.Test(),
+		Cluster: cluster,
+		kind:    node.Kind(),
+	}
+}
+
+func sortedNodes(n *netlist.Netlist) (names []string) {
+	for name := range n.Nodes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return
+}
+
+func sortedLinks(n *netlist.Netlist) (names []string) {
+	for name := range n.Links {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return
+}
+
+func dedup(edges []Edge) []Edge {
+	sort.Slice(edges, func(i, j int) bool {
+		if edges[i].From != edges[j].From {
+			return edges[i].From < edges[j].From
+		}
+		return edges[i].To < edges[j].To
+	})
+
+	var out []Edge
+	for i, e := range edges {
+		if i == 0 || e != edges[i-1] {
+			out = append(out, e)
+		}
+	}
+	return out
+}
+
+// Seq returns the picture, called name, of sequential graph s, with each edge
+// labelled with its logic depth. Nodes are grouped by the netlist that holds
+// them, but the groups are not nested.
+func Seq(name string, s *netlist.SeqGraph) *Picture {
+	p := &Picture{Name: name}
+
+	clusters := make(map[string]int)
+	for id, node := range s.Nodes {
+		name, typ := s.Owner(id)
+		cluster, found := clusters[name]
+		if !found {
+			cluster = len(p.Clusters)
+			clusters[name] = cluster
+			p.Clusters = append(p.Clusters, Cluster{name, typ, -1})
+		}
+
+		p.Nodes = append(p.Nodes, vertex(node, cluster))
+
+		for _, to := range s.Out(id) {
+			p.Edges = append(p.Edges, Edge{id, int(to), strconv.Itoa(s.Depth(id, int(to)))})
+		}
+	}
+
+	return p
+}
+
+////////////////////////////////////////////////////////////////////////////////
+
+// Colours and shapes by what a node is. ACE nodes take precedence over nodes
+// that only carry ACE terms, which take precedence over the kind of node.
+const (
+	colourAce   = "#e8505b" // ACE node
+	colourTerms = "#f9d56e" // Carries ACE terms
+	colourSeqn  = "#9fd3f0" // Sequential
+	colourPort  = "#c9e4c5"
+	colourOther = "#ffffff"
+)
+
+func (v Vertex) colour() string {
+	switch {
+	case v.IsAce:
+		return colourAce
+	case len(v.Rp) > 0 || len(v.Wp) > 0:
+		return colourTerms
+	case v.IsSeqn:
+		return colourSeqn
+	case v.IsPort:
+		return colourPort
+	}
+	return colourOther
+}
+
+func (v Vertex) shape() string {
+	switch {
+	case v.IsSeqn:
+		return "box"
+	case v.IsPort:
+		return "diamond"
+	}
+	return "ellipse"
+}
@@ -0,0 +1,159 @@
+package draw
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"sart/bitfield"
+	"sart/netlist"
+	"strings"
+	"testing"
+)
+
+// testNetlist returns t: a -> g -> h -> q -> u/i, u/o -> z, where g and h are
+// combinational, q is ACE and sequential, and u/i -> u/x -> u/o inside t/u.
+func testNetlist() *netlist.Netlist {
+	n := netlist.NewNetlist("t")
+	n.Type = "top"
+	a := netlist.NewPortNode("t", "a", "INPUT")
+	g := netlist.NewPrimNode("t", "g", "BUF")
+	h := netlist.NewPrimNode("t", "h", "AND")
+	q := netlist.NewPrimNode("t", "q", "FF")
+	z := netlist.NewPortNode("t", "z", "OUTPUT")
+	for _, node := range []*netlist.Node{a, g, h, q, z} {
+		n.AddNode(node)
+	}
+	q.IsSeqn = true
+	q.IsAce = true
+	q.RpAce = bitfield.New(8)
+	q.WpAce = bitfield.New(8)
+	q.RpAce.Set(0)
+	q.WpAce.Set(0)
+
+	u := netlist.NewNetlist("t/u")
+	u.Type = "buf"
+	i := netlist.NewPortNode("t/u", "i", "INPUT")
+	o := netlist.NewPortNode("t/u", "o", "OUTPUT")
+	x := netlist.NewPrimNode("t/u", "x", "BUF")
+	for _, node := range []*netlist.Node{i, o, x} {
+		u.AddNode(node)
+	}
+	u.Connect(i, x)
+	u.Connect(x, o)
+	n.Subnets[u.Name] = u
+
+	n.Connect(a, g)
+	n.Connect(g, h)
+	n.Connect(h, q)
+	n.Connect(q, i)
+	n.Connect(o, z)
+
+	n.Walk()
+	return n
+}
+
+func edges(p *Picture) (s []string) {
+	for _, e := range p.Edges {
+		s = append(s, p.Nodes[e.From].Name+">"+p.Nodes[e.To].Name)
+	}
+	return
+}
+
+func TestNew(t *testing.T) {
+	for _, test := range []struct {
+		opt   Options
+		nodes int
+		exp   string
+	}{
+		{Options{}, 8, "t/a>t/g t/g>t/h t/h>t/q t/q>t/u/i t/u/i>t/u/x t/u/o>t/z t/u/x>t/u/o"},
+		{Options{Depth: 1}, 7, "t/a>t/g t/g>t/h t/h>t/q t/q>t/u/i t/u/o>t/z"},
+		{Options{Collapse: true}, 5, "t/a>t/q t/q>t/u/i t/u/i>t/u/o t/u/o>t/z"},
+	} {
+		p := New(testNetlist(), test.opt)
+		if len(p.Nodes) != test.nodes {
+			t.Errorf("Expecting %d nodes with %+v. Got %d", test.nodes, test.opt, len(p.Nodes))
+		}
+		if got := strings.Join(edges(p), " "); got != test.exp {
+			t.Errorf("Expecting edges %q with %+v. Got %q", test.exp, test.opt, got)
+		}
+		if len(p.Clusters) != 2 || p.Clusters[1].Parent != 0 {
+			t.Errorf("Expecting t/u nested in t. Got %+v", p.Clusters)
+		}
+	}
+}
+
+func TestWriteDOT(t *testing.T) {
+	p := New(testNetlist(), Options{})
+
+	var buf bytes.Buffer
+	err := p.WriteDOT(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dot := buf.String()
+
+	for _, exp := range []string{
+		`digraph "t" {`,
+		`subgraph "cluster_1" {`,
+		`label="t/u (buf)";`,
+		fmt.Sprintf(`shape=box, fillcolor="%s", ace=true, seqn=true, port=false, rp="0", wp="0"`, colourAce),
+	} {
+		if !strings.Contains(dot, exp) {
+			t.Errorf("Expecting DOT output to contain %q. Got\n%s", exp, dot)
+		}
+	}
+	if got := strings.Count(dot, "->"); got != len(p.Edges) {
+		t.Errorf("Expecting %d edges in DOT output. Got %d", len(p.Edges), got)
+	}
+}
+
+func TestWriteGraphML(t *testing.T) {
+	p := New(testNetlist(), Options{})
+
+	var buf bytes.Buffer
+	err := p.WriteGraphML(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var doc graphml
+	err = xml.Unmarshal(buf.Bytes(), &doc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(doc.Graph.Nodes) != len(p.Nodes) || len(doc.Graph.Edges) != len(p.Edges) {
+		t.Fatalf("Expecting %d nodes and %d edges. Got %d and %d", len(p.Nodes),
+			len(p.Edges), len(doc.Graph.Nodes), len(doc.Graph.Edges))
+	}
+
+	data := make(map[string]string)
+	for _, d := range doc.Graph.Nodes[2].Data { // t/h, which carries w term 0
+		data[d.Key] = d.Value
+	}
+	if data["name"] != "t/h" || data["wp"] != "0" || data["color"] != colourTerms {
+		t.Errorf("Unexpected data for t/h: %v", data)
+	}
+}
+
+func TestSeq(t *testing.T) {
+	n := testNetlist()
+	r := netlist.NewPrimNode("t", "r", "FF")
+	r.IsSeqn = true
+	n.AddNode(r)
+	n.Connect(n.Subnets["t/u"].Nodes["t/u/o"], r)
+
+	p := Seq("t", netlist.NewSeqGraph(n.Graph()))
+
+	if got := strings.Join(edges(p), " "); got != "t/q>t/r" || p.Edges[0].Label != "1" {
+		t.Errorf("Expecting edge t/q>t/r with depth 1. Got %q %+v", got, p.Edges)
+	}
+
+	var buf bytes.Buffer
+	err := p.WriteDOT(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(buf.String(), ` [label="1"];`) {
+		t.Errorf("Expecting a labelled edge in DOT output. Got\n%s", buf.String())
+	}
+}
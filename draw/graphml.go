@@ -0,0 +1,108 @@
+package draw
+
+import (
+	"encoding/xml"
+	"io"
+	"strconv"
+)
+
+// GraphML layout. Only what WriteGraphML needs is modelled.
+type graphml struct {
+	XMLName xml.Name     `xml:"graphml"`
+	Xmlns   string       `xml:"xmlns,attr"`
+	Keys    []graphmlKey `xml:"key"`
+	Graph   graphmlGraph `xml:"graph"`
+}
+
+type graphmlKey struct {
+	ID   string `xml:"id,attr"`
+	For  string `xml:"for,attr"`
+	Name string `xml:"attr.name,attr"`
+	Type string `xml:"attr.type,attr"`
+}
+
+type graphmlGraph struct {
+	ID          string        `xml:"id,attr"`
+	EdgeDefault string        `xml:"edgedefault,attr"`
+	Nodes       []graphmlNode `xml:"node"`
+	Edges       []graphmlEdge `xml:"edge"`
+}
+
+type graphmlNode struct {
+	ID   string        `xml:"id,attr"`
+	Data []graphmlData `xml:"data"`
+}
+
+type graphmlEdge struct {
+	Source string        `xml:"source,attr"`
+	Target string        `xml:"target,attr"`
+	Data   []graphmlData `xml:"data"`
+}
+
+type graphmlData struct {
+	Key   string `xml:"key,attr"`
+	Value string `xml:",chardata"`
+}
+
+var graphmlKeys = []graphmlKey{
+	{"name", "node", "name", "string"},
+	{"type", "node", "type", "string"},
+	{"kind", "node", "kind", "string"},
+	{"netlist", "node", "netlist", "string"},
+	{"ace", "node", "ace", "boolean"},
+	{"seqn", "node", "seqn", "boolean"},
+	{"port", "node", "port", "boolean"},
+	{"rp", "node", "rp", "string"},
+	{"wp", "node", "wp", "string"},
+	{"color", "node", "color", "string"},
+	{"label", "edge", "label", "string"},
+}
+
+// WriteGraphML writes the picture as a flat GraphML graph. Every node has the
+// netlist that holds it, ace, seqn and port flags, rp and wp listing its ACE
+// terms, and the colour it would have in DOT. Edges have their labels.
+func (p *Picture) WriteGraphML(w io.Writer) error {
+	doc := graphml{
+		Xmlns: "http://graphml.graphdrawing.org/xmlns",
+		Keys:  graphmlKeys,
+		Graph: graphmlGraph{ID: p.Name, EdgeDefault: "directed"},
+	}
+
+	for i, v := range p.Nodes {
+		doc.Graph.Nodes = append(doc.Graph.Nodes, graphmlNode{
+			ID: "n" + strconv.Itoa(i),
+			Data: []graphmlData{
+				{"name", v.Name},
+				{"type", v.Type},
+				{"kind", v.Kind},
+				{"netlist", p.Clusters[v.Cluster].Name},
+				{"ace", strconv.FormatBool(v.IsAce)},
+				{"seqn", strconv.FormatBool(v.IsSeqn)},
+				{"port", strconv.FormatBool(v.IsPort)},
+				{"rp", terms(v.Rp)},
+				{"wp", terms(v.Wp)},
+				{"color", v.colour()},
+			},
+		})
+	}
+	for _, e := range p.Edges {
+		edge := graphmlEdge{Source: "n" + strconv.Itoa(e.From), Target: "n" + strconv.Itoa(e.To)}
+		if e.Label != "" {
+			edge.Data = []graphmlData{{"label", e.Label}}
+		}
+		doc.Graph.Edges = append(doc.Graph.Edges, edge)
+	}
+
+	_, err := io.WriteString(w, xml.Header)
+	if err != nil {
+		return err
+	}
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+	err = enc.Encode(doc)
+	if err != nil {
+		return err
+	}
+	_, err = io.WriteString(w, "\n")
+	return err
+}
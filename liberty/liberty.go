@@ -0,0 +1,359 @@
+// Package liberty reads what sart needs from Liberty cell libraries: which
+// pins of each cell are clock pins and which are asynchronous reset pins.
+// Everything else in the library is parsed only to be skipped.
+//
+// Clock pins are those with "clock : true", or named by the clocked_on of an
+// ff group or the enable of a latch group. Reset pins are those named by the
+// clear or preset of an ff or latch group.
+package liberty
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+	"unicode"
+)
+
+// Pin classes
+const (
+	Clock = "clock"
+	Reset = "reset"
+)
+
+// Library holds the class of the clock and reset pins of each cell, by cell
+// name and pin name.
+type Library map[string]map[string]string
+
+var SyntaxError error = fmt.Errorf("-!- Liberty Syntax Error")
+
+// Load reads a Liberty library.
+func Load(in io.Reader) (Library, error) {
+	p := &parser{in: bufio.NewReader(in), line: 1}
+
+	lib := make(Library)
+	for {
+		tok, err := p.peek()
+		if err == io.EOF {
+			return lib, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		if tok.kind != word {
+			return nil, p.errorf("unexpected %q", tok.text)
+		}
+
+		p.next()
+		g, err := p.statement(tok)
+		if err != nil {
+			return nil, err
+		}
+		if g != nil {
+			lib.add(g)
+		}
+	}
+}
+
+// add adds the cells of group g, which is a library or a cell, to lib.
+func (lib Library) add(g *group) {
+	switch g.kind {
+	case "library":
+		for _, sub := range g.groups {
+			lib.add(sub)
+		}
+		return
+	case "cell":
+	default:
+		return
+	}
+
+	pins := make(map[string]string)
+	set := func(class string, names ...string) {
+		for _, name := range names {
+			// A pin that is both, such as a latch enable that also clears,
+			// stays a clock pin
+			if pins[name] != Clock {
+				pins[name] = class
+			}
+		}
+	}
+
+	for _, sub := range g.groups {
+		switch sub.kind {
+		case "pin":
+			if sub.attrs["clock"] == "true" {
+				set(Clock, sub.names...)
+			}
+		case "ff", "ff_bank":
+			set(Clock, identifiers(sub.attrs["clocked_on"])...)
+			set(Reset, identifiers(sub.attrs["clear"])...)
+			set(Reset, identifiers(sub.attrs["preset"])...)
+		case "latch", "latch_bank":
+			set(Clock, identifiers(sub.attrs["enable"])...)
+			set(Reset, identifiers(sub.attrs["clear"])...)
+			set(Reset, identifiers(sub.attrs["preset"])...)
+		}
+	}
+
+	if len(pins) > 0 {
+		for _, name := range g.names {
+			lib[name] = pins
+		}
+	}
+}
+
+// identifiers returns the pin names in a Liberty boolean expression such as
+// "!RN" or "(CK * EN)'".
+func identifiers(expr string) (names []string) {
+	return strings.FieldsFunc(expr, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r) && r != '_' && r != '[' && r != ']'
+	})
+}
+
+////////////////////////////////////////////////////////////////////////////////
+
+// group is a Liberty group with its simple attributes and subgroups. Complex
+// attributes are dropped.
+type group struct {
+	kind   string
+	names  []string
+	attrs  map[string]string
+	groups []*group
+}
+
+type tokenKind int
+
+const (
+	word  tokenKind = iota // Identifier, number or quoted string
+	punct                  // One of (){}:;,
+)
+
+type token struct {
+	kind tokenKind
+	text string
+}
+
+type parser struct {
+	in     *bufio.Reader
+	line   int
+	peeked *token
+}
+
+func (p *parser) errorf(format string, args ...interface{}) error {
+	return fmt.Errorf("%v: line %d: %s", SyntaxError, p.line, fmt.Sprintf(format, args...))
+}
+
+// statement parses the rest of a group or complex attribute called name, from
+// its opening parenthesis. Only groups are returned.
+func (p *parser) statement(name token) (*group, error) {
+	tok, err := p.next()
+	if err != nil {
+		return nil, err
+	}
+	if tok.text != "(" {
+		return nil, p.errorf("unexpected %q after %q", tok.text, name.text)
+	}
+
+	args, err := p.args()
+	if err != nil {
+		return nil, err
+	}
+
+	tok, err = p.peek()
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+	if err == nil && tok.text == "{" {
+		p.next()
+		g := &group{kind: name.text, names: args, attrs: make(map[string]string)}
+		return g, p.body(g)
+	}
+
+	// A complex attribute, ended by an optional semicolon
+	if err == nil && tok.text == ";" {
+		p.next()
+	}
+	return nil, nil
+}
+
+// args parses the comma separated arguments of a group or complex attribute,
+// up to and including the closing parenthesis.
+func (p *parser) args() (args []string, err error) {
+	for {
+		tok, err := p.next()
+		if err != nil {
+			return nil, err
+		}
+		switch {
+		case tok.text == ")":
+			return args, nil
+		case tok.text == ",":
+		case tok.kind == word:
+			args = append(args, tok.text)
+		default:
+			return nil, p.errorf("unexpected %q in arguments", tok.text)
+		}
+	}
+}
+
+// body parses the statements of group g up to and including its closing
+// brace.
+func (p *parser) body(g *group) error {
+	for {
+		tok, err := p.peek()
+		if err == io.EOF {
+			return p.errorf("unterminated %s group", g.kind)
+		}
+		if err != nil {
+			return err
+		}
+		if tok.text == "}" {
+			p.next()
+			return nil
+		}
+		if tok.kind != word {
+			return p.errorf("unexpected %q in %s group", tok.text, g.kind)
+		}
+
+		name, _ := p.next()
+		tok, err = p.peek()
+		if err != nil {
+			return err
+		}
+
+		if tok.text != ":" {
+			sub, err := p.statement(name)
+			if err != nil {
+				return err
+			}
+			if sub != nil {
+				g.groups = append(g.groups, sub)
+			}
+			continue
+		}
+
+		p.next()
+		val, err := p.next()
+		if err != nil {
+			return err
+		}
+		if val.kind != word {
+			return p.errorf("unexpected %q as value of %q", val.text, name.text)
+		}
+		g.attrs[name.text] = val.text
+
+		// The semicolon is optional
+		if tok, err := p.peek(); err == nil && tok.text == ";" {
+			p.next()
+		}
+	}
+}
+
+func (p *parser) peek() (token, error) {
+	if p.peeked == nil {
+		tok, err := p.scan()
+		if err != nil {
+			return token{}, err
+		}
+		p.peeked = &tok
+	}
+	return *p.peeked, nil
+}
+
+func (p *parser) next() (token, error) {
+	tok, err := p.peek()
+	p.peeked = nil
+	return tok, err
+}
+
+// scan reads the next token, skipping white space, comments and line
+// continuations.
+func (p *parser) scan() (token, error) {
+	for {
+		r, _, err := p.in.ReadRune()
+		if err != nil {
+			return token{}, err
+		}
+
+		switch {
+		case r == '\n':
+			p.line++
+
+		case unicode.IsSpace(r) || r == '\\':
+
+		case r == '/':
+			next, _, err := p.in.ReadRune()
+			if err != nil {
+				return token{}, p.errorf("unexpected end after /")
+			}
+			switch next {
+			case '*':
+				if err := p.skipComment(); err != nil {
+					return token{}, err
+				}
+			case '/':
+				line, _ := p.in.ReadString('\n')
+				if strings.HasSuffix(line, "\n") {
+					p.line++
+				}
+			default:
+				// Part of a word such as a unit
+				p.in.UnreadRune()
+				return p.word("/")
+			}
+
+		case r == '"':
+			str, err := p.in.ReadString('"')
+			if err != nil {
+				return token{}, p.errorf("unterminated string")
+			}
+			p.line += strings.Count(str, "\n")
+			return token{word, strings.TrimSuffix(str, `"`)}, nil
+
+		case strings.ContainsRune("(){}:;,", r):
+			return token{punct, string(r)}, nil
+
+		default:
+			return p.word(string(r))
+		}
+	}
+}
+
+func (p *parser) skipComment() error {
+	star := false
+	for {
+		r, _, err := p.in.ReadRune()
+		if err != nil {
+			return p.errorf("unterminated comment")
+		}
+		if r == '\n' {
+			p.line++
+		}
+		if star && r == '/' {
+			return nil
+		}
+		star = r == '*'
+	}
+}
+
+// word reads the rest of a word that starts with prefix.
+func (p *parser) word(prefix string) (token, error) {
+	var b strings.Builder
+	b.WriteString(prefix)
+	for {
+		r, _, err := p.in.ReadRune()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return token{}, err
+		}
+		if unicode.IsSpace(r) || strings.ContainsRune(`(){}:;,"`, r) {
+			p.in.UnreadRune()
+			break
+		}
+		b.WriteRune(r)
+	}
+	return token{word, b.String()}, nil
+}
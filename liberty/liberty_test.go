@@ -0,0 +1,84 @@
+package liberty
+
+import (
+	"strings"
+	"testing"
+)
+
+const testLibrary = `
+/* A made up library */
+library (test) {
+  time_unit : "1ns" ;
+  capacitive_load_unit (1, ff);
+  cell (DFFR) {
+    area : 4.5;
+    ff (IQ, IQN) {
+      next_state : "D" ;
+      clocked_on : "CK" ;
+      clear : "!RN" ;
+    }
+    pin (CK) { direction : input; clock : true; }
+    pin (D, RN) { direction : input; }
+    pin (Q) {
+      direction : output ;
+      function : "IQ" ;
+      timing () {
+        related_pin : "CK" ;
+        values ("0.1, 0.2", \
+                "0.3, 0.4");
+      }
+    }
+  }
+  cell (LATQ) {
+    latch (IQ, IQN) { data_in : "D"; enable : "G"; preset : "SN'" }
+  }
+  cell (CKBUF) {
+    // A clock buffer has no clock pin of its own
+    pin (A) { direction : input }
+    pin (Z) { direction : output; function : "A" }
+  }
+  cell (ICG) {
+    pin (CK) { clock : true }
+  }
+}
+`
+
+func TestLoad(t *testing.T) {
+	lib, err := Load(strings.NewReader(testLibrary))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, test := range []struct {
+		cell, pin, exp string
+	}{
+		{"DFFR", "CK", Clock},
+		{"DFFR", "RN", Reset},
+		{"DFFR", "D", ""},
+		{"DFFR", "Q", ""},
+		{"LATQ", "G", Clock},
+		{"LATQ", "SN", Reset},
+		{"ICG", "CK", Clock},
+	} {
+		if got := lib[test.cell][test.pin]; got != test.exp {
+			t.Errorf("Expecting %s/%s to be %q. Got %q", test.cell, test.pin, test.exp, got)
+		}
+	}
+
+	if _, found := lib["CKBUF"]; found {
+		t.Errorf("Expecting no clock or reset pins for CKBUF")
+	}
+}
+
+func TestLoadErrors(t *testing.T) {
+	for _, text := range []string{
+		"library (x) { cell (y) { pin (a) { clock : true; }",
+		"library (x) { cell (y) { : } }",
+		"library (x) { /* open",
+		"library x { }",
+	} {
+		if _, err := Load(strings.NewReader(text)); err == nil {
+			t.Errorf("Expecting an error for %q", text)
+		}
+	}
+}
@@ -0,0 +1,300 @@
+// Package lint checks the connectivity of a built netlist, one module at a
+// time. Each rule has a severity that can be changed or turned off, and
+// findings that are known to be fine can be waived.
+//
+// A rules file looks like this:
+//
+//	{
+//		"severity": {"wire-no-load": "info", "multi-driver": "off"},
+//		"waivers": [
+//			{"rule": "undriven-input", "module": "^dfx_", "name": "scan_in$", "reason": "tied off in layout"}
+//		]
+//	}
+package lint
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"regexp"
+	"sart/netlist"
+	"sart/rtl"
+	"sort"
+)
+
+// Rules
+const (
+	WireNoDriver      = "wire-no-driver"     // Wire that nothing drives
+	WireNoLoad        = "wire-no-load"       // Wire that drives nothing
+	MultiDriver       = "multi-driver"       // Wire with more than one driver
+	UndrivenInput     = "undriven-input"     // Input port of an instance that nothing drives
+	UnconnectedOutput = "unconnected-output" // Output port of an instance that drives nothing
+	ConnCount         = "conn-count"         // Instance connections differ from subckt ports
+	EmptyDirection    = "empty-direction"    // Port that was never given a direction
+)
+
+// Severities
+const (
+	Error = "error"
+	Warn  = "warn"
+	Info  = "info"
+	Off   = "off"
+)
+
+// Severity is the severity of every rule unless a rules file says otherwise.
+var Severity = map[string]string{
+	WireNoDriver:      Warn,
+	WireNoLoad:        Info,
+	MultiDriver:       Error,
+	UndrivenInput:     Warn,
+	UnconnectedOutput: Info,
+	ConnCount:         Error,
+	EmptyDirection:    Error,
+}
+
+var UnknownRule error = fmt.Errorf("-!- Unknown Rule Error")
+
+type Finding struct {
+	Rule     string `json:"rule"`
+	Severity string `json:"severity"`
+	Module   string `json:"module"`
+	Name     string `json:"name"`
+	Message  string `json:"message"`
+	Waived   string `json:"waived,omitempty"` // Reason given by the waiver
+}
+
+type Waiver struct {
+	Rule   string `json:"rule"`
+	Module string `json:"module"` // Regex; empty matches any module
+	Name   string `json:"name"`   // Regex; empty matches any name
+	Reason string `json:"reason"`
+
+	module, name *regexp.Regexp
+}
+
+func (w Waiver) match(f Finding) bool {
+	if w.Rule != "" && w.Rule != f.Rule {
+		return false
+	}
+	if w.module != nil && !w.module.MatchString(f.Module) {
+		return false
+	}
+	if w.name != nil && !w.name.MatchString(f.Name) {
+		return false
+	}
+	return true
+}
+
+type Rules struct {
+	Severity map[string]string `json:"severity"`
+	Waivers  []*Waiver         `json:"waivers"`
+}
+
+// Default returns the rules with default severities and no waivers.
+func Default() *Rules {
+	r := &Rules{Severity: make(map[string]string)}
+	for rule, sev := range Severity {
+		r.Severity[rule] = sev
+	}
+	return r
+}
+
+// LoadRules reads a rules file. Rules it does not mention keep their default
+// severity. Every waiver must give a reason.
+func LoadRules(in io.Reader) (*Rules, error) {
+	var file Rules
+	err := json.NewDecoder(in).Decode(&file)
+	if err != nil {
+		return nil, err
+	}
+
+	r := Default()
+	for rule, sev := range file.Severity {
+		if _, found := Severity[rule]; !found {
+			return nil, fmt.Errorf("%v: %q", UnknownRule, rule)
+		}
+		switch sev {
+		case Error, Warn, Info, Off:
+		default:
+			return nil, fmt.Errorf("rule %q: unknown severity %q", rule, sev)
+		}
+		r.Severity[rule] = sev
+	}
+
+	for _, w := range file.Waivers {
+		if _, found := Severity[w.Rule]; w.Rule != "" && !found {
+			return nil, fmt.Errorf("waiver: %v: %q", UnknownRule, w.Rule)
+		}
+		// A waived finding is told apart by its reason, so one is required
+		if w.Reason == "" {
+			return nil, fmt.Errorf("waiver %q %q %q: no reason given", w.Rule, w.Module, w.Name)
+		}
+		if w.Module != "" {
+			if w.module, err = regexp.Compile(w.Module); err != nil {
+				return nil, fmt.Errorf("waiver: %v", err)
+			}
+		}
+		if w.Name != "" {
+			if w.name, err = regexp.Compile(w.Name); err != nil {
+				return nil, fmt.Errorf("waiver: %v", err)
+			}
+		}
+		r.Waivers = append(r.Waivers, w)
+	}
+
+	return r, nil
+}
+
+////////////////////////////////////////////////////////////////////////////////
+
+// Module checks the template netlist n of module def. Definitions of the
+// modules it instantiates are fetched from defs. Findings of rules that are
+// off are dropped; waived findings are kept and marked so.
+func (r *Rules) Module(n *netlist.Netlist, def *rtl.Module, defs rtl.ModuleLoader) (findings []Finding) {
+	report := func(rule, name, format string, args ...interface{}) {
+		sev := r.Severity[rule]
+		if sev == Off {
+			return
+		}
+		f := Finding{
+			Rule:     rule,
+			Severity: sev,
+			Module:   def.Name,
+			Name:     name,
+			Message:  fmt.Sprintf(format, args...),
+		}
+		for _, w := range r.Waivers {
+			if w.match(f) {
+				f.Waived = w.Reason
+				break
+			}
+		}
+		findings = append(findings, f)
+	}
+
+	for fullname, node := range n.Nodes {
+		if !node.IsWire {
+			continue
+		}
+		switch drivers := strictDrivers(n, fullname); {
+		case len(n.Rlinks[fullname]) == 0:
+			report(WireNoDriver, node.Name, "wire has no driver")
+		case drivers > 1:
+			report(MultiDriver, node.Name, "wire has %d drivers", drivers)
+		}
+		if len(n.Links[fullname]) == 0 {
+			report(WireNoLoad, node.Name, "wire has no load")
+		}
+	}
+
+	for _, subnet := range n.Subnets {
+		iname := subnet.Shortname()
+		for fullname, port := range subnet.Inputs {
+			if len(n.Rlinks[fullname]) == 0 {
+				report(UndrivenInput, iname+"/"+port.Name,
+					"input %q of %s instance %q is not driven", port.Name, subnet.Type, iname)
+			}
+		}
+		for fullname, port := range subnet.Outputs {
+			if len(n.Links[fullname]) == 0 {
+				report(UnconnectedOutput, iname+"/"+port.Name,
+					"output %q of %s instance %q is not connected", port.Name, subnet.Type, iname)
+			}
+		}
+	}
+
+	for iname, inst := range def.Insts {
+		if inst.IsPrim {
+			continue
+		}
+		conns := len(def.Conns[iname])
+		ports := defs.LoadModule(inst.Type).NumPorts()
+		if conns != ports {
+			report(ConnCount, iname, "%s instance %q has %d connections for %d ports",
+				inst.Type, iname, conns, ports)
+		}
+	}
+
+	for _, port := range def.Ports {
+		if port.Type == "" {
+			report(EmptyDirection, port.Name, "port %q has no direction", port.Name)
+		}
+	}
+
+	Sort(findings)
+	return
+}
+
+// strictDrivers counts the nodes that drive node fullname without also being
+// driven by it. Inout connections link both ways and are not counted, as any
+// number of them can share a wire.
+func strictDrivers(n *netlist.Netlist, fullname string) (count int) {
+	loads := make(map[*netlist.Node]bool)
+	for _, l := range n.Links[fullname] {
+		loads[l] = true
+	}
+	for _, d := range n.Rlinks[fullname] {
+		if !loads[d] {
+			count++
+		}
+	}
+	return
+}
+
+// Sort orders findings by module, rule and name so that reports can be
+// compared from run to run.
+func Sort(findings []Finding) {
+	sort.Slice(findings, func(i, j int) bool {
+		a, b := findings[i], findings[j]
+		if a.Module != b.Module {
+			return a.Module < b.Module
+		}
+		if a.Rule != b.Rule {
+			return a.Rule < b.Rule
+		}
+		return a.Name < b.Name
+	})
+}
+
+////////////////////////////////////////////////////////////////////////////////
+
+// Report is the machine-readable outcome of a lint run.
+type Report struct {
+	Top      string         `json:"top"`
+	Modules  int            `json:"modules"`
+	Counts   map[string]int `json:"counts"` // Unwaived findings by severity
+	Waived   int            `json:"waived"`
+	Findings []Finding      `json:"findings"`
+}
+
+func NewReport(top string) *Report {
+	return &Report{
+		Top:      top,
+		Counts:   make(map[string]int),
+		Findings: []Finding{},
+	}
+}
+
+// Add adds the findings of one module to the report.
+func (rep *Report) Add(findings []Finding) {
+	rep.Modules++
+	for _, f := range findings {
+		if f.Waived != "" {
+			rep.Waived++
+		} else {
+			rep.Counts[f.Severity]++
+		}
+	}
+	rep.Findings = append(rep.Findings, findings...)
+}
+
+// Errors returns the number of unwaived findings with error severity.
+func (rep *Report) Errors() int {
+	return rep.Counts[Error]
+}
+
+func (rep *Report) Write(out io.Writer) error {
+	enc := json.NewEncoder(out)
+	enc.SetIndent("", "  ")
+	return enc.Encode(rep)
+}
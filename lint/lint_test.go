@@ -0,0 +1,143 @@
+package lint
+
+import (
+	"sart/netlist"
+	"sart/rtl"
+	"strings"
+	"testing"
+)
+
+type defs map[string]*rtl.Module
+
+func (d defs) LoadModule(name string) *rtl.Module {
+	return d[name]
+}
+
+// testModule returns module m, which instantiates sub twice with one
+// connection too few on u2, and its template netlist:
+//
+//	a -> w1 -> u1/i     u1/o -> (nothing)
+//	     w2 -> (nothing)
+//	g1 -> w3 <- g2
+//	               u2/i (undriven)
+func testModule() (*netlist.Netlist, *rtl.Module, defs) {
+	sub := rtl.NewModule("sub")
+	for pos, name := range []string{"i", "o"} {
+		p := rtl.NewPort("sub", name, pos)
+		p.Type = map[string]string{"i": "INPUT", "o": "OUTPUT"}[name]
+		sub.AddPort(p)
+	}
+
+	m := rtl.NewModule("m")
+	a := rtl.NewPort("m", "a", 0)
+	a.Type = "INPUT"
+	m.AddPort(a)
+	m.AddPort(rtl.NewPort("m", "z", 1)) // No direction
+	m.AddInst(rtl.NewInst("m", "u1", "sub"))
+	m.AddInst(rtl.NewInst("m", "u2", "sub"))
+	m.AddConn(rtl.NewConn("m", "u1", "sub", "w1", 0))
+	m.AddConn(rtl.NewConn("m", "u1", "sub", "x", 1))
+	m.AddConn(rtl.NewConn("m", "u2", "sub", "y", 0))
+
+	n := netlist.NewNetlist("m")
+	n.Type = "m"
+	an := netlist.NewPortNode("m", "a", "INPUT")
+	w1 := netlist.NewWireNode("m", "w1")
+	w2 := netlist.NewWireNode("m", "w2")
+	w3 := netlist.NewWireNode("m", "w3")
+	g1 := netlist.NewPrimNode("m", "g1", "nand")
+	g2 := netlist.NewPrimNode("m", "g2", "nand")
+	for _, node := range []*netlist.Node{an, w1, w2, w3, g1, g2} {
+		n.AddNode(node)
+	}
+
+	for _, iname := range []string{"u1", "u2"} {
+		s := netlist.NewNetlist("m/" + iname)
+		s.Type = "sub"
+		s.AddNode(netlist.NewPortNode(s.Name, "i", "INPUT"))
+		s.AddNode(netlist.NewPortNode(s.Name, "o", "OUTPUT"))
+		n.Subnets[s.Name] = s
+	}
+
+	n.Connect(an, w1)
+	n.Connect(w1, n.LocateNode("m/u1/i"))
+	n.Connect(g1, w3)
+	n.Connect(g2, w3)
+
+	return n, m, defs{"m": m, "sub": sub}
+}
+
+func rules(findings []Finding) (got []string) {
+	for _, f := range findings {
+		s := f.Rule + ":" + f.Name
+		if f.Waived != "" {
+			s += ":waived"
+		}
+		got = append(got, s)
+	}
+	return
+}
+
+func TestModule(t *testing.T) {
+	n, m, d := testModule()
+
+	got := rules(Default().Module(n, m, d))
+	exp := []string{
+		"conn-count:u2",
+		"empty-direction:z",
+		"multi-driver:w3",
+		"unconnected-output:u1/o",
+		"unconnected-output:u2/o",
+		"undriven-input:u2/i",
+		"wire-no-driver:w2",
+		"wire-no-load:w2",
+		"wire-no-load:w3",
+	}
+	if strings.Join(got, " ") != strings.Join(exp, " ") {
+		t.Errorf("Expecting findings\n%v\nGot\n%v", exp, got)
+	}
+}
+
+func TestRules(t *testing.T) {
+	n, m, d := testModule()
+
+	r, err := LoadRules(strings.NewReader(`{
+		"severity": {"wire-no-load": "off", "unconnected-output": "off"},
+		"waivers": [{"rule": "multi-driver", "name": "^w3$", "reason": "wired or"}]
+	}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	findings := r.Module(n, m, d)
+	got := rules(findings)
+	exp := []string{
+		"conn-count:u2",
+		"empty-direction:z",
+		"multi-driver:w3:waived",
+		"undriven-input:u2/i",
+		"wire-no-driver:w2",
+	}
+	if strings.Join(got, " ") != strings.Join(exp, " ") {
+		t.Errorf("Expecting findings\n%v\nGot\n%v", exp, got)
+	}
+
+	rep := NewReport("m")
+	rep.Add(findings)
+	if rep.Errors() != 2 || rep.Waived != 1 || rep.Counts[Warn] != 2 {
+		t.Errorf("Unexpected report counts %v waived:%d", rep.Counts, rep.Waived)
+	}
+}
+
+func TestLoadRulesErrors(t *testing.T) {
+	for _, rules := range []string{
+		`{"severity": {"no-such-rule": "error"}}`,
+		`{"severity": {"multi-driver": "fatal"}}`,
+		`{"waivers": [{"rule": "multi-driver", "name": "(", "reason": "bad regex"}]}`,
+		`{"waivers": [{"rule": "multi-driver", "name": "^w3$"}]}`,
+	} {
+		if _, err := LoadRules(strings.NewReader(rules)); err == nil {
+			t.Errorf("Expecting an error for rules %s", rules)
+		}
+	}
+}
@@ -0,0 +1,94 @@
+// Package meta keeps the metadata of a cache in its own collection in the
+// default database of the session: the schema version, i.e. the version of the
+// document layout used by the rtl and netlist collections of the cache, and
+// whether the last step that wrote to the cache completed.
+package meta
+
+import (
+	"fmt"
+	"log"
+
+	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// Current is the schema version written by this tree. Caches with an older
+// version must be migrated before use. A cache without a version document
+// predates versioning and is treated as version 0.
+const Current = 3
+
+var OutdatedCache error = fmt.Errorf("-!- Outdated Cache Error")
+var NewerCache error = fmt.Errorf("-!- Newer Cache Error")
+
+const versionid = "schema"
+
+type versionDoc struct {
+	ID      string `bson:"_id"`
+	Version int    `bson:"version"`
+}
+
+// Collection returns the name of the collection that holds the metadata of
+// cache cname.
+func Collection(cname string) string {
+	return cname + "_meta"
+}
+
+// Version returns the schema version of cache cname.
+func Version(s *mgo.Session, cname string) (int, error) {
+	var doc versionDoc
+
+	err := s.DB("").C(Collection(cname)).FindId(versionid).One(&doc)
+	if err == mgo.ErrNotFound {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	return doc.Version, nil
+}
+
+// SetVersion records version as the schema version of cache cname.
+func SetVersion(s *mgo.Session, cname string, version int) error {
+	_, err := s.DB("").C(Collection(cname)).UpsertId(versionid,
+		bson.M{"$set": bson.M{"version": version}})
+	return err
+}
+
+// Stamp marks cache cname as having the Current schema version. It is to be
+// called by whatever creates a cache from scratch.
+func Stamp(s *mgo.Session, cname string) error {
+	return SetVersion(s, cname, Current)
+}
+
+// Check makes sure cache cname can be used by this tree. An older cache is
+// refused unless migrate is set, in which case it is upgraded in place
+// through the registered migrations. A warning is logged if the cache was
+// left incomplete by a failed or interrupted run.
+func Check(s *mgo.Session, cname string, migrate bool) error {
+	version, err := Version(s, cname)
+	if err != nil {
+		return err
+	}
+
+	st, err := GetState(s, cname)
+	if err != nil {
+		return err
+	}
+	if !st.Complete {
+		log.Printf("WARNING: cache %q was left incomplete: %v", cname, st)
+	}
+
+	switch {
+	case version == Current:
+		return nil
+	case version > Current:
+		return fmt.Errorf("%v: cache %q has schema version %d, this tool knows up to %d",
+			NewerCache, cname, version, Current)
+	case !migrate:
+		return fmt.Errorf("%v: cache %q has schema version %d, expecting %d. Rerun with -migrate to upgrade it",
+			OutdatedCache, cname, version, Current)
+	}
+
+	return Default.Migrate(s, cname, version, Current)
+}
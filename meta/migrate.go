@@ -0,0 +1,104 @@
+package meta
+
+import (
+	"fmt"
+	"log"
+	"sort"
+
+	"gopkg.in/mgo.v2"
+)
+
+var MissingMigration error = fmt.Errorf("-!- Missing Migration Error")
+
+// Migration upgrades the collections of a cache from schema version From to
+// From+1. The migrations of the caches sart writes are registered in
+// migrations.go, so that every package that checks a cache can migrate it.
+type Migration struct {
+	From int
+	Desc string
+	Up   func(d *mgo.Database, cname string) error
+}
+
+func (m Migration) String() string {
+	return fmt.Sprintf("v%d->v%d %s", m.From, m.From+1, m.Desc)
+}
+
+////////////////////////////////////////////////////////////////////////////////
+
+type Registry struct {
+	steps map[int]Migration
+}
+
+func NewRegistry() *Registry {
+	return &Registry{
+		steps: make(map[int]Migration),
+	}
+}
+
+// Default holds the migrations that Check applies.
+var Default = NewRegistry()
+
+// Register adds a migration to the Default registry.
+func Register(m Migration) {
+	Default.Register(m)
+}
+
+func (r *Registry) Register(m Migration) {
+	if _, found := r.steps[m.From]; found {
+		log.Panicf("Duplicate migration from schema version %d", m.From)
+	}
+	r.steps[m.From] = m
+}
+
+// Plan returns, in order, the migrations needed to take a cache from schema
+// version from to version to.
+func (r Registry) Plan(from, to int) (plan []Migration, err error) {
+	for v := from; v < to; v++ {
+		m, found := r.steps[v]
+		if !found {
+			return nil, fmt.Errorf("%v: no migration from schema version %d",
+				MissingMigration, v)
+		}
+		plan = append(plan, m)
+	}
+	return
+}
+
+// Versions returns the schema versions that have a migration registered, in
+// ascending order.
+func (r Registry) Versions() (versions []int) {
+	for v := range r.steps {
+		versions = append(versions, v)
+	}
+	sort.Ints(versions)
+	return
+}
+
+// Migrate upgrades cache cname from schema version from to version to. The
+// version is recorded after every step so that a failed migration can be
+// resumed from where it stopped.
+func (r Registry) Migrate(s *mgo.Session, cname string, from, to int) error {
+	plan, err := r.Plan(from, to)
+	if err != nil {
+		return err
+	}
+
+	sess := s.Copy()
+	defer sess.Close()
+
+	for _, m := range plan {
+		log.Printf("Migrating cache %q: %v", cname, m)
+
+		err = m.Up(sess.DB(""), cname)
+		if err != nil {
+			return fmt.Errorf("migration %v: %v", m, err)
+		}
+
+		err = SetVersion(sess, cname, m.From+1)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,80 @@
+package meta
+
+import (
+	"testing"
+
+	"gopkg.in/mgo.v2"
+)
+
+func nop(d *mgo.Database, cname string) error {
+	return nil
+}
+
+func TestPlan(t *testing.T) {
+	r := NewRegistry()
+	r.Register(Migration{From: 1, Desc: "second", Up: nop})
+	r.Register(Migration{From: 0, Desc: "first", Up: nop})
+	r.Register(Migration{From: 3, Desc: "fourth", Up: nop})
+
+	testcases := []struct {
+		from, to int
+		exp      []int
+		fail     bool
+	}{
+		{0, 0, nil, false},
+		{0, 1, []int{0}, false},
+		{0, 2, []int{0, 1}, false},
+		{1, 2, []int{1}, false},
+		{0, 3, nil, true},
+		{2, 4, nil, true},
+		{3, 4, []int{3}, false},
+	}
+
+	for _, tc := range testcases {
+		plan, err := r.Plan(tc.from, tc.to)
+		if tc.fail {
+			if err == nil {
+				t.Errorf("Expecting plan %d->%d to fail. Got %v", tc.from, tc.to, plan)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("Unexpected error for plan %d->%d: %v", tc.from, tc.to, err)
+			continue
+		}
+		if len(plan) != len(tc.exp) {
+			t.Errorf("Expecting %d steps for plan %d->%d. Got %d", len(tc.exp),
+				tc.from, tc.to, len(plan))
+			continue
+		}
+		for i, m := range plan {
+			if m.From != tc.exp[i] {
+				t.Errorf("Expecting step %d of plan %d->%d from v%d. Got v%d", i,
+					tc.from, tc.to, tc.exp[i], m.From)
+			}
+		}
+	}
+}
+
+func TestDuplicate(t *testing.T) {
+	r := NewRegistry()
+	r.Register(Migration{From: 0, Up: nop})
+
+	defer func() {
+		if recover() == nil {
+			t.Errorf("Expecting a panic on duplicate registration")
+		}
+	}()
+	r.Register(Migration{From: 0, Up: nop})
+}
+
+func TestVersions(t *testing.T) {
+	r := NewRegistry()
+	r.Register(Migration{From: 2, Up: nop})
+	r.Register(Migration{From: 0, Up: nop})
+
+	v := r.Versions()
+	if len(v) != 2 || v[0] != 0 || v[1] != 2 {
+		t.Errorf("Expecting versions [0 2]. Got %v", v)
+	}
+}
@@ -0,0 +1,127 @@
+package meta
+
+import (
+	"sart/bitfield"
+
+	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// The migrations live here rather than with the packages whose documents they
+// rewrite, so that every command that checks a cache can also migrate it,
+// whatever else it imports. Each works on the layout of the version it
+// migrates from, which is why it names collections and fields itself.
+
+// v1node is the part of a netlist node document that version 1 migrates.
+type v1node struct {
+	Parent string             `bson:"module"`
+	Name   string             `bson:"name"`
+	IsAce  bool               `bson:"isace"`
+	RpAce  *bitfield.BitField `bson:"rpace"`
+	WpAce  *bitfield.BitField `bson:"wpace"`
+}
+
+// v2netlist returns the collections that held the netlist of cache cname up
+// to version 2.
+func v2netlist(cname string) []string {
+	return []string{
+		cname + "_nnodes",
+		cname + "_nlinks",
+		cname + "_nsnets",
+		cname + "_nstate",
+	}
+}
+
+func init() {
+	// Version 0 caches carry a 'walked' field in node documents that was set by
+	// MarkAceNodes and never read back.
+	Register(Migration{
+		From: 0,
+		Desc: "drop unused walked field from netlist nodes",
+		Up: func(d *mgo.Database, cname string) error {
+			_, err := d.C(cname+"_nnodes").UpdateAll(
+				bson.M{"walked": bson.M{"$exists": true}},
+				bson.M{"$unset": bson.M{"walked": ""}},
+			)
+			return err
+		},
+	})
+
+	// Version 1 caches hold rpace and wpace as hex strings and have no touched
+	// flag. Reading a node accepts the old form, so rewriting it re-encodes
+	// its bitfields.
+	Register(Migration{
+		From: 1,
+		Desc: "re-encode netlist node bitfields and set touched flag",
+		Up: func(d *mgo.Database, cname string) error {
+			c := d.C(cname + "_nnodes")
+			sel := bson.M{"$or": []bson.M{
+				bson.M{"rpace": bson.M{"$type": 2}},
+				bson.M{"wpace": bson.M{"$type": 2}},
+			}}
+
+			var node v1node
+			var pending int
+			b := c.Bulk()
+			b.Unordered()
+
+			iter := c.Find(sel).Iter()
+			for iter.Next(&node) {
+				touched := node.IsAce ||
+					!node.RpAce.AllUnset() || !node.WpAce.AllUnset()
+				b.Update(
+					bson.M{"module": node.Parent, "name": node.Name},
+					bson.M{"$set": bson.M{
+						"rpace":   node.RpAce,
+						"wpace":   node.WpAce,
+						"touched": touched,
+					}},
+				)
+				pending++
+				node = v1node{}
+
+				if pending == migrateBatch {
+					if _, err := b.Run(); err != nil {
+						iter.Close()
+						return err
+					}
+					b = c.Bulk()
+					b.Unordered()
+					pending = 0
+				}
+			}
+			if err := iter.Close(); err != nil {
+				return err
+			}
+
+			if pending > 0 {
+				if _, err := b.Run(); err != nil {
+					return err
+				}
+			}
+			return nil
+		},
+	})
+
+	// Version 2 caches hold a flattened netlist, saved once per instance. It
+	// cannot be turned into templates without the module definitions, so it
+	// is dropped and the cache is flagged for a rebuild, which recreates the
+	// collections and their indexes.
+	Register(Migration{
+		From: 2,
+		Desc: "drop flattened netlist; rebuild to save module templates",
+		Up: func(d *mgo.Database, cname string) error {
+			for _, coll := range v2netlist(cname) {
+				err := d.C(coll).DropCollection()
+				if err != nil && err.Error() != "ns not found" {
+					return err
+				}
+			}
+			return Begin(d.Session, cname, "build")
+		},
+	})
+}
+
+// migrateBatch is the number of node updates sent to mongo at once by
+// migrations that rewrite every node.
+const migrateBatch = 1000
@@ -0,0 +1,53 @@
+package meta
+
+import (
+	"testing"
+	"time"
+
+	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// TestCheckMigrate migrates a version 0 cache through the Default registry,
+// as a command that does not import netlist would.
+func TestCheckMigrate(t *testing.T) {
+	s, err := mgo.DialWithTimeout("localhost/test", time.Second)
+	if err != nil {
+		t.Skip("no mongo on localhost:", err)
+	}
+	defer s.Close()
+
+	const cname = "test_migrate"
+	drop := func() {
+		for _, coll := range append(v2netlist(cname), Collection(cname)) {
+			s.DB("").C(coll).DropCollection()
+		}
+	}
+	drop()
+	defer drop()
+
+	err = s.DB("").C(cname + "_nnodes").Insert(bson.M{
+		"module": "top", "name": "top/a", "isace": true,
+		"rpace": "01", "wpace": "00", "walked": true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := Check(s, cname, false); err == nil {
+		t.Errorf("Expecting a version 0 cache to fail the check without migrate")
+	}
+	if err := Check(s, cname, true); err != nil {
+		t.Fatal(err)
+	}
+
+	if v, err := Version(s, cname); err != nil || v != Current {
+		t.Errorf("Expecting version %d. Got %d %v", Current, v, err)
+	}
+	if n, _ := s.DB("").C(cname + "_nnodes").Count(); n != 0 {
+		t.Errorf("Expecting the old netlist to be dropped. Got %d nodes", n)
+	}
+	if st, err := GetState(s, cname); err != nil || st.Complete || st.Stage != "build" {
+		t.Errorf("Expecting the cache to need a build. Got %v %v", st, err)
+	}
+}
@@ -0,0 +1,75 @@
+package meta
+
+import (
+	"fmt"
+	"time"
+
+	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+)
+
+var IncompleteCache error = fmt.Errorf("-!- Incomplete Cache Error")
+
+const stateid = "state"
+
+// State records whether the last step that wrote to a cache ran to completion.
+// A step that fails or is interrupted leaves the cache marked incomplete.
+type State struct {
+	Complete bool      `bson:"complete"`
+	Stage    string    `bson:"stage"`
+	Updated  time.Time `bson:"updated"`
+}
+
+func (st State) String() string {
+	status := "complete"
+	if !st.Complete {
+		status = "incomplete"
+	}
+	return fmt.Sprintf("%s %s at %v", st.Stage, status, st.Updated.Format(time.RFC3339))
+}
+
+// GetState returns the state of cache cname. A cache that has never been
+// marked is reported complete.
+func GetState(s *mgo.Session, cname string) (State, error) {
+	var st State
+
+	err := s.DB("").C(Collection(cname)).FindId(stateid).One(&st)
+	if err == mgo.ErrNotFound {
+		return State{Complete: true}, nil
+	}
+	return st, err
+}
+
+func setState(s *mgo.Session, cname, stage string, complete bool) error {
+	_, err := s.DB("").C(Collection(cname)).UpsertId(stateid, bson.M{
+		"$set": bson.M{
+			"complete": complete,
+			"stage":    stage,
+			"updated":  time.Now(),
+		},
+	})
+	return err
+}
+
+// Begin marks cache cname incomplete before stage starts writing to it.
+func Begin(s *mgo.Session, cname, stage string) error {
+	return setState(s, cname, stage, false)
+}
+
+// Finish marks cache cname complete once stage has written everything.
+func Finish(s *mgo.Session, cname, stage string) error {
+	return setState(s, cname, stage, true)
+}
+
+// CheckComplete returns an IncompleteCache error if the last stage that wrote
+// to cache cname did not finish.
+func CheckComplete(s *mgo.Session, cname string) error {
+	st, err := GetState(s, cname)
+	if err != nil {
+		return err
+	}
+	if !st.Complete {
+		return fmt.Errorf("%v: cache %q: %v", IncompleteCache, cname, st)
+	}
+	return nil
+}
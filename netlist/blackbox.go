@@ -0,0 +1,197 @@
+package netlist
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"regexp"
+	"sart/ace"
+	"sart/rtl"
+	"sort"
+)
+
+// How ACE terms cross an arc of a black box
+const (
+	Pass    = "pass"    // Terms go through as they would through a prim
+	Stop    = "stop"    // Terms go no further
+	Replace = "replace" // Terms are dropped and a fixed ACE value put out instead
+)
+
+// BlackBox is a module that New does not elaborate. Its template holds its
+// ports and, for each arc of its transfer model, a prim node that the input
+// ports of the arc drive and that drives its output ports.
+type BlackBox struct {
+	Module string `json:"module"`
+
+	// Ports in order, needed only when the cache has no definition of the
+	// module. Ports that are only driven by arcs are outputs, those that only
+	// drive arcs are inputs and those that do both are inouts.
+	Ports []string `json:"ports"`
+
+	Arcs []Arc `json:"arcs"`
+}
+
+// Arc says which input ports of a black box feed which of its outputs.
+type Arc struct {
+	Name string   `json:"name"` // "arc<i>" if not given
+	From []string `json:"from"`
+	To   []string `json:"to"`
+	Ace  string   `json:"ace"` // Pass if not given, Stop or Replace
+
+	// Fixed ACE value put out by a Replace arc
+	Rpavf float64 `json:"rpavf"`
+	Wpavf float64 `json:"wpavf"`
+}
+
+// BlackBoxSpec holds black boxes by module name.
+//
+// A black box spec file lists them:
+//
+//	[
+//		{
+//			"module": "ariqd",
+//			"arcs": [
+//				{"from": ["wdata", "waddr"], "to": ["rdata"]},
+//				{"name": "cfg", "from": ["cfg_in"], "to": ["cfg_out"], "ace": "stop"},
+//				{"name": "tune", "from": ["vref"], "to": ["trim"], "ace": "replace", "rpavf": 0.1, "wpavf": 0.1}
+//			]
+//		}
+//	]
+type BlackBoxSpec map[string]*BlackBox
+
+// LoadBlackBoxSpec reads a black box spec file.
+func LoadBlackBoxSpec(in io.Reader) (BlackBoxSpec, error) {
+	var list []*BlackBox
+	err := json.NewDecoder(in).Decode(&list)
+	if err != nil {
+		return nil, err
+	}
+
+	spec := make(BlackBoxSpec)
+	for _, bb := range list {
+		if bb.Module == "" {
+			return nil, fmt.Errorf("black box without a module")
+		}
+		if _, found := spec[bb.Module]; found {
+			return nil, fmt.Errorf("black box %q listed twice", bb.Module)
+		}
+
+		names := make(map[string]bool)
+		for i := range bb.Arcs {
+			arc := &bb.Arcs[i]
+			if arc.Name == "" {
+				arc.Name = fmt.Sprintf("arc%d", i)
+			}
+			if names[arc.Name] {
+				return nil, fmt.Errorf("black box %q: arc %q listed twice", bb.Module, arc.Name)
+			}
+			names[arc.Name] = true
+
+			switch arc.Ace {
+			case "":
+				arc.Ace = Pass
+			case Pass, Stop, Replace:
+			default:
+				return nil, fmt.Errorf("black box %q: arc %q: unknown ace %q", bb.Module, arc.Name, arc.Ace)
+			}
+		}
+
+		spec[bb.Module] = bb
+	}
+
+	return spec, nil
+}
+
+// AceStructs returns an ACE struct for each Replace arc, selecting the node of
+// the arc in every instance of its black box. They are to be added to the ACE
+// structs of the run, which sizes the bitfields of the netlist, so that the
+// nodes of the arcs are marked ACE with their fixed values as they are loaded.
+func (spec BlackBoxSpec) AceStructs() (acestructs []ace.AceStruct) {
+	for _, bb := range spec.sorted() {
+		for _, arc := range bb.Arcs {
+			if arc.Ace == Replace {
+				name := "^" + regexp.QuoteMeta(arc.node(bb.Module)) + "$"
+				acestructs = append(acestructs, ace.New("", name, arc.Rpavf, arc.Wpavf))
+			}
+		}
+	}
+	return
+}
+
+// sorted returns the black boxes in order of module name.
+func (spec BlackBoxSpec) sorted() (bbs []*BlackBox) {
+	for _, bb := range spec {
+		bbs = append(bbs, bb)
+	}
+	sort.Slice(bbs, func(i, j int) bool { return bbs[i].Module < bbs[j].Module })
+	return
+}
+
+// node returns the name of the node of the arc in black box module. The
+// module name keeps it from colliding with the signals of the module and
+// lets ACE structs tell the arcs of different black boxes apart.
+func (arc Arc) node(module string) string {
+	return module + ":" + arc.Name
+}
+
+// define returns the definition New builds black box bb from: the ports of
+// def, or those of bb if def has none, and no instances.
+func (bb *BlackBox) define(def *rtl.Module) *rtl.Module {
+	m := rtl.NewModule(bb.Module)
+
+	if def != nil && len(def.Ports) > 0 {
+		for name, port := range def.Ports {
+			m.Ports[name] = port
+		}
+		return m
+	}
+
+	in := make(map[string]bool)
+	out := make(map[string]bool)
+	for _, arc := range bb.Arcs {
+		for _, name := range arc.From {
+			in[name] = true
+		}
+		for _, name := range arc.To {
+			out[name] = true
+		}
+	}
+	for pos, name := range bb.Ports {
+		m.AddNewPort(name, pos)
+		switch {
+		case in[name] && out[name]:
+			m.SetPortType(name, "INOUT")
+		case out[name]:
+			m.SetPortType(name, "OUTPUT")
+		default:
+			m.SetPortType(name, "INPUT")
+		}
+	}
+	return m
+}
+
+// model adds the nodes and links of the transfer model of bb to n, which
+// holds its port nodes.
+func (bb *BlackBox) model(n *Netlist) {
+	port := func(name string, arc Arc) *Node {
+		node := n.Nodes[bb.Module+"/"+name]
+		if node == nil || !node.IsPort {
+			log.Fatalf("Black box %q arc %q: no port %q", bb.Module, arc.Name, name)
+		}
+		return node
+	}
+
+	for _, arc := range bb.Arcs {
+		node := NewPrimNode(bb.Module, arc.node(bb.Module), "BLACKBOX")
+		node.IsStop = arc.Ace == Stop
+		n.AddNode(node)
+
+		for _, name := range arc.From {
+			n.Connect(port(name, arc), node)
+		}
+		for _, name := range arc.To {
+			n.Connect(node, port(name, arc))
+		}
+	}
+}
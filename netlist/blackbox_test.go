@@ -0,0 +1,96 @@
+package netlist
+
+import (
+	"strings"
+	"testing"
+)
+
+const bbSpec = `[
+	{
+		"module": "bb",
+		"ports": ["a", "b", "c", "y", "z", "w"],
+		"arcs": [
+			{"from": ["a"], "to": ["y"]},
+			{"name": "cfg", "from": ["b"], "to": ["z"], "ace": "stop"},
+			{"name": "tune", "from": ["c"], "to": ["w"], "ace": "replace", "rpavf": 0.5, "wpavf": 0.25}
+		]
+	}
+]`
+
+func TestLoadBlackBoxSpec(t *testing.T) {
+	spec, err := LoadBlackBoxSpec(strings.NewReader(bbSpec))
+	if err != nil {
+		t.Fatal(err)
+	}
+	bb := spec["bb"]
+	if bb == nil || len(bb.Arcs) != 3 {
+		t.Fatalf("Expecting black box bb with 3 arcs. Got %v", spec)
+	}
+	if arc := bb.Arcs[0]; arc.Name != "arc0" || arc.Ace != Pass {
+		t.Errorf("Expecting unnamed arc to default to arc0, pass. Got %v", arc)
+	}
+
+	as := spec.AceStructs()
+	if len(as) != 1 || as[0].Selector.Name != "^bb:tune$" || as[0].Rpavf != 0.5 || as[0].Wpavf != 0.25 {
+		t.Errorf("Expecting one ACE struct for bb:tune. Got %v", as)
+	}
+
+	for _, bad := range []string{
+		`[{"arcs": []}]`,
+		`[{"module": "bb"}, {"module": "bb"}]`,
+		`[{"module": "bb", "arcs": [{"name": "x"}, {"name": "x"}]}]`,
+		`[{"module": "bb", "arcs": [{"ace": "maybe"}]}]`,
+		`{"module": "bb"}`,
+	} {
+		if _, err := LoadBlackBoxSpec(strings.NewReader(bad)); err == nil {
+			t.Errorf("Expecting error loading %s", bad)
+		}
+	}
+}
+
+// bbNetlist returns the template of black box bb of bbSpec, built from its
+// ports in the spec, with ACE struct 0 on all its inputs and ACE struct 1 on
+// its replace arc.
+func bbNetlist(t *testing.T) *Netlist {
+	spec, err := LoadBlackBoxSpec(strings.NewReader(bbSpec))
+	if err != nil {
+		t.Fatal(err)
+	}
+	bb := spec["bb"]
+
+	f := newFixture("bb", "bb", 8)
+	for _, port := range bb.define(nil).OrderedPorts() {
+		f.Port(port.Type, port.Name)
+	}
+	bb.model(f.Netlist())
+
+	return f.Ace("a", 0).Ace("b", 0).Ace("c", 0).Ace("bb:tune", 1).Netlist()
+}
+
+func TestBlackBox(t *testing.T) {
+	n := bbNetlist(t)
+
+	types := map[string]string{"a": "INPUT", "y": "OUTPUT", "w": "OUTPUT"}
+	for name, typ := range types {
+		if got := n.Nodes["bb/"+name].Type; got != typ {
+			t.Errorf("Expecting port %q of type %q. Got %q", name, typ, got)
+		}
+	}
+	if !n.Nodes["bb/bb:cfg"].IsStop || n.Nodes["bb/bb:arc0"].IsStop {
+		t.Errorf("Expecting only the stop arc to be IsStop")
+	}
+
+	n.Walk()
+
+	exp := map[string][]int{
+		"y": {0}, // Through the pass arc
+		"z": nil, // Stopped by cfg
+		"w": {1}, // Replaced by tune
+	}
+	for name, terms := range exp {
+		got := n.Nodes["bb/"+name].RpAce.Test()
+		if len(got) != len(terms) || len(got) > 0 && got[0] != terms[0] {
+			t.Errorf("Expecting %q to carry %v. Got %v", name, terms, got)
+		}
+	}
+}
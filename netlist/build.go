@@ -0,0 +1,161 @@
+package netlist
+
+import (
+	"log"
+	"runtime"
+	"sart/bulk"
+	"sart/rtl"
+	"sort"
+	"sync"
+)
+
+// New builds the template netlists of module mname and of every module it
+// instantiates, at any depth, and saves them to the store. It returns the
+// template of mname.
+//
+// Module definitions are fetched from mongo by a pool of bulk.Workers
+// goroutines and templates built by one goroutine per CPU. Each template
+// depends only on the definition of its module, so the saved netlist is the
+// same whatever order they finish in.
+func (st *Store) New(mname string) *Netlist {
+	defs := st.fetchAll(mname)
+	order, levels := buildOrder(mname, defs)
+
+	built := make([]*Netlist, len(order))
+	done := make([]chan struct{}, len(order))
+	for i := range done {
+		done[i] = make(chan struct{})
+	}
+
+	// The writer is started before the workers, which check it for errors
+	st.startWriter()
+
+	jobs := make(chan int)
+	go func() {
+		for i := range order {
+			jobs <- i
+		}
+		close(jobs)
+	}()
+
+	for w := 0; w < runtime.GOMAXPROCS(0); w++ {
+		go func() {
+			for i := range jobs {
+				// Once saving has failed or been cancelled there is no point
+				// in building any further. Wait reports what went wrong.
+				if !st.Stopped() {
+					built[i] = st.build(defs[order[i]], defs)
+					st.Save(built[i])
+					if !st.OrderedLog {
+						log.Printf("Done (%d) %q", levels[i], order[i])
+					}
+				}
+				close(done[i])
+			}
+		}()
+	}
+
+	for i := range order {
+		<-done[i]
+		if st.OrderedLog && built[i] != nil {
+			log.Printf("Done (%d) %q", levels[i], order[i])
+		}
+	}
+
+	// The top is last in the build order
+	if top := built[len(built)-1]; top != nil {
+		return top
+	}
+	return NewNetlist(mname)
+}
+
+// fetchAll fetches the definitions of module mname and of every module it
+// instantiates, at any depth, one level of the hierarchy at a time. Black boxes
+// are defined by their ports alone, so nothing below them is fetched.
+func (st *Store) fetchAll(mname string) map[string]*rtl.Module {
+	defs := make(map[string]*rtl.Module)
+
+	level := []string{mname}
+	for len(level) > 0 {
+		var next []string
+		for _, m := range st.fetch(level) {
+			if bb := st.BlackBoxes[m.Name]; bb != nil {
+				m = bb.define(m)
+			}
+			defs[m.Name] = m
+		}
+		for _, name := range level {
+			for _, inst := range defs[name].Insts {
+				if inst.IsPrim {
+					continue
+				}
+				if _, found := defs[inst.Type]; found {
+					continue
+				}
+				defs[inst.Type] = nil // Claimed by the next level
+				next = append(next, inst.Type)
+			}
+		}
+		level = next
+	}
+
+	return defs
+}
+
+// fetch loads the definitions of the named modules in parallel.
+func (st *Store) fetch(names []string) []*rtl.Module {
+	mods := make([]*rtl.Module, len(names))
+
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < bulk.Workers; w++ {
+		wg.Add(1)
+		go func() {
+			for i := range jobs {
+				mods[i] = st.rtl.LoadModule(names[i])
+			}
+			wg.Done()
+		}()
+	}
+
+	for i := range names {
+		jobs <- i
+	}
+	close(jobs)
+	wg.Wait()
+
+	return mods
+}
+
+// buildOrder returns the modules in defs in the order a sequential, depth-first
+// build from module top would finish them, visiting instances by name. Each
+// module is listed once, along with the depth it was first reached at.
+func buildOrder(top string, defs map[string]*rtl.Module) (order []string, levels []int) {
+	visited := make(map[string]bool)
+
+	var visit func(mname string, level int)
+	visit = func(mname string, level int) {
+		visited[mname] = true
+
+		m := defs[mname]
+		var names []string
+		for nname, inst := range m.Insts {
+			if !inst.IsPrim {
+				names = append(names, nname)
+			}
+		}
+		sort.Strings(names)
+
+		for _, nname := range names {
+			if typ := m.Insts[nname].Type; !visited[typ] {
+				visit(typ, level+1)
+			}
+		}
+
+		order = append(order, mname)
+		levels = append(levels, level)
+	}
+	visit(top, 0)
+
+	return
+}
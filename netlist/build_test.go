@@ -0,0 +1,119 @@
+package netlist
+
+import (
+	"sart/rtl"
+	"strings"
+	"testing"
+)
+
+func TestBuildOrder(t *testing.T) {
+	// top instantiates b twice and a once; b instantiates a and a primitive.
+	defs := make(map[string]*rtl.Module)
+	for name, insts := range map[string][][2]string{
+		"top": {{"x2", "b"}, {"x1", "b"}, {"x0", "a"}},
+		"b":   {{"y", "a"}, {"p", "nand"}},
+		"a":   nil,
+	} {
+		m := rtl.NewModule(name)
+		for _, i := range insts {
+			inst := rtl.NewInst(name, i[0], i[1])
+			inst.IsPrim = i[1] == "nand"
+			m.AddInst(inst)
+		}
+		defs[name] = m
+	}
+
+	order, levels := buildOrder("top", defs)
+
+	exp := []string{"a", "b", "top"}
+	explevels := []int{1, 1, 0}
+	if len(order) != len(exp) {
+		t.Fatalf("Expecting build order %v. Got %v", exp, order)
+	}
+	for i := range exp {
+		if order[i] != exp[i] || levels[i] != explevels[i] {
+			t.Errorf("Expecting %q at level %d in position %d. Got %q at level %d",
+				exp[i], explevels[i], i, order[i], levels[i])
+		}
+	}
+}
+
+func TestMismatches(t *testing.T) {
+	st := &Store{mismatches: make(map[[2]string]*Mismatch)}
+	st.mismatch("top", "b", 0, 2)
+	st.mismatch("a", "c", 1, 0)
+	st.mismatch("top", "b", 1, 1)
+
+	ms := st.Mismatches()
+	exp := []Mismatch{
+		{Module: "a", Type: "c", Insts: 1, Extra: 1},
+		{Module: "top", Type: "b", Insts: 2, Extra: 1, Missing: 3},
+	}
+	if len(ms) != len(exp) {
+		t.Fatalf("Expecting %v. Got %v", exp, ms)
+	}
+	for i := range exp {
+		if ms[i] != exp[i] {
+			t.Errorf("Expecting %v. Got %v", exp[i], ms[i])
+		}
+	}
+
+	var b strings.Builder
+	if err := WriteMismatches(&b, ms); err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(b.String()), "\n")
+	if len(lines) != 3 || !strings.HasPrefix(lines[2], "top ") {
+		t.Errorf("Unexpected table:\n%s", b.String())
+	}
+}
+
+func TestTolerantBuild(t *testing.T) {
+	sub := rtl.NewModule("sub")
+	for pos, port := range [][2]string{{"a", "INPUT"}, {"b", "INPUT"}, {"y", "OUTPUT"}} {
+		sub.AddNewPort(port[0], pos)
+		sub.SetPortType(port[0], port[1])
+	}
+
+	// u connects to a and to a fourth port that sub does not have, and
+	// leaves b and y unconnected.
+	top := rtl.NewModule("top")
+	top.AddNewInst("u", "sub")
+	top.AddNewConn("u", "sub", "w0", 0)
+	top.AddNewConn("u", "sub", "w3", 3)
+
+	st := &Store{
+		Tolerant:   true,
+		mismatches: make(map[[2]string]*Mismatch),
+	}
+	n := st.build(top, map[string]*rtl.Module{"sub": sub})
+
+	for _, name := range []string{"top/u:extra3", "top/u:missing:b", "top/u:missing:y"} {
+		if node := n.Nodes[name]; node == nil || node.Type != "DANGLING" {
+			t.Errorf("Expecting dangling node %q. Got %v", name, node)
+		}
+	}
+
+	links := []struct {
+		l, r string
+	}{
+		{"top/w0", "top/u/a"},
+		{"top/w3", "top/u:extra3"},
+		{"top/u:missing:b", "top/u/b"},
+		{"top/u/y", "top/u:missing:y"},
+	}
+	for _, link := range links {
+		rnodes := n.Links[link.l]
+		if len(rnodes) != 1 || rnodes[0].Fullname() != link.r {
+			t.Errorf("Expecting %q to drive %q. Got %v", link.l, link.r, rnodes)
+		}
+	}
+	if len(n.Links["top/u:missing:y"]) != 0 || len(n.Links["top/u/b"]) != 0 {
+		t.Errorf("Expecting dangling nodes to follow port directions")
+	}
+
+	exp := Mismatch{Module: "top", Type: "sub", Insts: 1, Extra: 1, Missing: 2}
+	if ms := st.Mismatches(); len(ms) != 1 || ms[0] != exp {
+		t.Errorf("Expecting %v. Got %v", exp, ms)
+	}
+}
@@ -0,0 +1,243 @@
+package netlist
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"sart/liberty"
+	"sart/rtl"
+	"sort"
+)
+
+// ClockSpec says how to find the nodes of clock and reset networks, which
+// reach every sequential and would spread any ACE term that got onto them
+// everywhere. Walks do not go through such nodes.
+//
+// A clock spec file looks like this:
+//
+//	{
+//		"liberty": ["lib/stdcells.lib"],
+//		"clock_pins": "^(CK|CLK|CP)$",
+//		"reset_pins": "^(RN|SN|CLR|RST)$",
+//		"clocks": ["/clk_[a-z]+$"],
+//		"resets": ["/rst_b$"],
+//		"trace": true
+//	}
+type ClockSpec struct {
+	// Clock and reset pins of prims, by prim type
+	Liberty liberty.Library
+
+	// Clock and reset pins of sequentials that are not in Liberty
+	ClockPins, ResetPins *regexp.Regexp
+
+	// Full names of clock and reset nodes
+	Clocks, Resets []*regexp.Regexp
+
+	// Follow clock and reset nets back from where they were found, through
+	// wires, ports and single-input prims such as buffers and inverters
+	Trace bool
+}
+
+// Excluded is a node found to be on a clock or reset network.
+type Excluded struct {
+	Node   string `json:"node"`
+	Class  string `json:"class"` // liberty.Clock or liberty.Reset
+	Reason string `json:"reason"`
+}
+
+// LoadClockSpec reads a clock spec file, along with the Liberty libraries it
+// names.
+func LoadClockSpec(in io.Reader) (spec ClockSpec, err error) {
+	var file struct {
+		Liberty   []string `json:"liberty"`
+		ClockPins string   `json:"clock_pins"`
+		ResetPins string   `json:"reset_pins"`
+		Clocks    []string `json:"clocks"`
+		Resets    []string `json:"resets"`
+		Trace     bool     `json:"trace"`
+	}
+	err = json.NewDecoder(in).Decode(&file)
+	if err != nil {
+		return
+	}
+
+	spec.Liberty = make(liberty.Library)
+	for _, path := range file.Liberty {
+		lib, err := loadLiberty(path)
+		if err != nil {
+			return spec, err
+		}
+		for cell, pins := range lib {
+			spec.Liberty[cell] = pins
+		}
+	}
+
+	if file.ClockPins != "" {
+		if spec.ClockPins, err = regexp.Compile(file.ClockPins); err != nil {
+			return
+		}
+	}
+	if file.ResetPins != "" {
+		if spec.ResetPins, err = regexp.Compile(file.ResetPins); err != nil {
+			return
+		}
+	}
+	for _, expr := range file.Clocks {
+		re, err := regexp.Compile(expr)
+		if err != nil {
+			return spec, err
+		}
+		spec.Clocks = append(spec.Clocks, re)
+	}
+	for _, expr := range file.Resets {
+		re, err := regexp.Compile(expr)
+		if err != nil {
+			return spec, err
+		}
+		spec.Resets = append(spec.Resets, re)
+	}
+	spec.Trace = file.Trace
+
+	return
+}
+
+func loadLiberty(path string) (liberty.Library, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	lib, err := liberty.Load(file)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	return lib, nil
+}
+
+////////////////////////////////////////////////////////////////////////////////
+
+// MarkClocks finds the clock and reset nodes of the graph as spec says and
+// marks them IsClock, dropping any ACE terms they carried. Definitions of
+// modules and prims, needed to tell which pin of a prim a wire is on, are
+// fetched from defs. ACE nodes are never marked. It returns what was marked,
+// in order of name.
+func (g *Graph) MarkClocks(spec ClockSpec, defs rtl.ModuleLoader) (excluded []Excluded) {
+	classes := make(map[int32]string)
+	var queue []int32
+
+	mark := func(id int32, class, reason string) {
+		node := g.Nodes[id]
+		if _, marked := classes[id]; marked || node.IsAce {
+			return
+		}
+		classes[id] = class
+		queue = append(queue, id)
+
+		node.IsClock = true
+		node.RpAce, node.WpAce = nil, nil
+		excluded = append(excluded, Excluded{g.names[id], class, reason})
+	}
+
+	// Wires on the clock and reset pins of prims
+	if len(spec.Liberty) > 0 || spec.ClockPins != nil || spec.ResetPins != nil {
+		pins := make(map[string][]clockPin) // By module
+		for _, gn := range g.nets {
+			found, done := pins[gn.typ]
+			if !done {
+				found = clockPins(defs.LoadModule(gn.typ), spec, defs)
+				pins[gn.typ] = found
+			}
+			for _, p := range found {
+				if id, ok := g.id(gn.name + "/" + p.actual); ok {
+					mark(id, p.class, p.reason)
+				}
+			}
+		}
+	}
+
+	// Nodes named as clocks or resets
+	for id, name := range g.names {
+		for _, re := range spec.Clocks {
+			if re.MatchString(name) {
+				mark(int32(id), liberty.Clock, "name matches "+re.String())
+			}
+		}
+		for _, re := range spec.Resets {
+			if re.MatchString(name) {
+				mark(int32(id), liberty.Reset, "name matches "+re.String())
+			}
+		}
+	}
+
+	// Back from all of those to the roots of their networks
+	if spec.Trace {
+		for len(queue) > 0 {
+			u := queue[0]
+			queue = queue[1:]
+			for _, v := range g.in.edges(u) {
+				node := g.Nodes[v]
+				if node.IsPrim && (node.IsSeqn || len(g.in.edges(v)) != 1) {
+					continue
+				}
+				mark(v, classes[u], "drives "+g.names[u])
+			}
+		}
+	}
+
+	sort.Slice(excluded, func(i, j int) bool { return excluded[i].Node < excluded[j].Node })
+	return
+}
+
+// clockPin is a wire of a module that is on a clock or reset pin of a prim.
+type clockPin struct {
+	actual, class, reason string
+}
+
+// clockPins returns the wires of module m on clock or reset pins of its prims.
+// Pins of prims in Liberty are looked up there; those of other sequentials
+// are matched against the pin name patterns of spec.
+func clockPins(m *rtl.Module, spec ClockSpec, defs rtl.ModuleLoader) (pins []clockPin) {
+	var names []string
+	for iname := range m.Insts {
+		names = append(names, iname)
+	}
+	sort.Strings(names)
+
+	for _, iname := range names {
+		inst := m.Insts[iname]
+		if !inst.IsPrim {
+			continue
+		}
+		lib, inLiberty := spec.Liberty[inst.Type]
+		if !inLiberty && !inst.IsSeq {
+			continue
+		}
+
+		ports := defs.LoadModule(inst.Type).OrderedPorts()
+		for _, c := range m.Conns[iname] {
+			if c.Pos >= len(ports) {
+				continue
+			}
+			pin := ports[c.Pos].Name
+
+			var class string
+			switch {
+			case inLiberty:
+				class = lib[pin]
+			case spec.ClockPins != nil && spec.ClockPins.MatchString(pin):
+				class = liberty.Clock
+			case spec.ResetPins != nil && spec.ResetPins.MatchString(pin):
+				class = liberty.Reset
+			}
+			if class != "" {
+				pins = append(pins, clockPin{c.Actual, class,
+					fmt.Sprintf("on pin %s of %s instance %q", pin, inst.Type, iname)})
+			}
+		}
+	}
+
+	return
+}
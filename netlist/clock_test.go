@@ -0,0 +1,118 @@
+package netlist
+
+import (
+	"regexp"
+	"sart/liberty"
+	"sart/rtl"
+	"strings"
+	"testing"
+)
+
+type testDefs map[string]*rtl.Module
+
+func (d testDefs) LoadModule(name string) *rtl.Module {
+	if m, found := d[name]; found {
+		return m
+	}
+	return rtl.NewModule(name)
+}
+
+// clockNetlist returns t, where clk -> b -> ck clocks ACE flop f1, and ck and
+// en are gated by g onto gck, which clocks f2, reset by rst. Only f1 is in
+// Liberty. d -> f1 is data. Along with it come the definitions of t and its
+// prims.
+func clockNetlist() (*Netlist, testDefs) {
+	f := newFixture("t", "top", 8)
+	f.Port("INPUT", "clk", "d", "en", "rst").Wire("ck", "gck").
+		Prim("CKBUF", "b").Prim("AND", "g").Seqn("DFF", "f1").Seqn("DFFX", "f2").Ace("f1", 0)
+	f.Link("clk", "b", "ck", "f1").Link("d", "f1").Link("ck", "g", "gck", "f2").
+		Link("en", "g").Link("rst", "f2")
+
+	prims := map[string]string{"b": "CKBUF", "g": "AND", "f1": "DFF", "f2": "DFFX"}
+
+	defs := make(testDefs)
+	top := rtl.NewModule("top")
+	for name, typ := range prims {
+		inst := rtl.NewInst("top", name, typ)
+		inst.IsPrim = true
+		inst.IsSeq = typ == "DFF" || typ == "DFFX"
+		top.AddInst(inst)
+	}
+	top.AddNewConn("f1", "DFF", "d", 0)
+	top.AddNewConn("f1", "DFF", "ck", 1)
+	top.AddNewConn("f2", "DFFX", "gck", 1)
+	top.AddNewConn("f2", "DFFX", "rst", 2)
+	defs["top"] = top
+
+	for typ, pins := range map[string][]string{"DFF": {"D", "CK"}, "DFFX": {"D", "CP", "RN"}} {
+		m := rtl.NewModule(typ)
+		for pos, pin := range pins {
+			m.AddNewPort(pin, pos)
+		}
+		defs[typ] = m
+	}
+
+	return f.Netlist(), defs
+}
+
+func TestMarkClocks(t *testing.T) {
+	n, defs := clockNetlist()
+	g := n.Graph()
+
+	spec := ClockSpec{
+		Liberty:   liberty.Library{"DFF": {"CK": liberty.Clock}},
+		ClockPins: regexp.MustCompile("^CP$"),
+		ResetPins: regexp.MustCompile("^RN$"),
+		Trace:     true,
+	}
+
+	var got []string
+	for _, e := range g.MarkClocks(spec, defs) {
+		got = append(got, e.Node+":"+e.Class)
+	}
+	exp := "t/b:clock t/ck:clock t/clk:clock t/gck:clock t/rst:reset"
+	if strings.Join(got, " ") != exp {
+		t.Errorf("Expecting %q to be marked. Got %q", exp, got)
+	}
+
+	// Terms stay off the clock network
+	g.WalkUp()
+	for name, wp := range map[string]string{"t/d": "01", "t/ck": "", "t/clk": ""} {
+		if node := g.Lookup(name); node.WpAce.String() != wp {
+			t.Errorf("Expecting %s w:%s. Got %v", name, wp, node)
+		}
+	}
+	if !g.Lookup("t/ck").IsClock || g.Lookup("t/en").IsClock {
+		t.Errorf("Expecting t/ck and not t/en to be marked IsClock")
+	}
+}
+
+func TestMarkClocksByName(t *testing.T) {
+	n, defs := clockNetlist()
+	g := n.Graph()
+
+	spec := ClockSpec{Clocks: []*regexp.Regexp{regexp.MustCompile("/g?ck$")}}
+
+	var got []string
+	for _, e := range g.MarkClocks(spec, defs) {
+		got = append(got, e.Node)
+	}
+	if strings.Join(got, " ") != "t/ck t/gck" {
+		t.Errorf("Expecting t/ck and t/gck to be marked. Got %q", got)
+	}
+}
+
+func TestLoadClockSpec(t *testing.T) {
+	spec, err := LoadClockSpec(strings.NewReader(
+		`{"clock_pins": "^CK$", "resets": ["rst"], "trace": true}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !spec.ClockPins.MatchString("CK") || len(spec.Resets) != 1 || !spec.Trace {
+		t.Errorf("Unexpected spec %+v", spec)
+	}
+
+	if _, err := LoadClockSpec(strings.NewReader(`{"clocks": ["("]}`)); err == nil {
+		t.Error("Expecting an error for a bad pattern")
+	}
+}
@@ -0,0 +1,163 @@
+package netlist
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Kind is a set of node kinds, used to pick which nodes of a cone to report.
+type Kind uint8
+
+const (
+	KindPrim Kind = 1 << iota
+	KindSeqn
+	KindPort
+	KindWire
+	KindAce
+	KindClock
+
+	KindAll Kind = KindPrim | KindSeqn | KindPort | KindWire | KindAce | KindClock
+)
+
+var kindNames = []struct {
+	kind Kind
+	name string
+}{
+	{KindPrim, "prim"},
+	{KindSeqn, "seqn"},
+	{KindPort, "port"},
+	{KindWire, "wire"},
+	{KindAce, "ace"},
+	{KindClock, "clock"},
+}
+
+// ParseKinds parses a comma separated list of kinds such as "prim,seqn". An
+// empty list, or "all", stands for all kinds.
+func ParseKinds(list string) (Kind, error) {
+	if list == "" || list == "all" {
+		return KindAll, nil
+	}
+
+	var k Kind
+	for _, name := range strings.Split(list, ",") {
+		found := false
+		for _, kn := range kindNames {
+			if kn.name == name {
+				k |= kn.kind
+				found = true
+			}
+		}
+		if !found {
+			return 0, fmt.Errorf("unknown node kind %q", name)
+		}
+	}
+	return k, nil
+}
+
+func (k Kind) String() string {
+	var names []string
+	for _, kn := range kindNames {
+		if k&kn.kind != 0 {
+			names = append(names, kn.name)
+		}
+	}
+	return strings.Join(names, ",")
+}
+
+// Kind returns the kinds that node n is of.
+func (n *Node) Kind() (k Kind) {
+	if n.IsPrim {
+		k |= KindPrim
+	}
+	if n.IsSeqn {
+		k |= KindSeqn
+	}
+	if n.IsPort {
+		k |= KindPort
+	}
+	if n.IsWire {
+		k |= KindWire
+	}
+	if n.IsAce {
+		k |= KindAce
+	}
+	if n.IsClock {
+		k |= KindClock
+	}
+	return
+}
+
+////////////////////////////////////////////////////////////////////////////////
+
+type ConeOptions struct {
+	Depth      int  // Maximum number of links from the start node; 0 for no limit
+	StopAtSeqn bool // Report sequentials but do not go through them
+	StopAtAce  bool // Report ACE nodes but do not go through them
+	Kinds      Kind // Kinds of nodes to report; 0 for all
+}
+
+// ConeNode is a node of a cone and its distance, in links, from the start.
+type ConeNode struct {
+	ID    int
+	Node  *Node
+	Depth int
+}
+
+// FanOut returns the nodes that node id drives, directly or through other
+// nodes, across subnet boundaries. Nodes are ordered by depth and then name.
+func (g *Graph) FanOut(id int, opt ConeOptions) []ConeNode {
+	return g.cone(g.out, id, opt)
+}
+
+// FanIn returns the nodes that drive node id, directly or through other
+// nodes, across subnet boundaries. Nodes are ordered by depth and then name.
+func (g *Graph) FanIn(id int, opt ConeOptions) []ConeNode {
+	return g.cone(g.in, id, opt)
+}
+
+func (g *Graph) cone(adj csr, start int, opt ConeOptions) (cone []ConeNode) {
+	kinds := opt.Kinds
+	if kinds == 0 {
+		kinds = KindAll
+	}
+
+	depth := make(map[int32]int)
+	depth[int32(start)] = 0
+	level := []int32{int32(start)}
+
+	for d := 1; len(level) > 0 && (opt.Depth == 0 || d <= opt.Depth); d++ {
+		var next []int32
+		for _, u := range level {
+			// The start node is always gone through
+			if node := g.Nodes[u]; int(u) != start &&
+				(opt.StopAtSeqn && node.IsSeqn || opt.StopAtAce && node.IsAce) {
+				continue
+			}
+			for _, v := range adj.edges(u) {
+				if _, seen := depth[v]; seen {
+					continue
+				}
+				depth[v] = d
+				next = append(next, v)
+
+				if g.Nodes[v].Kind()&kinds != 0 {
+					cone = append(cone, ConeNode{int(v), g.Nodes[v], d})
+				}
+			}
+		}
+		level = next
+	}
+
+	sortCone(g, cone)
+	return
+}
+
+func sortCone(g *Graph, cone []ConeNode) {
+	sort.Slice(cone, func(i, j int) bool {
+		if cone[i].Depth != cone[j].Depth {
+			return cone[i].Depth < cone[j].Depth
+		}
+		return g.Name(cone[i].ID) < g.Name(cone[j].ID)
+	})
+}
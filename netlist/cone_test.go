@@ -0,0 +1,60 @@
+package netlist
+
+import (
+	"strings"
+	"testing"
+)
+
+func coneNames(cone []ConeNode) (s []string) {
+	for _, c := range cone {
+		s = append(s, c.Node.Fullname())
+	}
+	return
+}
+
+func TestCone(t *testing.T) {
+	g := NewGraph(testNetlist())
+	a, _ := g.ID("t/a")
+	z, _ := g.ID("t/z")
+
+	// t/a -> t/q -> t/w -> t/u/i -> t/u/x -> t/u/o -> t/z, q is ACE and seqn
+	for _, test := range []struct {
+		out  bool
+		from int
+		opt  ConeOptions
+		exp  string
+	}{
+		{true, a, ConeOptions{}, "t/q t/w t/u/i t/u/x t/u/o t/z"},
+		{true, a, ConeOptions{Depth: 3}, "t/q t/w t/u/i"},
+		{true, a, ConeOptions{StopAtSeqn: true}, "t/q"},
+		{true, a, ConeOptions{Kinds: KindPrim}, "t/q t/u/x"},
+		{false, z, ConeOptions{StopAtAce: true}, "t/u/o t/u/x t/u/i t/w t/q"},
+		{false, z, ConeOptions{Kinds: KindPort | KindAce}, "t/u/o t/u/i t/q t/a"},
+	} {
+		var cone []ConeNode
+		if test.out {
+			cone = g.FanOut(test.from, test.opt)
+		} else {
+			cone = g.FanIn(test.from, test.opt)
+		}
+		if got := strings.Join(coneNames(cone), " "); got != test.exp {
+			t.Errorf("Cone of %s with %+v: expecting %q. Got %q", g.Name(test.from),
+				test.opt, test.exp, got)
+		}
+	}
+}
+
+func TestParseKinds(t *testing.T) {
+	k, err := ParseKinds("seqn,port")
+	if err != nil || k != KindSeqn|KindPort || k.String() != "seqn,port" {
+		t.Errorf("Unexpected kinds %v, error %v", k, err)
+	}
+
+	if k, _ := ParseKinds(""); k != KindAll {
+		t.Errorf("Expecting all kinds for an empty list. Got %v", k)
+	}
+
+	if _, err := ParseKinds("prim,flop"); err == nil {
+		t.Error("Expecting an error for an unknown kind")
+	}
+}
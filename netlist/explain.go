@@ -0,0 +1,232 @@
+package netlist
+
+import (
+	"sart/bitfield"
+	"sort"
+)
+
+// ExplainOptions pick the term Explain looks for and how many paths it finds.
+type ExplainOptions struct {
+	Write bool // Explain a write-port term instead of a read-port term
+	All   bool // Find every path, not just a shortest one
+	Limit int  // Most paths to return with All; 0 for DefaultExplainLimit
+	Depth int  // Longest path, in links, to consider with All; 0 for DefaultExplainDepth
+}
+
+// DefaultExplainLimit caps the number of paths Explain returns with All, as
+// the number of paths can grow exponentially with the size of the netlist.
+const DefaultExplainLimit = 100
+
+// DefaultExplainDepth caps the length of the paths Explain looks at with All,
+// as the time taken to search can grow exponentially with it.
+const DefaultExplainDepth = 64
+
+// Explain returns the paths by which ACE term pos reached node id, as node IDs
+// in the order the term travelled: from the ACE node it came from to id. A
+// read-port term travels along links and a write-port term against them, and
+// neither goes through other ACE nodes. Only nodes that carry the term are
+// considered, so Explain is meaningful once walks are done. It returns no
+// paths if id does not carry the term. With All, paths are ordered shortest
+// first.
+func (g *Graph) Explain(id, pos int, opt ExplainOptions) [][]int {
+	terms := func(n *Node) *bitfield.BitField { return n.RpAce }
+
+	// Paths are searched backwards, from id towards the ACE node
+	fwd, back := g.out, g.in
+	if opt.Write {
+		terms = func(n *Node) *bitfield.BitField { return n.WpAce }
+		fwd, back = g.in, g.out
+	}
+
+	carries := func(v int32) bool {
+		return terms(g.Nodes[v]).IsSet(pos)
+	}
+	source := func(v int32) bool {
+		return g.Nodes[v].IsAce && carries(v)
+	}
+
+	if !carries(int32(id)) {
+		return nil
+	}
+	if opt.All {
+		dist := g.sourceDist(fwd, carries, source)
+		return g.explainAll(int32(id), back, dist, source, opt)
+	}
+	if path := g.explainShortest(int32(id), back, carries, source); path != nil {
+		return [][]int{path}
+	}
+	return nil
+}
+
+// explainShortest searches breadth first and returns the first path found.
+func (g *Graph) explainShortest(start int32, back csr, carries, source func(int32) bool) []int {
+	next := map[int32]int32{start: -1} // Node one step closer to start
+	level := []int32{start}
+
+	for len(level) > 0 {
+		var following []int32
+		for _, u := range level {
+			if source(u) {
+				// Follow the trail back to start, which is the order the term
+				// travelled in.
+				var path []int
+				for v := u; v != -1; v = next[v] {
+					path = append(path, int(v))
+				}
+				return path
+			}
+
+			// Terms do not go through ACE nodes
+			if g.Nodes[u].IsAce && u != start {
+				continue
+			}
+
+			for _, v := range back.edges(u) {
+				if _, seen := next[v]; seen || !carries(v) {
+					continue
+				}
+				next[v] = u
+				following = append(following, v)
+			}
+		}
+		level = following
+	}
+
+	return nil
+}
+
+// sourceDist returns the number of links from every node that carries the
+// term back to the nearest source, or -1 for nodes that do not carry it. The
+// term travels along fwd.
+func (g *Graph) sourceDist(fwd csr, carries, source func(int32) bool) []int32 {
+	dist := make([]int32, len(g.Nodes))
+	var level []int32
+	for id := range dist {
+		dist[id] = -1
+		if source(int32(id)) {
+			dist[id] = 0
+			level = append(level, int32(id))
+		}
+	}
+
+	// Sources are the only ACE nodes that carry the term, so the search does
+	// not go through other ACE nodes.
+	for d := int32(1); len(level) > 0; d++ {
+		var following []int32
+		for _, u := range level {
+			for _, v := range fwd.edges(u) {
+				if dist[v] == -1 && carries(v) {
+					dist[v] = d
+					following = append(following, v)
+				}
+			}
+		}
+		level = following
+	}
+
+	return dist
+}
+
+// explainAll searches depth first for every simple path. Nodes from which no
+// source can be reached within the depth left are not searched, and the search
+// stops once limit paths are found.
+func (g *Graph) explainAll(start int32, back csr, dist []int32, source func(int32) bool, opt ExplainOptions) (paths [][]int) {
+	limit := opt.Limit
+	if limit == 0 {
+		limit = DefaultExplainLimit
+	}
+	depth := opt.Depth
+	if depth == 0 {
+		depth = DefaultExplainDepth
+	}
+
+	onpath := make(map[int32]bool)
+	var stack []int32
+
+	var visit func(u int32)
+	visit = func(u int32) {
+		if len(paths) >= limit {
+			return
+		}
+
+		stack = append(stack, u)
+		onpath[u] = true
+		defer func() {
+			stack = stack[:len(stack)-1]
+			delete(onpath, u)
+		}()
+
+		if source(u) {
+			path := make([]int, len(stack))
+			for i, v := range stack {
+				path[len(stack)-1-i] = int(v)
+			}
+			paths = append(paths, path)
+			return
+		}
+
+		if g.Nodes[u].IsAce && u != start {
+			return
+		}
+
+		// Going on to v makes the path len(stack) links long so far
+		for _, v := range back.edges(u) {
+			if len(paths) >= limit {
+				return
+			}
+			if dist[v] == -1 || onpath[v] || len(stack)+int(dist[v]) > depth {
+				continue
+			}
+			visit(v)
+		}
+	}
+	if int(dist[start]) <= depth {
+		visit(start)
+	}
+
+	// Shortest first, and then by name, so that output is the same from run
+	// to run
+	sort.Slice(paths, func(i, j int) bool {
+		a, b := paths[i], paths[j]
+		if len(a) != len(b) {
+			return len(a) < len(b)
+		}
+		for k := range a {
+			if a[k] != b[k] {
+				return g.names[a[k]] < g.names[b[k]]
+			}
+		}
+		return false
+	})
+
+	return
+}
+
+////////////////////////////////////////////////////////////////////////////////
+
+// Hop is one node of a path found by Explain, along with the netlist that
+// holds it. A change of netlist between two hops is a subnet port being
+// crossed.
+type Hop struct {
+	Node    string `json:"node"`
+	Type    string `json:"type"`
+	Kind    string `json:"kind"`
+	Netlist string `json:"netlist"`
+	Module  string `json:"module"`
+}
+
+// Hops describes the nodes of path one by one.
+func (g *Graph) Hops(path []int) []Hop {
+	hops := make([]Hop, len(path))
+	for i, id := range path {
+		name, typ := g.Owner(id)
+		hops[i] = Hop{
+			Node:    g.names[id],
+			Type:    g.Nodes[id].Type,
+			Kind:    g.Nodes[id].Kind().String(),
+			Netlist: name,
+			Module:  typ,
+		}
+	}
+	return hops
+}
@@ -0,0 +1,104 @@
+package netlist
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func pathNames(g *Graph, paths [][]int) (s []string) {
+	for _, path := range paths {
+		var hops []string
+		for _, h := range g.Hops(path) {
+			hops = append(hops, h.Node)
+		}
+		s = append(s, strings.Join(hops, " "))
+	}
+	return
+}
+
+func TestExplain(t *testing.T) {
+	n := testNetlist()
+
+	// A second way from t/q into t/u
+	v := NewWireNode("t", "v")
+	n.AddNode(v)
+	n.Connect(n.Nodes["t/q"], v)
+	n.Connect(v, n.Subnets["t/u"].Nodes["t/u/i"])
+
+	n.Walk()
+	g := n.Graph()
+
+	for _, test := range []struct {
+		name string
+		pos  int
+		opt  ExplainOptions
+		exp  []string
+	}{
+		{"t/u/x", 0, ExplainOptions{All: true}, []string{
+			"t/q t/v t/u/i t/u/x",
+			"t/q t/w t/u/i t/u/x",
+		}},
+		{"t/z", 0, ExplainOptions{All: true, Depth: 4}, nil},
+		{"t/z", 0, ExplainOptions{All: true, Depth: 5}, []string{
+			"t/q t/v t/u/i t/u/x t/u/o t/z",
+			"t/q t/w t/u/i t/u/x t/u/o t/z",
+		}},
+		{"t/a", 0, ExplainOptions{Write: true}, []string{"t/q t/a"}},
+		{"t/q", 0, ExplainOptions{}, []string{"t/q"}},
+		{"t/z", 0, ExplainOptions{Write: true}, nil},
+		{"t/z", 1, ExplainOptions{}, nil},
+	} {
+		id, _ := g.ID(test.name)
+		got := pathNames(g, g.Explain(id, test.pos, test.opt))
+		if strings.Join(got, "\n") != strings.Join(test.exp, "\n") {
+			t.Errorf("Explain %s term %d with %+v: expecting %q. Got %q",
+				test.name, test.pos, test.opt, test.exp, got)
+		}
+	}
+
+	// Either way in is a shortest path, and either can be found first
+	z, _ := g.ID("t/z")
+	for _, opt := range []ExplainOptions{{}, {All: true, Limit: 1}} {
+		paths := g.Explain(z, 0, opt)
+		if len(paths) != 1 || len(paths[0]) != 6 {
+			t.Errorf("Expecting one path of 6 nodes to t/z with %+v. Got %q",
+				opt, pathNames(g, paths))
+		}
+	}
+}
+
+func TestHops(t *testing.T) {
+	g := NewGraph(testNetlist())
+	w, _ := g.ID("t/w")
+	i, _ := g.ID("t/u/i")
+
+	hops := g.Hops([]int{w, i})
+	if hops[0].Netlist != "t" || hops[1].Netlist != "t/u" || hops[1].Kind != "port" {
+		t.Errorf("Unexpected hops %+v", hops)
+	}
+}
+
+// TestExplainReconvergent explains a term through a chain of diamonds, which
+// has 2^stages paths.
+func TestExplainReconvergent(t *testing.T) {
+	const stages = 30
+
+	f := newFixture("t", "", 1).Seqn("FF", "q").Ace("q", 0).Wire("d0").Link("q", "d0")
+	for i := 0; i < stages; i++ {
+		d, next := fmt.Sprintf("d%d", i), fmt.Sprintf("d%d", i+1)
+		a, b := fmt.Sprintf("a%d", i), fmt.Sprintf("b%d", i)
+		f.Wire(next).Prim("AND", a, b).Link(d, a, next).Link(d, b, next)
+	}
+	n := f.Netlist()
+	n.Walk()
+	g := n.Graph()
+	end, _ := g.ID(fmt.Sprintf("t/d%d", stages))
+
+	if paths := g.Explain(end, 0, ExplainOptions{All: true, Limit: 5}); len(paths) != 5 {
+		t.Errorf("Expecting 5 paths. Got %d", len(paths))
+	}
+	if paths := g.Explain(end, 0, ExplainOptions{All: true, Depth: 2 * stages}); paths != nil {
+		t.Errorf("Expecting no paths within %d links. Got %d", 2*stages, len(paths))
+	}
+}
@@ -0,0 +1,107 @@
+package netlist
+
+import (
+	"fmt"
+	"sart/bitfield"
+)
+
+// fixture builds a small netlist by hand for tests. Node names are given
+// relative to the netlist being built. Links may name nodes in its subnets, as
+// in "u/i".
+//
+//	f := newFixture("t", "top", 8)
+//	f.Port("INPUT", "a").Seqn("FF", "q").Wire("w").Ace("q", 0)
+//	f.Sub("u", "buf").Port("INPUT", "i").Port("OUTPUT", "o").Prim("BUF", "x").
+//		Link("i", "x", "o")
+//	f.Link("a", "q", "w", "u/i")
+//	n := f.Netlist()
+type fixture struct {
+	n      *Netlist
+	bfsize int
+}
+
+// newFixture starts netlist name, an instance of module typ, whose ACE nodes
+// have bitfields of bfsize terms.
+func newFixture(name, typ string, bfsize int) *fixture {
+	n := NewNetlist(name)
+	n.Type = typ
+	return &fixture{n, bfsize}
+}
+
+// Netlist returns the netlist built so far.
+func (f *fixture) Netlist() *Netlist {
+	return f.n
+}
+
+// Port adds port nodes of direction typ.
+func (f *fixture) Port(typ string, names ...string) *fixture {
+	for _, name := range names {
+		f.n.AddNode(NewPortNode(f.n.Name, name, typ))
+	}
+	return f
+}
+
+// Prim adds combinational prim nodes of type typ.
+func (f *fixture) Prim(typ string, names ...string) *fixture {
+	for _, name := range names {
+		f.n.AddNode(NewPrimNode(f.n.Name, name, typ))
+	}
+	return f
+}
+
+// Seqn adds sequential prim nodes of type typ.
+func (f *fixture) Seqn(typ string, names ...string) *fixture {
+	for _, name := range names {
+		node := NewPrimNode(f.n.Name, name, typ)
+		node.IsSeqn = true
+		f.n.AddNode(node)
+	}
+	return f
+}
+
+// Wire adds wire nodes.
+func (f *fixture) Wire(names ...string) *fixture {
+	for _, name := range names {
+		f.n.AddNode(NewWireNode(f.n.Name, name))
+	}
+	return f
+}
+
+// Ace marks node name ACE with term on both ports, as marking would.
+func (f *fixture) Ace(name string, term int) *fixture {
+	node := f.node(name)
+	node.IsAce = true
+	if node.RpAce == nil {
+		node.RpAce = bitfield.New(f.bfsize)
+		node.WpAce = bitfield.New(f.bfsize)
+	}
+	node.RpAce.Set(term)
+	node.WpAce.Set(term)
+	return f
+}
+
+// Sub adds subnet name, an instance of module typ, and returns the fixture
+// that builds it.
+func (f *fixture) Sub(name, typ string) *fixture {
+	sub := newFixture(f.n.Name+"/"+name, typ, f.bfsize)
+	f.n.Subnets[sub.n.Name] = sub.n
+	return sub
+}
+
+// Link connects each of the named nodes to the next.
+func (f *fixture) Link(chain ...string) *fixture {
+	for i := 1; i < len(chain); i++ {
+		f.n.Connect(f.node(chain[i-1]), f.node(chain[i]))
+	}
+	return f
+}
+
+// node returns node name. A fixture that names a node it has not added is a
+// broken test, not a failing one, so it panics.
+func (f *fixture) node(name string) *Node {
+	node := f.n.LocateNode(f.n.Name + "/" + name)
+	if node == nil {
+		panic(fmt.Sprintf("fixture %q: no node %q", f.n.Name, name))
+	}
+	return node
+}
@@ -0,0 +1,312 @@
+package netlist
+
+import (
+	"log"
+	"sart/ace"
+	"sart/bitfield"
+	"sort"
+)
+
+// Graph is a flat, integer-indexed view of a netlist and all of its subnets.
+// Every node gets a dense ID and links are held in compressed sparse row
+// arrays in both directions. Nodes are shared with the netlist the graph was
+// made from, so walks on the graph update the netlist in place. So are the
+// full names of nodes, which are found by binary search rather than through a
+// map of their own.
+type Graph struct {
+	Nodes []*Node // Indexed by node ID
+
+	names []string // Full name of each node, shared with the netlist
+	order []int32  // Node IDs in order of full name
+
+	out, in csr
+
+	// Netlists the nodes came from. Index 0 is the netlist the graph was made
+	// from. Nodes and links can be handed back to them by Netlist.
+	nets  []graphNet
+	netof []int32 // Netlist of each node
+}
+
+type graphNet struct {
+	name, typ string
+	parent    int32 // -1 for the top netlist
+	depth     int
+}
+
+// csr holds adjacency lists back to back. The neighbours of node i are
+// adj[start[i]:start[i+1]].
+type csr struct {
+	start []int32
+	adj   []int32
+}
+
+func (c csr) edges(id int32) []int32 {
+	return c.adj[c.start[id]:c.start[id+1]]
+}
+
+// NewGraph returns the graph of netlist n, expanding all of its subnets.
+func NewGraph(n *Netlist) *Graph {
+	g := &Graph{}
+
+	var netlists []*Netlist
+
+	var add func(n *Netlist, parent int32, depth int)
+	add = func(n *Netlist, parent int32, depth int) {
+		n.Expand()
+
+		net := int32(len(g.nets))
+		g.nets = append(g.nets, graphNet{n.Name, n.Type, parent, depth})
+		netlists = append(netlists, n)
+
+		for fullname, node := range n.Nodes {
+			g.Nodes = append(g.Nodes, node)
+			g.names = append(g.names, fullname)
+			g.netof = append(g.netof, net)
+		}
+
+		for _, subnet := range n.Subnets {
+			add(subnet, net, depth+1)
+		}
+	}
+	add(n, -1, 0)
+	g.index()
+
+	// Count the links leaving and entering every node, then lay them out.
+	type link struct{ l, r int32 }
+	var links []link
+	for _, n := range netlists {
+		for lfullname, rnodes := range n.Links {
+			l, found := g.id(lfullname)
+			if !found {
+				log.Fatalf("Could not locate lnode %q in netlist %q", lfullname, n.Name)
+			}
+			for _, rnode := range rnodes {
+				r, found := g.id(rnode.Fullname())
+				if !found {
+					log.Fatalf("Could not locate rnode %q in netlist %q", rnode.Fullname(), n.Name)
+				}
+				links = append(links, link{l, r})
+			}
+		}
+	}
+
+	g.out = newCSR(len(g.Nodes), len(links), func(emit func(from, to int32)) {
+		for _, k := range links {
+			emit(k.l, k.r)
+		}
+	})
+	g.in = newCSR(len(g.Nodes), len(links), func(emit func(from, to int32)) {
+		for _, k := range links {
+			emit(k.r, k.l)
+		}
+	})
+
+	return g
+}
+
+// newCSR lays out the numedges edges produced by each, which is run twice:
+// once to count them and once to place them.
+func newCSR(numnodes, numedges int, each func(emit func(from, to int32))) csr {
+	c := csr{
+		start: make([]int32, numnodes+1),
+		adj:   make([]int32, numedges),
+	}
+
+	each(func(from, to int32) {
+		c.start[from+1]++
+	})
+	for i := 1; i <= numnodes; i++ {
+		c.start[i] += c.start[i-1]
+	}
+
+	next := make([]int32, numnodes)
+	copy(next, c.start)
+	each(func(from, to int32) {
+		c.adj[next[from]] = to
+		next[from]++
+	})
+
+	return c
+}
+
+// index sorts the node IDs by full name for id.
+func (g *Graph) index() {
+	g.order = make([]int32, len(g.names))
+	for i := range g.order {
+		g.order[i] = int32(i)
+	}
+	sort.Slice(g.order, func(i, j int) bool {
+		return g.names[g.order[i]] < g.names[g.order[j]]
+	})
+}
+
+// id returns the ID of the node with the given full name.
+func (g *Graph) id(fullname string) (int32, bool) {
+	i := sort.Search(len(g.order), func(i int) bool {
+		return g.names[g.order[i]] >= fullname
+	})
+	if i < len(g.order) && g.names[g.order[i]] == fullname {
+		return g.order[i], true
+	}
+	return -1, false
+}
+
+// Len returns the number of nodes in the graph.
+func (g *Graph) Len() int {
+	return len(g.Nodes)
+}
+
+// Name returns the full name of node id.
+func (g *Graph) Name(id int) string {
+	return g.names[id]
+}
+
+// ID returns the ID of the node with the given full name.
+func (g *Graph) ID(fullname string) (id int, found bool) {
+	i, found := g.id(fullname)
+	return int(i), found
+}
+
+// Lookup returns the node with the given full name, or nil if there is none.
+func (g *Graph) Lookup(fullname string) *Node {
+	if id, found := g.id(fullname); found {
+		return g.Nodes[id]
+	}
+	return nil
+}
+
+// Owner returns the name and module of the netlist that holds node id.
+func (g *Graph) Owner(id int) (name, typ string) {
+	net := g.nets[g.netof[id]]
+	return net.name, net.typ
+}
+
+// Out returns the IDs of the nodes that node id drives.
+func (g *Graph) Out(id int) []int32 {
+	return g.out.edges(int32(id))
+}
+
+// In returns the IDs of the nodes that drive node id.
+func (g *Graph) In(id int) []int32 {
+	return g.in.edges(int32(id))
+}
+
+// Netlist hands the nodes and links of the graph back to a hierarchy of
+// netlists and returns its top. The nodes are those of the graph, not copies.
+func (g *Graph) Netlist() *Netlist {
+	nets := make([]*Netlist, len(g.nets))
+	for i, gn := range g.nets {
+		n := NewNetlist(gn.name)
+		n.Type = gn.typ
+		n.expanded = true
+		nets[i] = n
+		if gn.parent >= 0 {
+			nets[gn.parent].Subnets[gn.name] = n
+		}
+	}
+
+	for id, node := range g.Nodes {
+		nets[g.netof[id]].AddNode(node)
+	}
+
+	// A link belongs to the outer of the two netlists it joins. That is the
+	// parent when a node is hooked up to a port of a subnet.
+	for l := range g.Nodes {
+		for _, r := range g.Out(l) {
+			owner := g.netof[l]
+			if g.nets[g.netof[r]].depth < g.nets[owner].depth {
+				owner = g.netof[r]
+			}
+			nets[owner].Connect(g.Nodes[l], g.Nodes[r])
+		}
+	}
+
+	return nets[0]
+}
+
+////////////////////////////////////////////////////////////////////////////////
+
+// WalkDn propagates the read-port ACE terms of every ACE node to all nodes it
+// drives, directly or through other nodes, stopping at other ACE nodes, at
+// clock and reset nodes and at stopping arcs of black boxes. It returns the
+// number of times a node gained terms.
+func (g *Graph) WalkDn() int {
+	return g.walk(g.out, rpAce, isAce)
+}
+
+// WalkUp propagates the write-port ACE terms of every ACE node to all nodes
+// that drive it, directly or through other nodes, stopping at other ACE
+// nodes, at clock and reset nodes and at stopping arcs of black boxes. It
+// returns the number of times a node gained terms.
+func (g *Graph) WalkUp() int {
+	return g.walk(g.in, wpAce, isAce)
+}
+
+// Fill completes walks that have already brought terms to some of the nodes,
+// such as walks of a SeqGraph, which leave the combinational nodes in between
+// untouched. Every node that carries terms passes them on, down and up, as
+// WalkDn and WalkUp do from ACE nodes. It returns the number of times a node
+// gained terms.
+func (g *Graph) Fill() int {
+	carries := func(terms func(*Node) **bitfield.BitField) func(*Node) bool {
+		return func(n *Node) bool {
+			return n.IsAce || !n.IsClock && !n.IsStop && !(*terms(n)).AllUnset()
+		}
+	}
+	return g.walk(g.out, rpAce, carries(rpAce)) + g.walk(g.in, wpAce, carries(wpAce))
+}
+
+func rpAce(n *Node) **bitfield.BitField { return &n.RpAce }
+func wpAce(n *Node) **bitfield.BitField { return &n.WpAce }
+func isAce(n *Node) bool                { return n.IsAce }
+
+// walk pushes terms along adj from the nodes picked by seed until nothing
+// changes. A node is queued again whenever it gains terms, so a single call
+// reaches the fixed point.
+func (g *Graph) walk(adj csr, terms func(*Node) **bitfield.BitField, seed func(*Node) bool) (changed int) {
+	queued := make([]bool, len(g.Nodes))
+	var queue []int32
+
+	for id, node := range g.Nodes {
+		if seed(node) {
+			queue = append(queue, int32(id))
+			queued[id] = true
+		}
+	}
+
+	for len(queue) > 0 {
+		u := queue[0]
+		queue = queue[1:]
+		queued[u] = false
+
+		from := *terms(g.Nodes[u])
+		for _, v := range adj.edges(u) {
+			node := g.Nodes[v]
+			if node.IsAce || node.IsClock || node.IsStop {
+				continue
+			}
+			if !orTerms(terms(node), from) {
+				continue
+			}
+			changed++
+			if !queued[v] {
+				queue = append(queue, v)
+				queued[v] = true
+			}
+		}
+	}
+
+	return
+}
+
+// Stats returns the statistics of all nodes in the graph.
+func (g *Graph) Stats(acestructs []ace.AceStruct) (stats NetStats) {
+	stats = NewNetStats()
+	stats.Nodes = len(g.Nodes)
+
+	for _, node := range g.Nodes {
+		stats.add(node, acestructs)
+	}
+
+	return
+}
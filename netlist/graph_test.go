@@ -0,0 +1,118 @@
+package netlist
+
+import (
+	"testing"
+)
+
+// testNetlist returns a netlist with an ACE sequential q driving a subnet u
+// whose output drives z, and an input a driving q.
+//
+//	t/a -> t/q -> t/w -> t/u/i -> t/u/x -> t/u/o -> t/z
+func testNetlist() *Netlist {
+	f := newFixture("t", "", 8)
+	f.Port("INPUT", "a").Seqn("FF", "q").Wire("w", "z").Ace("q", 0)
+	f.Sub("u", "").Port("INPUT", "i").Port("OUTPUT", "o").Prim("BUF", "x").
+		Link("i", "x", "o")
+	f.Link("a", "q", "w", "u/i").Link("u/o", "z")
+	return f.Netlist()
+}
+
+func TestGraph(t *testing.T) {
+	g := NewGraph(testNetlist())
+
+	if g.Len() != 7 {
+		t.Fatalf("Expecting 7 nodes. Got %d", g.Len())
+	}
+
+	w, found := g.ID("t/w")
+	if !found {
+		t.Fatal("Expecting to find t/w")
+	}
+	if g.Name(w) != "t/w" {
+		t.Errorf("Expecting name t/w for ID %d. Got %q", w, g.Name(w))
+	}
+
+	for _, test := range []struct {
+		name    string
+		out, in []string
+	}{
+		{"t/w", []string{"t/u/i"}, []string{"t/q"}},
+		{"t/u/i", []string{"t/u/x"}, []string{"t/w"}},
+		{"t/a", []string{"t/q"}, nil},
+		{"t/z", nil, []string{"t/u/o"}},
+	} {
+		id, _ := g.ID(test.name)
+		if got := names(g, g.Out(id)); !equal(got, test.out) {
+			t.Errorf("Expecting %s to drive %v. Got %v", test.name, test.out, got)
+		}
+		if got := names(g, g.In(id)); !equal(got, test.in) {
+			t.Errorf("Expecting %s to be driven by %v. Got %v", test.name, test.in, got)
+		}
+	}
+
+	if g.Lookup("t/u/x") == nil || g.Lookup("t/nope") != nil {
+		t.Error("Lookup found the wrong nodes")
+	}
+}
+
+func TestWalk(t *testing.T) {
+	n := testNetlist()
+
+	if changed := n.Walk(); changed == 0 {
+		t.Fatal("Expecting the first walk to change nodes")
+	}
+	if changed := n.Walk(); changed != 0 {
+		t.Errorf("Expecting nothing to change on the second walk. Got %d", changed)
+	}
+
+	for _, test := range []struct {
+		name   string
+		rp, wp string
+	}{
+		{"t/a", "", "01"},
+		{"t/q", "01", "01"},
+		{"t/w", "01", ""},
+		{"t/u/x", "01", ""},
+		{"t/z", "01", ""},
+	} {
+		node := n.LocateNode(test.name)
+		if node.RpAce.String() != test.rp || node.WpAce.String() != test.wp {
+			t.Errorf("Expecting %s r:%s w:%s. Got %v", test.name, test.rp, test.wp, node)
+		}
+	}
+}
+
+func TestGraphNetlist(t *testing.T) {
+	n := NewGraph(testNetlist()).Netlist()
+
+	if n.Name != "t" || n.NumNodes() != 4 || n.NumLinks() != 4 {
+		t.Errorf("Unexpected top netlist %v", n)
+	}
+
+	u := n.Subnets["t/u"]
+	if u == nil {
+		t.Fatal("Expecting subnet t/u")
+	}
+	if u.NumNodes() != 3 || u.NumLinks() != 2 || len(u.Inputs) != 1 {
+		t.Errorf("Unexpected subnet %v", u)
+	}
+}
+
+func names(g *Graph, ids []int32) (s []string) {
+	for _, id := range ids {
+		s = append(s, g.Name(int(id)))
+	}
+	return
+}
+
+func equal(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,92 @@
+package netlist
+
+import (
+	"fmt"
+	"io"
+	"sart/rtl"
+	"sort"
+	"text/tabwriter"
+)
+
+// Mismatch sums up the instances of module Type in module Module whose
+// connections do not line up with the ports of Type. Extra counts connections
+// beyond the last port and Missing counts ports left without a connection,
+// over all such instances.
+type Mismatch struct {
+	Module, Type   string
+	Insts          int
+	Extra, Missing int
+}
+
+// danglePorts hooks each port of subnet that has no connection up to a
+// dangling node in n, in the direction of the port. It returns the number of
+// such ports.
+func danglePorts(n, subnet *Netlist, nname string, ports rtl.PortList, connected map[int]bool) (missing int) {
+	for pos, port := range ports {
+		if connected[pos] {
+			continue
+		}
+		missing++
+
+		fnode := subnet.Nodes[subnet.Name+"/"+port.Name]
+		d := NewDanglingNode(n.Name, fmt.Sprintf("%s:missing:%s", nname, port.Name))
+		n.AddNode(d)
+
+		switch port.Type {
+		case "INPUT":
+			n.Connect(d, fnode)
+		case "OUTPUT":
+			n.Connect(fnode, d)
+		case "INOUT":
+			n.Connect(d, fnode)
+			n.Connect(fnode, d)
+		}
+	}
+	return
+}
+
+// mismatch records an instance of module typ in module mname with mismatched
+// connections.
+func (st *Store) mismatch(mname, typ string, extra, missing int) {
+	st.mmu.Lock()
+	defer st.mmu.Unlock()
+
+	key := [2]string{mname, typ}
+	m, found := st.mismatches[key]
+	if !found {
+		m = &Mismatch{Module: mname, Type: typ}
+		st.mismatches[key] = m
+	}
+	m.Insts++
+	m.Extra += extra
+	m.Missing += missing
+}
+
+// Mismatches returns the mismatches recorded by a tolerant build, sorted by
+// module and instantiated module.
+func (st *Store) Mismatches() (ms []Mismatch) {
+	st.mmu.Lock()
+	defer st.mmu.Unlock()
+
+	for _, m := range st.mismatches {
+		ms = append(ms, *m)
+	}
+	sort.Slice(ms, func(i, j int) bool {
+		if ms[i].Module != ms[j].Module {
+			return ms[i].Module < ms[j].Module
+		}
+		return ms[i].Type < ms[j].Type
+	})
+	return
+}
+
+// WriteMismatches writes ms to w as a table with one line per module pair.
+func WriteMismatches(w io.Writer, ms []Mismatch) error {
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(tw, "MODULE\tINSTANCE OF\tINSTS\tEXTRA PINS\tMISSING PINS")
+	for _, m := range ms {
+		fmt.Fprintf(tw, "%s\t%s\t%d\t%d\t%d\n", m.Module, m.Type, m.Insts,
+			m.Extra, m.Missing)
+	}
+	return tw.Flush()
+}
@@ -1,215 +1,205 @@
 package netlist
 
 import (
+	"context"
 	"log"
-	"sart/ace"
-	"sart/bitfield"
+	"sart/bulk"
+	"sart/rtl"
 	"sync"
 
 	"gopkg.in/mgo.v2"
 	"gopkg.in/mgo.v2/bson"
 )
 
-var mgosession *mgo.Session
-
-const db = "sart"
-
-var nodecoll, linkcoll, snetcoll string
-
-////////////////////////////////////////////////////////////////////////////////
-// Worker pool for insert jobs
+// Store holds the netlist built in one cache. The netlist of each module is
+// saved once, as a template, and the ACE state of nodes that walks have
+// touched is saved per instance. Each Store carries its own session and worker
+// pools, so several caches can be open side by side in one process and a
+// program can build more than once.
+type Store struct {
+	Cache string
+
+	// OrderedLog makes New log built modules in depth-first order, the order
+	// in which a sequential build would finish them, rather than as they are
+	// done.
+	OrderedLog bool
+
+	// Tolerant makes New carry on past instances whose connections do not
+	// match the ports of their module. Extra and missing pins are hooked up to
+	// dangling nodes and the instances are recorded; see Mismatches.
+	Tolerant bool
+
+	// BlackBoxes makes New stop at the modules listed and build each from its
+	// transfer model instead of from what it instantiates.
+	BlackBoxes BlackBoxSpec
+
+	rtl     rtl.ModuleLoader // Module definitions the netlist is built from
+	session *mgo.Session
+	ctx     context.Context
+
+	nodecoll, linkcoll, snetcoll, statecoll string
+
+	// Templates read back by Load. Loading also needs the saved ACE state,
+	// when there is any, and the ACE structs to mark nodes with.
+	tmu       sync.Mutex
+	templates map[string]*template
+	reads     map[string]*sync.Once // Templates being read from mongo
+	hasState  bool
+	selectors []selector
+	marked    int
+
+	mmu        sync.Mutex
+	mismatches map[[2]string]*Mismatch
+
+	// Batched writers for insert and update jobs. Each is only started when
+	// first needed.
+	wonce, uonce    sync.Once
+	writer, updater *bulk.Writer
+}
+
+// Collections returns the names of all collections that hold the built
+// netlist of cache cname, including the ACE state saved by walks.
+func Collections(cname string) []string {
+	return []string{
+		cname + "_nnodes",
+		cname + "_nlinks",
+		cname + "_nsnets",
+		cname + "_nstate",
+	}
+}
+
+// NewStore opens cache cname to build, save and load a netlist. Module
+// definitions are fetched from rs, a Store or a ModuleCache, when building.
+// The collections live in the default database of session s, i.e. the
+// database it was dialed with. Inserts and updates stop when ctx is cancelled.
+func NewStore(ctx context.Context, s *mgo.Session, rs rtl.ModuleLoader, cname string, drop bool) *Store {
+	st := &Store{
+		Cache:     cname,
+		rtl:       rs,
+		session:   s.Copy(),
+		ctx:       ctx,
+		nodecoll:  cname + "_nnodes",
+		linkcoll:  cname + "_nlinks",
+		snetcoll:  cname + "_nsnets",
+		statecoll: cname + "_nstate",
+		templates: make(map[string]*template),
+		reads:     make(map[string]*sync.Once),
+
+		mismatches: make(map[[2]string]*Mismatch),
+	}
 
-const MaxMgoThreads = 8
+	if drop {
+		for _, coll := range Collections(cname) {
+			st.dropCollection(coll)
+		}
+	}
 
-var wg sync.WaitGroup
+	EnsureIndexes(st.session, cname)
 
-type insertjob struct {
-	col string
-	doc interface{}
+	return st
 }
 
-var jobs chan insertjob
-
-func worker() {
-	s := mgosession.Copy()
+func (st *Store) startWriter() {
+	st.wonce.Do(func() {
+		st.writer = bulk.New(st.ctx, st.session, bulk.Workers, bulk.BatchSize)
+	})
+}
 
-	for job := range jobs {
-		c := s.DB(db).C(job.col)
-		err := c.Insert(job.doc)
-		if err != nil {
-			log.Fatal(err)
-		}
-	}
-	wg.Done()
+func (st *Store) startUpdater() {
+	st.uonce.Do(func() {
+		st.updater = bulk.New(st.ctx, st.session, bulk.Workers, bulk.BatchSize)
+	})
 }
 
 // Synchronizers
 
-func DoneMgo() {
-	close(jobs)
+// Done is to be invoked when there are no more netlists to save.
+func (st *Store) Done() {
+	st.startWriter()
+	st.writer.Done()
 }
 
-func WaitMgo() {
-	wg.Wait()
+// Wait waits for all inserts to complete. It returns the error that stopped
+// the inserts early, if any. Wait is to be invoked after Done.
+func (st *Store) Wait() error {
+	stats, err := st.writer.Wait()
+	log.Printf("netlist inserts (%s): %v", st.Cache, stats)
+	return err
 }
 
-////////////////////////////////////////////////////////////////////////////////
-
-var updateJobs chan *Node
-var updateWg sync.WaitGroup
-
-func updateWorker() {
-	s := mgosession.Copy()
-	c := s.DB(db).C(nodecoll)
+// UpdateWait signals that there are no more updates and waits for them to
+// complete. It returns the error that stopped the updates early, if any.
+func (st *Store) UpdateWait() error {
+	st.startUpdater()
+	st.updater.Done()
+	stats, err := st.updater.Wait()
+	log.Printf("netlist updates (%s): %v", st.Cache, stats)
+	return err
+}
 
-	for node := range updateJobs {
-		sel := bson.M{"module": node.Parent, "name": node.Name}
-		err := c.Update(sel, node)
-		if err != nil {
-			log.Fatal(err)
-		}
-	}
-	updateWg.Done()
+// Stopped reports whether saving has failed or been cancelled, in which case
+// there is no point in building any further. A store that has not started
+// saving has not stopped.
+func (st *Store) Stopped() bool {
+	return st.writer != nil && st.writer.Err() != nil
 }
 
-func UpdateWait() {
-	close(updateJobs)
-	updateWg.Wait()
+// Close releases the session of the store.
+func (st *Store) Close() {
+	st.session.Close()
 }
 
 ////////////////////////////////////////////////////////////////////////////////
 
-func InitMgo(s *mgo.Session, cname string, drop bool) {
-	mgosession = s.Copy()
-
-	nodecoll = cname + "_nnodes"
-	linkcoll = cname + "_nlinks"
-	snetcoll = cname + "_nsnets"
-
+// EnsureIndexes creates the indexes that the netlist collections of cache
+// cname rely on. It is safe to call on a cache that already has them.
+func EnsureIndexes(s *mgo.Session, cname string) {
 	var err error
 
-	if drop {
-		dropCollection(nodecoll)
-		dropCollection(linkcoll)
-		dropCollection(snetcoll)
-	}
-
-	n := mgosession.DB(db).C(nodecoll)
+	n := s.DB("").C(cname + "_nnodes")
 	err = n.EnsureIndex(mgo.Index{Key: []string{"module", "name"}, Unique: true})
 	if err != nil {
 		log.Fatal(err)
 	}
 
-	l := mgosession.DB(db).C(linkcoll)
+	l := s.DB("").C(cname + "_nlinks")
 	err = l.EnsureIndex(mgo.Index{Key: []string{"module"}})
 	if err != nil {
 		log.Fatal(err)
 	}
 
-	b := mgosession.DB(db).C(snetcoll)
+	b := s.DB("").C(cname + "_nsnets")
 	err = b.EnsureIndex(mgo.Index{Key: []string{"module", "name"}, Unique: true})
 	if err != nil {
 		log.Fatal(err)
 	}
 
-	// Initialize worker pool for insert jobs
-	jobs = make(chan insertjob, 100)
-	for i := 0; i < MaxMgoThreads; i++ {
-		wg.Add(1)
-		go worker()
+	a := s.DB("").C(cname + "_nstate")
+	err = a.EnsureIndex(mgo.Index{Key: []string{"module", "name"}, Unique: true})
+	if err != nil {
+		log.Fatal(err)
 	}
-
-	updateJobs = make(chan *Node, 100)
-	for i := 0; i < MaxMgoThreads; i++ {
-		updateWg.Add(1)
-		go updateWorker()
+	err = a.EnsureIndex(mgo.Index{Key: []string{"touched"}})
+	if err != nil {
+		log.Fatal(err)
 	}
 }
 
-func dropCollection(coll string) {
-	c := mgosession.DB(db).C(coll)
+func (st *Store) dropCollection(coll string) {
+	c := st.session.DB("").C(coll)
 	err := c.DropCollection()
 	if err != nil {
 		log.Println(err)
 	}
 }
 
-func MarkAceNodes(acestructs []ace.AceStruct) (reset, marked int) {
-	s := mgosession.Copy()
-	c := s.DB(db).C(nodecoll)
-
-	maxace := len(acestructs)
+// Save queues all nodes, links and subnet instances of template netlist n for
+// insertion.
+func (st *Store) Save(n *Netlist) {
+	st.startWriter()
 
-	// Reset the ACE information of all nodes that had changed. ////////////////
-
-	bf := bitfield.New(maxace)
-	sel := bson.M{
-		// Select if read or write port ACE terms has a non-zero character
-		// because if a node was never marked with an ACE value during a walk,
-		// its ACE terms string will be all 0s.
-		"$or": []bson.M{
-			bson.M{"rpace": bson.RegEx{"[^0]", ""}},
-			bson.M{"wpace": bson.RegEx{"[^0]", ""}},
-		},
-	}
-	upd := bson.M{
-		// Update it with an empty bitfield of the required size.
-		"$set": bson.M{
-			"isace":  false,
-			"walked": false,
-			"rpace":  bf,
-			"wpace":  bf,
-		},
-	}
-
-	ci, err := c.UpdateAll(sel, upd)
-	if err != nil {
-		log.Fatal(err)
-	}
-	reset = ci.Updated
-
-	// Mark ACE nodes //////////////////////////////////////////////////////////
-
-	// The index of the ACE struct in the array will be the bit to set in the
-	// bitfield to indicate its contribution to the pAVF equation.
-	for i, s := range acestructs {
-		rpbf := bitfield.New(maxace)
-		wpbf := bitfield.New(maxace)
-		rpbf.Set(i)
-		wpbf.Set(i)
-
-		sel := bson.M{}
-
-		if s.Selector.Module != "" {
-			sel["module"] = bson.RegEx{s.Selector.Module, ""}
-		}
-		if s.Selector.Name != "" {
-			sel["name"] = bson.RegEx{s.Selector.Name, ""}
-		}
-
-		upd := bson.M{
-			"$set": bson.M{
-				"isace": true,
-				"rpace": rpbf,
-				"wpace": wpbf,
-			},
-		}
-
-		ci, err := c.UpdateAll(sel, upd)
-		if err != nil {
-			log.Fatal(err)
-		}
-		log.Printf("(%d/%d) Marked %d nodes ACE with %v", i+1, maxace,
-			ci.Updated, s)
-		marked += ci.Updated
-	}
-
-	return
-}
-
-func (n *Netlist) Save() {
 	for _, node := range n.Nodes {
-		jobs <- insertjob{nodecoll, node}
+		st.writer.Insert(st.nodecoll, node)
 	}
 
 	// Links is a map of right-nodes indexed using the fullname of the
@@ -223,7 +213,7 @@ func (n *Netlist) Save() {
 				"lfullname": lfullname,
 				"rfullname": rnode.Fullname(),
 			}
-			jobs <- insertjob{linkcoll, doc}
+			st.writer.Insert(st.linkcoll, doc)
 		}
 	}
 
@@ -231,192 +221,30 @@ func (n *Netlist) Save() {
 		doc := bson.M{
 			"module": n.Name,
 			"name":   subnet.Name,
+			"type":   subnet.Type,
 		}
-		jobs <- insertjob{snetcoll, doc}
+		st.writer.Insert(st.snetcoll, doc)
 	}
 }
 
-func (n *Netlist) Update() (count int) {
+// Update queues the ACE state of every node in netlist n, at any depth, that
+// is ACE or that a walk has touched with an ACE value. It returns the number
+// of such nodes. The saved state is expected to have been cleared by
+// MarkAceNodes before the walks.
+func (st *Store) Update(n *Netlist) (count int) {
+	st.startUpdater()
+
 	for _, node := range n.Nodes {
-		// If a node that is not ace has been touched by an ACE value, update
-		// to reflect this in mongo.
-		if !node.IsAce && (!node.RpAce.AllUnset() || !node.WpAce.AllUnset()) {
-			updateJobs <- node
+		if node.IsAce || !node.RpAce.AllUnset() || !node.WpAce.AllUnset() {
+			node.Touched = true
+			st.updater.Insert(st.statecoll, node)
 			count++
 		}
 	}
 
 	for _, subnet := range n.Subnets {
-		count += subnet.Update()
+		count += st.Update(subnet)
 	}
 
 	return
 }
-
-func (n *Netlist) Load() {
-	n.LoadNodes(0)
-	n.LoadLinks(0)
-}
-
-func (n *Netlist) LoadNodes(level int) {
-	// log.Printf("Nodes Load (%d) %q", level, n.Name)
-	var result bson.M
-
-	// nodes collection, query and iterator
-	nc := mgosession.DB(db).C(nodecoll)
-	nq := nc.Find(bson.M{"module": n.Name})
-	ni := nq.Iter()
-
-	for ni.Next(&result) {
-		bytes, err := bson.Marshal(result)
-		if err != nil {
-			log.Fatalf("Unable to marshal. module:%q name:%q err:%v",
-				result["module"], result["name"], err)
-		}
-
-		var node Node
-		err = bson.Unmarshal(bytes, &node)
-		if err != nil {
-			log.Fatalf("Unable to umarshal. module:%q name:%q err:%v",
-				result["module"], result["name"], err)
-		}
-
-		n.AddNode(&node)
-	}
-
-	// Use parallel loader for subnet nodes.
-	loader := NewNodeLoader(level + 1)
-
-	// subnet collection, query and iterator
-	sc := mgosession.DB(db).C(snetcoll)
-	sq := sc.Find(bson.M{"module": n.Name}).Select(bson.M{"_id": 0, "module": 0})
-	si := sq.Iter()
-
-	for si.Next(&result) {
-		fullname := result["name"].(string)
-		subnet := NewNetlist(fullname)
-		n.Subnets[fullname] = subnet
-
-		// This is effectively subnet.LoadNodes() except that this will run in
-		// parallel through a worker pool.
-		loader.Add(subnet)
-	}
-
-	// Indicate that there will be no more load jobs and wait till all subnets
-	// are loaded. If we don't wait the links being loaded next will not have
-	// all the nodes needed to get hooked up.
-	loader.Done()
-	loader.Wait()
-
-	// log.Printf("Nodes Done (%d) %q", level, n.Name)
-}
-
-func (n *Netlist) LoadLinks(level int) {
-	// log.Printf("Links Load (%d) %q", level, n.Name)
-	var result bson.M
-
-	// link collection, query and iterator
-	lc := mgosession.DB(db).C(linkcoll)
-	lq := lc.Find(bson.M{"module": n.Name}).Select(bson.M{"_id": 0})
-	li := lq.Iter()
-
-	for li.Next(&result) {
-		lfullname := result["lfullname"].(string)
-		rfullname := result["rfullname"].(string)
-		lnode := n.LocateNode(lfullname)
-		rnode := n.LocateNode(rfullname)
-
-		if lnode == nil {
-			log.Fatalf("Could not locate lnode %q in netlist %q", lfullname, n.Name)
-		}
-
-		if rnode == nil {
-			log.Fatalf("Could not locate rnode %q in netlist %q", rfullname, n.Name)
-		}
-
-		n.Connect(lnode, rnode)
-	}
-
-	// Use parallel loader for subnet links.
-	loader := NewLinkLoader(level + 1)
-
-	for _, subnet := range n.Subnets {
-		// This is effectively subnet.LoadLinks() except that this will run in
-		// parallel through a worker pool.
-		loader.Add(subnet)
-	}
-
-	loader.Done()
-	loader.Wait()
-
-	// log.Printf("Links Done (%d) %q", level, n.Name)
-}
-
-////////////////////////////////////////////////////////////////////////////////
-
-type Loader interface {
-	LoadNodes(int)
-	LoadLinks(int)
-}
-
-type NetlistLoader struct {
-	loadjobs chan Loader
-	wg       sync.WaitGroup
-}
-
-func NewNetlistLoader() *NetlistLoader {
-	l := &NetlistLoader{
-		loadjobs: make(chan Loader, 1000),
-	}
-	return l
-}
-
-func NewNodeLoader(level int) *NetlistLoader {
-	l := NewNetlistLoader()
-
-	for i := 0; i < MaxMgoThreads; i++ {
-		l.wg.Add(1)
-		go func() {
-			for job := range l.loadjobs {
-				job.LoadNodes(level)
-			}
-			l.wg.Done()
-		}()
-	}
-
-	return l
-}
-
-func NewLinkLoader(level int) *NetlistLoader {
-	l := NewNetlistLoader()
-
-	for i := 0; i < MaxMgoThreads; i++ {
-		l.wg.Add(1)
-		go func() {
-			for job := range l.loadjobs {
-				job.LoadLinks(level)
-			}
-			l.wg.Done()
-		}()
-	}
-
-	return l
-}
-
-// Add a job to the loader
-func (l *NetlistLoader) Add(job Loader) {
-	l.loadjobs <- job
-}
-
-// Done is to be invoked when there are no more jobs for NetlistLoader. It
-// closes the internal channel used for scheduling and synchronizing.
-func (l *NetlistLoader) Done() {
-	close(l.loadjobs)
-}
-
-// Wait waits till all the load jobs have completed. Wait is to be invoked
-// after Done is invoked to indicate that there will be no more jobs for
-// NetlistLoader.
-func (l *NetlistLoader) Wait() {
-	l.wg.Wait()
-}
@@ -21,38 +21,58 @@ type Node struct {
 	IsSeqn bool
 	IsWire bool
 	IsAce  bool
-	RpAce  *bitfield.BitField
-	WpAce  *bitfield.BitField
+
+	// Terms of the ACE structs that reach the node on its read and write
+	// ports. They are nil until the node gains a term, which most nodes never
+	// do.
+	RpAce *bitfield.BitField
+	WpAce *bitfield.BitField
+
+	// IsClock is set for the run by MarkClocks on nodes of clock and reset
+	// networks, which walks do not go through. It is not saved.
+	IsClock bool `bson:"-"`
+
+	// IsStop is set on the arcs of black boxes that ACE terms do not cross.
+	// Walks do not go through such nodes.
+	IsStop bool
+
+	// Touched is set in storage for nodes that are ACE or carry ACE values
+	// from a walk, so that they can be found again without scanning bitfields.
+	Touched bool
 }
 
-func NewNode(parent, name, typ string, bfsize int) *Node {
+func NewNode(parent, name, typ string) *Node {
 	return &Node{
 		Parent: parent,
 		Name:   name,
 		Type:   typ,
-		RpAce:  bitfield.New(bfsize),
-		WpAce:  bitfield.New(bfsize),
 	}
 }
 
-func NewPortNode(parent, name, typ string, bfsize int) *Node {
-	p := NewNode(parent, name, typ, bfsize)
+func NewPortNode(parent, name, typ string) *Node {
+	p := NewNode(parent, name, typ)
 	p.IsPort = true
 	return p
 }
 
-func NewPrimNode(parent, name, typ string, bfsize int) *Node {
-	p := NewNode(parent, name, typ, bfsize)
+func NewPrimNode(parent, name, typ string) *Node {
+	p := NewNode(parent, name, typ)
 	p.IsPrim = true
 	return p
 }
 
-func NewWireNode(parent, name string, bfsize int) *Node {
-	w := NewNode(parent, name, "WIRE", bfsize)
+func NewWireNode(parent, name string) *Node {
+	w := NewNode(parent, name, "WIRE")
 	w.IsWire = true
 	return w
 }
 
+// NewDanglingNode returns a node standing in for a pin that exists on only one
+// side of an instance connection. See Store.Tolerant.
+func NewDanglingNode(parent, name string) *Node {
+	return NewNode(parent, name, "DANGLING")
+}
+
 func (n Node) String() (str string) {
 	str += "["
 	switch {
@@ -67,6 +87,12 @@ func (n Node) String() (str string) {
 	if n.IsAce {
 		str += " ACE"
 	}
+	if n.IsClock {
+		str += " CLOCK"
+	}
+	if n.IsStop {
+		str += " STOP"
+	}
 	str += "] "
 	str += fmt.Sprintf("r:'%v' w:'%v'", n.RpAce, n.WpAce)
 	return
@@ -80,7 +106,8 @@ func (n Node) Fullname() string {
 
 type Netlist struct {
 	Name    string
-	IsAce   bool
+	Type    string // Module this is an instance of
+	IsAce   bool   // Marked ACE as a whole subtree; only its ports are loaded
 	Ports   []*rtl.Port
 	Nodes   map[string]*Node   // Holds all nodes
 	Inputs  map[string]*Node   // Holds all nodes corresponding to input ports
@@ -89,6 +116,18 @@ type Netlist struct {
 	Links   map[string][]*Node // Map from left-node's fullname to right-nodes
 	Rlinks  map[string][]*Node // Map from right-node's fullname to left-nodes
 	Subnets map[string]*Netlist
+
+	// A loaded netlist starts out holding only its port nodes. The rest of it
+	// is filled in from the template of its module by Expand.
+	store    *Store
+	expanded bool
+
+	// Saved ACE state of the nodes of this instance by name, read when it
+	// is loaded and dropped once it is expanded
+	state map[string]*Node
+
+	// Graph of the netlist, kept until nodes or links are added at this level
+	graph *Graph
 }
 
 func NewNetlist(name string) *Netlist {
@@ -105,44 +144,59 @@ func NewNetlist(name string) *Netlist {
 	return n
 }
 
-func New(prefix, mname, iname string, bfsize, level int) *Netlist {
-	m := rtl.LoadModule(mname)
+// build builds the template netlist of module m for New to save. Instantiated
+// modules are looked up in defs, but only for their ports, so templates can be
+// built in any order. Node and link names in the template are prefixed with
+// the module name in place of an instance path.
+func (st *Store) build(m *rtl.Module, defs map[string]*rtl.Module) *Netlist {
+	mname := m.Name
 
-	// log.Printf("%s%s [%v] ACE:%v", prefix, iname, m)
-
-	n := NewNetlist(iname)
+	n := NewNetlist(mname)
+	n.Type = mname
 
 	// All ports trivially become nodes
 	for pos, port := range m.OrderedPorts() {
 		pname := port.Name
 
-		p := NewPortNode(iname, pname, port.Type, bfsize)
+		p := NewPortNode(mname, pname, port.Type)
 		n.AddNode(p)
 
 		// This list is needed only to look up the formal node at the time of
 		// netlist construction. It will not be saved to mongo, nor will it be
 		// populated in a Load()-ed netlist.
-		nport := rtl.NewPort(iname, pname, pos)
+		nport := rtl.NewPort(mname, pname, pos)
 		n.Ports = append(n.Ports, nport)
 	}
 
+	// A black box is made of its transfer model rather than its contents
+	if bb := st.BlackBoxes[mname]; bb != nil {
+		bb.model(n)
+		return n
+	}
+
 	// Go through all connections -- actual names of all instance connections.
 	// If a name has not already been encountered as a port, add it as a wire.
 	for _, conns := range m.Conns {
 		for _, conn := range conns {
-			w := NewWireNode(iname, conn.Actual, bfsize)
+			w := NewWireNode(mname, conn.Actual)
 			n.AddNode(w)
 		}
 	}
 
 	// Go through all the instantiations. If primitive add a primitive node. If
-	// a defined module, create a subnet for it an add it to the set of subnets
+	// a defined module, add an instance of its template to the set of subnets
 	// at this level.
 	for nname, inst := range m.Insts {
-		// log.Printf("Inst:%q Type:%s Prim:%v", nname, inst.Type, inst.IsPrim)
-		fullname := iname + "/" + nname
+		// Stop descending once saving has failed or been cancelled. The ports
+		// are in place so the caller can still hook this netlist up. Wait
+		// reports what went wrong.
+		if st.Stopped() {
+			return n
+		}
+
+		fullname := mname + "/" + nname
 		if inst.IsPrim {
-			prim := NewPrimNode(iname, nname, inst.Type, bfsize)
+			prim := NewPrimNode(mname, nname, inst.Type)
 			n.AddNode(prim)
 
 			// Update whether or not this node is a sequential
@@ -152,13 +206,10 @@ func New(prefix, mname, iname string, bfsize, level int) *Netlist {
 			// corresponding node and link it to this primitive node. By now
 			// all signals should exist as either a port or a wire node.
 			for _, c := range m.Conns[nname] {
-				// This node will be indexed with a name that has the unique
-				// prefix of this parent instance -- iname.
-				nodename := iname + "/" + c.Actual
+				nodename := mname + "/" + c.Actual
 				if node, ok := n.Nodes[nodename]; !ok {
 					log.Fatal("Could not locate actual node:", nodename)
 				} else {
-					// log.Printf("%sP: %v <-> %v", prefix, node, prim)
 					switch c.Type {
 					case "INPUT":
 						n.Connect(node, prim)
@@ -173,14 +224,21 @@ func New(prefix, mname, iname string, bfsize, level int) *Netlist {
 				}
 			}
 		} else {
-			subnet := New(prefix+"|  ", inst.Type, iname+"/"+inst.Name, bfsize, level+1)
+			def := defs[inst.Type]
+			if def == nil {
+				log.Fatalf("No definition of module %q instantiated as %q", inst.Type, fullname)
+			}
+			ports := def.OrderedPorts()
+			subnet := stub(fullname, def, ports)
 			n.Subnets[fullname] = subnet
 
+			connected := make(map[int]bool)
+			extra := 0
+
 			for _, c := range m.Conns[nname] {
 				// Locate actual node. This should be a node (port or wire) at
-				// this level by now. It will be indexed at this level with a
-				// name with the unique prefix of this parent instance -- iname
-				aname := iname + "/" + c.Actual
+				// this level by now.
+				aname := mname + "/" + c.Actual
 				anode := n.Nodes[aname]
 				if anode == nil {
 					log.Fatal("Could not locate actual node:", aname)
@@ -189,33 +247,57 @@ func New(prefix, mname, iname string, bfsize, level int) *Netlist {
 				// Locate formal node. This should be a port in the subnet at
 				// the exact position as this connection's position. If node
 				// cannot be located, abort rightaway -- something went wrong.
-				if c.Pos >= len(subnet.Ports) {
-					log.Fatalf("Seeking port position %d in subnet %v of netlist %v. Number of available ports: %d",
-						c.Pos, subnet, n, len(subnet.Ports))
-				}
-				fname := fullname + "/" + subnet.Ports[c.Pos].Name
-				if fnode, ok := subnet.Nodes[fname]; !ok {
-					log.Fatal("Could not locate formal node", fname)
+				// A tolerant build hooks the pin up to a dangling node instead.
+				var fnode *Node
+				if c.Pos >= len(ports) {
+					if !st.Tolerant {
+						log.Fatalf("Seeking port position %d in subnet %v of netlist %v. Number of available ports: %d",
+							c.Pos, subnet, n, len(ports))
+					}
+					fnode = NewDanglingNode(mname, fmt.Sprintf("%s:extra%d", nname, c.Pos))
+					n.AddNode(fnode)
+					extra++
 				} else {
-					// log.Printf("%sS: %v <-> %v", prefix, anode, fnode)
-					switch c.Type {
-					case "INPUT":
-						n.Connect(anode, fnode)
-					case "OUTPUT":
-						n.Connect(fnode, anode)
-					case "INOUT":
-						n.Connect(anode, fnode)
-						n.Connect(fnode, anode)
-					default:
-						log.Fatal("Unexpected conn type:", c.Type)
+					fname := fullname + "/" + ports[c.Pos].Name
+					if fnode = subnet.Nodes[fname]; fnode == nil {
+						log.Fatal("Could not locate formal node", fname)
 					}
+					connected[c.Pos] = true
+				}
+
+				switch c.Type {
+				case "INPUT":
+					n.Connect(anode, fnode)
+				case "OUTPUT":
+					n.Connect(fnode, anode)
+				case "INOUT":
+					n.Connect(anode, fnode)
+					n.Connect(fnode, anode)
+				default:
+					log.Fatal("Unexpected conn type:", c.Type)
 				}
 			}
+
+			if st.Tolerant && (extra > 0 || len(connected) < len(ports)) {
+				missing := danglePorts(n, subnet, nname, ports, connected)
+				st.mismatch(mname, inst.Type, extra, missing)
+			}
 		}
 	}
 
-	n.Save()
-	log.Printf("Done (%d) %q", level, mname)
+	return n
+}
+
+// stub returns an unexpanded instance of module def with the given instance
+// path. It holds just the port nodes, which is all that the template of the
+// parent links to.
+func stub(name string, def *rtl.Module, ports rtl.PortList) *Netlist {
+	n := NewNetlist(name)
+	n.Type = def.Name
+
+	for _, port := range ports {
+		n.AddNode(NewPortNode(name, port.Name, port.Type))
+	}
 
 	return n
 }
@@ -224,11 +306,13 @@ func New(prefix, mname, iname string, bfsize, level int) *Netlist {
 // the node is saved beacuse the node can be looked up easily with that name if
 // needed.
 func (n *Netlist) Connect(l *Node, r *Node) {
+	n.graph = nil
 	n.Links[l.Fullname()] = append(n.Links[l.Fullname()], r)
 	n.Rlinks[r.Fullname()] = append(n.Rlinks[r.Fullname()], l)
 }
 
 func (n *Netlist) AddNode(node *Node) {
+	n.graph = nil
 	fullname := node.Fullname()
 	if _, found := n.Nodes[fullname]; found {
 		if node.IsWire {
@@ -251,37 +335,76 @@ func (n *Netlist) AddNode(node *Node) {
 	case "OUTPUT":
 		n.Outputs[fullname] = node
 	}
-
-	n.IsAce = node.IsAce
 }
 
+// LocateNode returns the node with the given full name, at this level or in a
+// subnet at any depth. Subnets on the way are expanded as needed, but ports of
+// a subnet are found without expanding it. Once the graph of the netlist has
+// been made, nodes are found by looking them up in it.
 func (n *Netlist) LocateNode(name string) *Node {
-	var node *Node
-	var subnet *Netlist
-	var found bool
+	if n.graph != nil {
+		return n.graph.Lookup(name)
+	}
 
 	// If a node with this name exists at this level, it can be readily found
 	// in Nodes. Return it.
-	if node, found = n.Nodes[name]; found {
+	if node, found := n.Nodes[name]; found {
 		return node
 	}
 
-	// If not found at this level, it could be a port in a subnet. Assuming the
-	// name is a full name, derive the subnet name and port name, then search.
-	parts := strings.Split(name, "/")
-	subnetname := strings.Join(parts[0:len(parts)-1], "/")
-
-	// If there is no subnet with this name, no node was found
-	if subnet, found = n.Subnets[subnetname]; !found {
+	// Otherwise the next component of the name, below this netlist, names the
+	// subnet to look in.
+	if !strings.HasPrefix(name, n.Name+"/") {
+		return nil
+	}
+	rest := name[len(n.Name)+1:]
+	i := strings.Index(rest, "/")
+	if i < 0 {
 		return nil
 	}
 
-	// To be found, the name should match a node in this subnet
-	if node, found = subnet.Nodes[name]; found {
+	subnet, found := n.Subnets[n.Name+"/"+rest[:i]]
+	if !found {
+		return nil
+	}
+	if node, found := subnet.Nodes[name]; found {
 		return node
 	}
 
-	return nil
+	subnet.Expand()
+	return subnet.LocateNode(name)
+}
+
+// Subnet returns the netlist with the given full name: n itself or a subnet at
+// any depth. Subnets on the way are expanded as needed.
+func (n *Netlist) Subnet(name string) *Netlist {
+	if name == n.Name {
+		return n
+	}
+	if !strings.HasPrefix(name, n.Name+"/") {
+		return nil
+	}
+
+	n.Expand()
+
+	rest := name[len(n.Name)+1:]
+	if i := strings.Index(rest, "/"); i >= 0 {
+		rest = rest[:i]
+	}
+	subnet, found := n.Subnets[n.Name+"/"+rest]
+	if !found {
+		return nil
+	}
+	return subnet.Subnet(name)
+}
+
+// Graph returns the graph of the netlist, making it on first use. The netlist
+// and all of its subnets are expanded in the process.
+func (n *Netlist) Graph() *Graph {
+	if n.graph == nil {
+		n.graph = NewGraph(n)
+	}
+	return n.graph
 }
 
 func (n Netlist) String() (str string) {
@@ -0,0 +1,160 @@
+package netlist
+
+import (
+	"fmt"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// Match is a node found by Resolve along with the netlist that holds it.
+type Match struct {
+	Node    *Node
+	Netlist *Netlist
+}
+
+// component matches one component of a path pattern against a name.
+type component func(name string) bool
+
+// anyDepth stands for a "**" component, which matches any number of levels.
+var anyDepth component = nil
+
+var BadPattern error = fmt.Errorf("-!- Bad Pattern Error")
+
+// Resolve returns every node whose full name matches pattern. The pattern is a
+// full name, starting with the name of netlist n, with components separated by
+// "/". Each component is one of:
+//
+//	name     matched literally
+//	a*b?     a shell pattern as understood by path.Match
+//	~regex   a regular expression that must match the whole component
+//	**       any number of levels of subnets, including none
+//
+// A pattern that ends in "**" matches every node below where the "**" starts,
+// so "**" alone matches every node of n. Subnets are expanded as the search
+// descends into them. Matches are returned in order of their full names.
+func (n *Netlist) Resolve(pattern string) ([]Match, error) {
+	comps, err := parsePattern(pattern)
+	if err != nil {
+		return nil, err
+	}
+
+	// The leading components have to match the name of n itself. A "**"
+	// among them can leave more than one way to go on from there.
+	found := make(map[*Node]*Netlist)
+	for _, rest := range heads(comps, strings.Split(n.Name, "/")) {
+		if len(rest) > 0 {
+			n.resolve(rest, found)
+		}
+	}
+
+	var matches []Match
+	for node, owner := range found {
+		matches = append(matches, Match{node, owner})
+	}
+	sortMatches(matches)
+
+	return matches, nil
+}
+
+// heads returns what is left of comps once they have matched names, in every
+// way they can.
+func heads(comps []component, names []string) (rests [][]component) {
+	if len(names) == 0 {
+		return [][]component{comps}
+	}
+	if len(comps) == 0 {
+		return nil
+	}
+
+	comp, rest := comps[0], comps[1:]
+	if comp == nil {
+		return append(heads(rest, names), heads(comps, names[1:])...)
+	}
+	if !comp(names[0]) {
+		return nil
+	}
+	return heads(rest, names[1:])
+}
+
+func (n *Netlist) resolve(comps []component, found map[*Node]*Netlist) {
+	n.Expand()
+
+	comp, rest := comps[0], comps[1:]
+
+	if comp == nil {
+		// "**" matches no level at all, and so continues here with the rest,
+		// or one more level, and so continues in every subnet with itself.
+		// With nothing after it, it matches every node on the way.
+		if len(rest) > 0 {
+			n.resolve(rest, found)
+		} else {
+			for _, node := range n.Nodes {
+				found[node] = n
+			}
+		}
+		for _, subnet := range n.Subnets {
+			subnet.resolve(comps, found)
+		}
+		return
+	}
+
+	if len(rest) == 0 {
+		for _, node := range n.Nodes {
+			if comp(node.Name) {
+				found[node] = n
+			}
+		}
+		return
+	}
+
+	for _, subnet := range n.Subnets {
+		if comp(subnet.Shortname()) {
+			subnet.resolve(rest, found)
+		}
+	}
+}
+
+func sortMatches(matches []Match) {
+	sort.Slice(matches, func(i, j int) bool {
+		return matches[i].Node.Fullname() < matches[j].Node.Fullname()
+	})
+}
+
+func parsePattern(pattern string) (comps []component, err error) {
+	for _, c := range strings.Split(pattern, "/") {
+		switch {
+		case c == "":
+			return nil, fmt.Errorf("%v: empty component in %q", BadPattern, pattern)
+
+		case c == "**":
+			comps = append(comps, anyDepth)
+
+		case strings.HasPrefix(c, "~"):
+			re, err := regexp.Compile("^(?:" + c[1:] + ")$")
+			if err != nil {
+				return nil, fmt.Errorf("%v: %v", BadPattern, err)
+			}
+			comps = append(comps, re.MatchString)
+
+		case strings.ContainsAny(c, "*?["):
+			if _, err := path.Match(c, ""); err != nil {
+				return nil, fmt.Errorf("%v: %q: %v", BadPattern, c, err)
+			}
+			glob := c
+			comps = append(comps, func(name string) bool {
+				ok, _ := path.Match(glob, name)
+				return ok
+			})
+
+		default:
+			literal := c
+			comps = append(comps, func(name string) bool {
+				return name == literal
+			})
+		}
+	}
+
+	return
+}
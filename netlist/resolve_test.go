@@ -0,0 +1,96 @@
+package netlist
+
+import (
+	"strings"
+	"testing"
+)
+
+// deepNetlist returns t with subnets t/m and t/n, each holding a subnet c with
+// a wire w, plus the nodes of testNetlist.
+func deepNetlist() *Netlist {
+	f := &fixture{testNetlist(), 8}
+	for _, name := range []string{"m", "n"} {
+		f.Sub(name, "").Sub("c", "").Wire("w").Port("INPUT", "in0")
+	}
+	return f.Netlist()
+}
+
+func TestLocateNodeDeep(t *testing.T) {
+	n := deepNetlist()
+
+	for _, name := range []string{"t/q", "t/u/i", "t/u/x", "t/m/c/w", "t/n/c/in0"} {
+		node := n.LocateNode(name)
+		if node == nil || node.Fullname() != name {
+			t.Errorf("Expecting to locate %q. Got %v", name, node)
+		}
+	}
+
+	for _, name := range []string{"t/m/c/nope", "t/nope/c/w", "x/m/c/w", "t/m"} {
+		if node := n.LocateNode(name); node != nil {
+			t.Errorf("Expecting not to locate %q. Got %v", name, node)
+		}
+	}
+}
+
+func TestSubnet(t *testing.T) {
+	n := deepNetlist()
+
+	for _, name := range []string{"t", "t/u", "t/m/c"} {
+		if s := n.Subnet(name); s == nil || s.Name != name {
+			t.Errorf("Expecting subnet %q. Got %v", name, s)
+		}
+	}
+
+	for _, name := range []string{"t/x", "t/m/c/w", "x/m", "tt"} {
+		if s := n.Subnet(name); s != nil {
+			t.Errorf("Expecting no subnet %q. Got %v", name, s)
+		}
+	}
+}
+
+func TestResolve(t *testing.T) {
+	n := deepNetlist()
+
+	for _, test := range []struct {
+		pattern string
+		exp     []string
+	}{
+		{"t/m/c/w", []string{"t/m/c/w@t/m/c"}},
+		{"t/*/c/w", []string{"t/m/c/w@t/m/c", "t/n/c/w@t/n/c"}},
+		{"t/~[mn]/c/in[0-9]", []string{"t/m/c/in0@t/m/c", "t/n/c/in0@t/n/c"}},
+		{"t/**/w", []string{"t/m/c/w@t/m/c", "t/n/c/w@t/n/c", "t/w@t"}},
+		{"t/**/u/?", []string{"t/u/i@t/u", "t/u/o@t/u", "t/u/x@t/u"}},
+		{"t/~m/c/w", []string{"t/m/c/w@t/m/c"}},
+		{"t/m/**", []string{"t/m/c/in0@t/m/c", "t/m/c/w@t/m/c"}},
+		{"**/c/w", []string{"t/m/c/w@t/m/c", "t/n/c/w@t/n/c"}},
+		{"**/t/u/**", []string{"t/u/i@t/u", "t/u/o@t/u", "t/u/x@t/u"}},
+		{"t/~c/w", nil},
+		{"x/m/c/w", nil},
+		{"t", nil},
+	} {
+		matches, err := n.Resolve(test.pattern)
+		if err != nil {
+			t.Errorf("%q: unexpected error %v", test.pattern, err)
+			continue
+		}
+
+		var got []string
+		for _, m := range matches {
+			got = append(got, m.Node.Fullname()+"@"+m.Netlist.Name)
+		}
+		if strings.Join(got, " ") != strings.Join(test.exp, " ") {
+			t.Errorf("%q: expecting %v. Got %v", test.pattern, test.exp, got)
+		}
+	}
+
+	all, err := n.Resolve("**")
+	if exp := len(n.Graph().Nodes); err != nil || len(all) != exp {
+		t.Errorf("Expecting ** to match all %d nodes. Got %d %v", exp, len(all), err)
+	}
+
+	for _, pattern := range []string{"t//w", "t/~(/w", "t/[/w"} {
+		if _, err := n.Resolve(pattern); err == nil {
+			t.Errorf("Expecting an error for pattern %q", pattern)
+		}
+	}
+}
@@ -0,0 +1,299 @@
+package netlist
+
+import (
+	"fmt"
+	"log"
+	"sort"
+)
+
+// Loop is a combinational cycle: a strongly connected set of nodes that are
+// not sequential, ACE, clock, reset or stopping nodes, holding at least one
+// prim. Walks go round loops until the terms of their nodes stop changing.
+type Loop struct {
+	Nodes   []int  // IDs in the graph, in order of name
+	Netlist string // Innermost netlist holding the whole loop
+	Module  string // Module of Netlist
+}
+
+// Loops returns the combinational cycles of the graph, largest first, then by
+// where they are in the hierarchy. Nodes joined both ways only through ports
+// and wires, as inout connections are, do not make a loop.
+func (g *Graph) Loops() (loops []Loop) {
+	comb := func(id int32) bool {
+		node := g.Nodes[id]
+		return !node.IsSeqn && !node.IsAce && !node.IsClock && !node.IsStop
+	}
+
+	for _, scc := range g.sccs(comb) {
+		prims := false
+		for _, id := range scc {
+			prims = prims || g.Nodes[id].IsPrim
+		}
+		if !prims {
+			continue
+		}
+
+		loop := Loop{Nodes: make([]int, len(scc))}
+		net := g.netof[scc[0]]
+		for i, id := range scc {
+			loop.Nodes[i] = int(id)
+			net = g.commonNet(net, g.netof[id])
+		}
+		sort.Slice(loop.Nodes, func(i, j int) bool {
+			return g.names[loop.Nodes[i]] < g.names[loop.Nodes[j]]
+		})
+		loop.Netlist, loop.Module = g.nets[net].name, g.nets[net].typ
+
+		loops = append(loops, loop)
+	}
+
+	sort.Slice(loops, func(i, j int) bool {
+		a, b := loops[i], loops[j]
+		if len(a.Nodes) != len(b.Nodes) {
+			return len(a.Nodes) > len(b.Nodes)
+		}
+		if a.Netlist != b.Netlist {
+			return a.Netlist < b.Netlist
+		}
+		return g.names[a.Nodes[0]] < g.names[b.Nodes[0]]
+	})
+
+	return
+}
+
+// sccs returns the strongly connected components of the nodes for which in is
+// true, keeping only those with more than one node or a link to itself. It is
+// Tarjan's algorithm, with an explicit stack as netlists are deep enough to
+// exhaust the goroutine stack.
+func (g *Graph) sccs(in func(int32) bool) (sccs [][]int32) {
+	const unvisited = -1
+
+	index := make([]int32, len(g.Nodes))
+	lowlink := make([]int32, len(g.Nodes))
+	onstack := make([]bool, len(g.Nodes))
+	for i := range index {
+		index[i] = unvisited
+	}
+
+	var stack []int32 // Nodes of components not yet complete
+	type frame struct {
+		id   int32
+		next int // Next edge to follow
+	}
+	var next int32
+
+	for root := range g.Nodes {
+		if index[root] != unvisited || !in(int32(root)) {
+			continue
+		}
+
+		calls := []frame{{int32(root), 0}}
+		index[root], lowlink[root] = next, next
+		next++
+		stack = append(stack, int32(root))
+		onstack[root] = true
+
+		for len(calls) > 0 {
+			f := &calls[len(calls)-1]
+			u := f.id
+			edges := g.out.edges(u)
+
+			if f.next < len(edges) {
+				v := edges[f.next]
+				f.next++
+				switch {
+				case !in(v):
+				case index[v] == unvisited:
+					index[v], lowlink[v] = next, next
+					next++
+					stack = append(stack, v)
+					onstack[v] = true
+					calls = append(calls, frame{v, 0})
+				case onstack[v] && index[v] < lowlink[u]:
+					lowlink[u] = index[v]
+				}
+				continue
+			}
+
+			// All edges of u followed: return to the caller
+			calls = calls[:len(calls)-1]
+			if len(calls) > 0 {
+				if caller := calls[len(calls)-1].id; lowlink[u] < lowlink[caller] {
+					lowlink[caller] = lowlink[u]
+				}
+			}
+
+			if lowlink[u] != index[u] {
+				continue
+			}
+
+			// u is the root of a component
+			var scc []int32
+			for {
+				v := stack[len(stack)-1]
+				stack = stack[:len(stack)-1]
+				onstack[v] = false
+				scc = append(scc, v)
+				if v == u {
+					break
+				}
+			}
+			if len(scc) > 1 || g.selfLinked(u) {
+				sccs = append(sccs, scc)
+			}
+		}
+	}
+
+	return
+}
+
+func (g *Graph) selfLinked(id int32) bool {
+	for _, v := range g.out.edges(id) {
+		if v == id {
+			return true
+		}
+	}
+	return false
+}
+
+// commonNet returns the innermost netlist that holds both netlists a and b.
+func (g *Graph) commonNet(a, b int32) int32 {
+	for g.nets[a].depth > g.nets[b].depth {
+		a = g.nets[a].parent
+	}
+	for g.nets[b].depth > g.nets[a].depth {
+		b = g.nets[b].parent
+	}
+	for a != b {
+		a, b = g.nets[a].parent, g.nets[b].parent
+	}
+	return a
+}
+
+////////////////////////////////////////////////////////////////////////////////
+
+// Condensed is a graph in which each loop has been replaced by a single node
+// standing in for all of it. Nodes of a loop all carry the same terms once
+// walks are done, so walking the condensed graph and handing the terms of
+// each stand-in back to its loop gives the same result as walking the full
+// graph, without going round loops.
+type Condensed struct {
+	*Graph
+
+	full    *Graph
+	members [][]int32 // IDs in the full graph of the loop each stand-in replaces
+}
+
+// Condense returns graph g with each of loops replaced by a stand-in node. The
+// stand-in of loop i is named "loop<i>" in the netlist holding the loop.
+func (g *Graph) Condense(loops []Loop) *Condensed {
+	c := &Condensed{
+		Graph: &Graph{
+			nets: g.nets,
+		},
+		full: g,
+	}
+
+	// Every node of the full graph maps to itself or to its stand-in
+	to := make([]int32, len(g.Nodes))
+	for i := range to {
+		to[i] = -1
+	}
+	add := func(node *Node, name string, net int32) int32 {
+		id := int32(len(c.Nodes))
+		c.Nodes = append(c.Nodes, node)
+		c.names = append(c.names, name)
+		c.netof = append(c.netof, net)
+		return id
+	}
+
+	for i, loop := range loops {
+		first := g.Nodes[loop.Nodes[0]]
+		node := &Node{
+			Parent: loop.Netlist,
+			Name:   fmt.Sprintf("loop%d", i),
+			Type:   "LOOP",
+			RpAce:  first.RpAce.Clone(),
+			WpAce:  first.WpAce.Clone(),
+		}
+		members := make([]int32, len(loop.Nodes))
+		for j, id := range loop.Nodes {
+			orTerms(&node.RpAce, g.Nodes[id].RpAce)
+			orTerms(&node.WpAce, g.Nodes[id].WpAce)
+			members[j] = int32(id)
+		}
+
+		id := add(node, node.Fullname(), g.netof[loop.Nodes[0]])
+		for _, m := range members {
+			to[m] = id
+		}
+		c.members = append(c.members, members)
+	}
+	for id, node := range g.Nodes {
+		if to[id] == -1 {
+			to[id] = add(node, g.names[id], g.netof[id])
+		}
+	}
+	c.index()
+
+	// Links within a loop disappear and links into or out of it are merged
+	type link struct{ l, r int32 }
+	seen := make(map[link]bool)
+	var links []link
+	for l := range g.Nodes {
+		for _, r := range g.out.edges(int32(l)) {
+			k := link{to[l], to[r]}
+			if k.l == k.r && to[l] < int32(len(loops)) || seen[k] {
+				continue
+			}
+			seen[k] = true
+			links = append(links, k)
+		}
+	}
+
+	c.out = newCSR(len(c.Nodes), len(links), func(emit func(from, to int32)) {
+		for _, k := range links {
+			emit(k.l, k.r)
+		}
+	})
+	c.in = newCSR(len(c.Nodes), len(links), func(emit func(from, to int32)) {
+		for _, k := range links {
+			emit(k.r, k.l)
+		}
+	})
+
+	return c
+}
+
+// Walk propagates ACE terms down and up the condensed graph and hands the
+// terms of each stand-in to the nodes of its loop.
+func (c *Condensed) Walk() (changed int) {
+	d := c.WalkDn()
+	log.Println("Condensed dn walk changed", d, "nodes")
+
+	u := c.WalkUp()
+	log.Println("Condensed up walk changed", u, "nodes")
+
+	for i, members := range c.members {
+		standin := c.Nodes[i]
+		for _, id := range members {
+			orTerms(&c.full.Nodes[id].RpAce, standin.RpAce)
+			orTerms(&c.full.Nodes[id].WpAce, standin.WpAce)
+		}
+	}
+
+	return d + u
+}
+
+// Members returns the IDs in the full graph of the nodes that node id stands
+// in for, or nil if it is not a stand-in.
+func (c *Condensed) Members(id int) []int {
+	if id >= len(c.members) {
+		return nil
+	}
+	ids := make([]int, len(c.members[id]))
+	for i, m := range c.members[id] {
+		ids[i] = int(m)
+	}
+	return ids
+}
@@ -0,0 +1,73 @@
+package netlist
+
+import (
+	"strings"
+	"testing"
+)
+
+// loopNetlist returns t: a -> q -> g <-> h -> r -> u/i, u/o -> s, q -> m -> q
+// and w <-> u/i, with u/i -> x -> y -> z -> x -> u/o inside t/u. q and r are
+// sequential and ACE, g, h, m, x, y and z are combinational.
+func loopNetlist() *Netlist {
+	f := newFixture("t", "", 8)
+	f.Port("INPUT", "a").Seqn("FF", "q", "r").Prim("NAND", "g", "h").Prim("MUX", "m").
+		Wire("s", "w").Ace("q", 0).Ace("r", 1)
+	f.Sub("u", "").Port("INOUT", "i").Port("OUTPUT", "o").Prim("INV", "x", "y", "z").
+		Link("i", "x", "y", "z", "x").Link("z", "o")
+	f.Link("a", "q", "g", "h", "g").Link("h", "r", "u/i", "w", "u/i").Link("u/o", "s").
+		Link("q", "m", "q")
+	return f.Netlist()
+}
+
+func TestLoops(t *testing.T) {
+	g := NewGraph(loopNetlist())
+
+	var got []string
+	for _, loop := range g.Loops() {
+		got = append(got, strings.Join(names(g, ids32(loop.Nodes)), ",")+"@"+loop.Netlist)
+	}
+	exp := "t/u/x,t/u/y,t/u/z@t/u t/g,t/h@t"
+	if strings.Join(got, " ") != exp {
+		t.Errorf("Expecting loops %q. Got %q", exp, got)
+	}
+}
+
+// Walking the condensed graph has to give every node the same terms as
+// walking the full graph.
+func TestCondense(t *testing.T) {
+	full := loopNetlist()
+	for full.Walk() > 0 {
+	}
+
+	n := loopNetlist()
+	g := n.Graph()
+	loops := g.Loops()
+	c := g.Condense(loops)
+
+	if c.Len() != g.Len()-3 {
+		t.Errorf("Expecting %d nodes in the condensed graph. Got %d", g.Len()-3, c.Len())
+	}
+	if members := c.Members(0); len(members) != 3 || g.Name(members[0]) != "t/u/x" {
+		t.Errorf("Expecting loop0 to stand in for t/u. Got %v", members)
+	}
+	if c.Members(c.Len()-1) != nil {
+		t.Errorf("Expecting the last node not to be a stand-in")
+	}
+
+	for c.Walk() > 0 {
+	}
+
+	for id, name := range full.Graph().names {
+		want, got := full.Graph().Nodes[id], g.Lookup(name)
+		if want.RpAce.String() != got.RpAce.String() || want.WpAce.String() != got.WpAce.String() {
+			t.Errorf("Expecting %v. Got %v", want, got)
+		}
+	}
+}
+
+func ids32(ids []int) (s []int32) {
+	for _, id := range ids {
+		s = append(s, int32(id))
+	}
+	return
+}
@@ -0,0 +1,157 @@
+package netlist
+
+import (
+	"log"
+	"sort"
+)
+
+// SeqGraph is the register-transfer view of a graph: only its sequential and
+// ACE nodes, with an edge wherever combinational logic, wires and ports join
+// two of them. Each edge carries the logic depth of the path. A SeqGraph is a
+// Graph, so walks, cones and explanations work on it as they do on the full
+// graph, only much faster.
+//
+// The nodes are those of the full graph, so a walk on a SeqGraph updates them
+// in place, but it leaves the combinational nodes in between untouched.
+// Graph.Fill of the full graph fills them in.
+type SeqGraph struct {
+	*Graph
+
+	depth []int32 // Logic depth of each edge, in the order of out
+	orig  []int32 // ID in the full graph of each node
+}
+
+// NewSeqGraph collapses the combinational logic of graph g.
+func NewSeqGraph(g *Graph) *SeqGraph {
+	s := &SeqGraph{
+		Graph: &Graph{
+			nets: g.nets,
+		},
+	}
+
+	keep := func(id int32) bool {
+		node := g.Nodes[id]
+		return node.IsSeqn || node.IsAce
+	}
+
+	seqid := make(map[int32]int32)
+	for id, node := range g.Nodes {
+		if !keep(int32(id)) {
+			continue
+		}
+		seqid[int32(id)] = int32(len(s.Nodes))
+		s.Nodes = append(s.Nodes, node)
+		s.names = append(s.names, g.names[id])
+		s.netof = append(s.netof, g.netof[id])
+		s.orig = append(s.orig, int32(id))
+	}
+	s.index()
+
+	type edge struct{ from, to, depth int32 }
+	var edges []edge
+	for from, id := range s.orig {
+		for _, r := range g.reach(id, keep) {
+			edges = append(edges, edge{int32(from), seqid[r.id], r.depth})
+		}
+	}
+
+	// Edges are listed by their from node, which is the order newCSR lays
+	// them out in, so depths line up with out.
+	s.out = newCSR(len(s.Nodes), len(edges), func(emit func(from, to int32)) {
+		for _, e := range edges {
+			emit(e.from, e.to)
+		}
+	})
+	s.in = newCSR(len(s.Nodes), len(edges), func(emit func(from, to int32)) {
+		for _, e := range edges {
+			emit(e.to, e.from)
+		}
+	})
+	s.depth = make([]int32, len(edges))
+	for i, e := range edges {
+		s.depth[i] = e.depth
+	}
+
+	return s
+}
+
+type reached struct {
+	id, depth int32
+}
+
+// reach returns the nodes to keep that node start drives through nodes not
+// kept, other than clock, reset and stopping nodes, each with the fewest
+// combinational prims on a path to it, in order of ID. It searches breadth
+// first, one prim further away at a time.
+func (g *Graph) reach(start int32, keep func(int32) bool) (found []reached) {
+	done := make(map[int32]bool)
+	loop := false // Whether start drives itself
+
+	level := []int32{start}
+	for depth := int32(0); len(level) > 0; depth++ {
+		var next []int32
+
+		// Nodes reached without going through another prim join this level
+		for i := 0; i < len(level); i++ {
+			u := level[i]
+			if done[u] {
+				continue
+			}
+			done[u] = true
+
+			if u != start && keep(u) {
+				found = append(found, reached{u, depth})
+				continue
+			}
+
+			for _, v := range g.out.edges(u) {
+				switch {
+				case v == start:
+					if !loop {
+						loop = true
+						found = append(found, reached{start, depth})
+					}
+				case done[v] || g.Nodes[v].IsClock || g.Nodes[v].IsStop:
+				case !keep(v) && g.Nodes[v].IsPrim:
+					next = append(next, v)
+				default:
+					level = append(level, v)
+				}
+			}
+		}
+
+		level = next
+	}
+
+	sort.Slice(found, func(i, j int) bool { return found[i].id < found[j].id })
+	return
+}
+
+// Walk propagates ACE terms down and up between sequential and ACE nodes, as
+// Netlist.Walk does between all nodes.
+func (s *SeqGraph) Walk() (changed int) {
+	d := s.WalkDn()
+	log.Println("Sequential dn walk changed", d, "nodes")
+
+	u := s.WalkUp()
+	log.Println("Sequential up walk changed", u, "nodes")
+
+	return d + u
+}
+
+// Depth returns the number of combinational prims on the shallowest path from
+// node from to node to, or -1 if there is no edge between them.
+func (s *SeqGraph) Depth(from, to int) int {
+	start := s.out.start[from]
+	for i, v := range s.out.edges(int32(from)) {
+		if int(v) == to {
+			return int(s.depth[int(start)+i])
+		}
+	}
+	return -1
+}
+
+// Orig returns the ID in the full graph of node id.
+func (s *SeqGraph) Orig(id int) int {
+	return int(s.orig[id])
+}
@@ -0,0 +1,79 @@
+package netlist
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"testing"
+)
+
+// seqNetlist returns t: q1 -> g -> h -> q2, q2 -> m -> q2, q2 -> w -> u/i and
+// u/o -> q3, with u/i -> u/x -> u/o inside t/u. The q are sequential, q1 is
+// ACE, and g, h, m and u/x are combinational.
+func seqNetlist() *Netlist {
+	f := newFixture("t", "", 8)
+	f.Seqn("FF", "q1", "q2", "q3").Prim("AND", "g").Prim("BUF", "h").Prim("MUX", "m").
+		Wire("w").Ace("q1", 0)
+	f.Sub("u", "").Port("INPUT", "i").Port("OUTPUT", "o").Prim("BUF", "x").
+		Link("i", "x", "o")
+	f.Link("q1", "g", "h", "q2", "m", "q2").Link("q2", "w", "u/i").Link("u/o", "q3")
+	return f.Netlist()
+}
+
+func TestSeqGraph(t *testing.T) {
+	g := NewGraph(seqNetlist())
+	s := NewSeqGraph(g)
+
+	if s.Len() != 3 {
+		t.Fatalf("Expecting 3 nodes. Got %d", s.Len())
+	}
+
+	var edges []string
+	for from := 0; from < s.Len(); from++ {
+		for _, to := range s.Out(from) {
+			edges = append(edges, fmt.Sprintf("%s>%s:%d", s.Name(from), s.Name(int(to)),
+				s.Depth(from, int(to))))
+		}
+	}
+	sort.Strings(edges)
+	exp := "t/q1>t/q2:2 t/q2>t/q2:1 t/q2>t/q3:1"
+	if got := strings.Join(edges, " "); got != exp {
+		t.Errorf("Expecting edges %q. Got %q", exp, got)
+	}
+
+	q1, _ := s.ID("t/q1")
+	q3, _ := s.ID("t/q3")
+	if d := s.Depth(q1, q3); d != -1 {
+		t.Errorf("Expecting no edge from t/q1 to t/q3. Got depth %d", d)
+	}
+	if s.Nodes[q3] != g.Nodes[s.Orig(q3)] {
+		t.Errorf("Expecting t/q3 to be node %d of the full graph", s.Orig(q3))
+	}
+}
+
+// Walking the sequential graph has to give the sequentials the same terms as
+// walking the full graph, and filling in after it the rest of the nodes.
+func TestSeqGraphWalk(t *testing.T) {
+	full := seqNetlist()
+	full.Walk()
+
+	n := seqNetlist()
+	s := NewSeqGraph(n.Graph())
+	s.WalkDn()
+	s.WalkUp()
+
+	same := func(names []string) {
+		for _, name := range names {
+			exp, got := full.LocateNode(name), n.LocateNode(name)
+			if exp.RpAce.String() != got.RpAce.String() || exp.WpAce.String() != got.WpAce.String() {
+				t.Errorf("Expecting %v. Got %v", exp, got)
+			}
+		}
+	}
+	same([]string{"t/q1", "t/q2", "t/q3"})
+
+	if n.Graph().Fill() == 0 {
+		t.Errorf("Expecting the combinational nodes to be filled in")
+	}
+	same(n.Graph().names)
+}
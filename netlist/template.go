@@ -0,0 +1,367 @@
+package netlist
+
+import (
+	"log"
+	"regexp"
+	"sart/ace"
+	"sart/bitfield"
+	"strings"
+	"sync"
+
+	"gopkg.in/mgo.v2/bson"
+)
+
+// template is a module's netlist as saved by New. Names are relative to the
+// module so that the template can be instantiated under any instance path.
+type template struct {
+	nodes   []*Node     // Parent of each node is the module name
+	links   [][2]string // Left and right node names
+	subnets [][2]string // Instance name and module of each subnet
+}
+
+// selector is an ACE struct selector compiled for matching nodes in memory. A
+// selector with inst set matches instance subtrees rather than nodes.
+type selector struct {
+	module, name, inst *regexp.Regexp
+}
+
+func (s selector) match(node *Node) bool {
+	if s.inst != nil {
+		return false
+	}
+	if s.module != nil && !s.module.MatchString(node.Parent) {
+		return false
+	}
+	if s.name != nil && !s.name.MatchString(node.Name) {
+		return false
+	}
+	return true
+}
+
+// copyTo returns a copy of node n, as found in a template, for the instance
+// with path parent.
+func (n *Node) copyTo(parent string) *Node {
+	c := *n
+	c.Parent = parent
+	c.RpAce = n.RpAce.Clone()
+	c.WpAce = n.WpAce.Clone()
+	return &c
+}
+
+// template returns the template of module mname, reading it from mongo the
+// first time it is needed. Templates of different modules are read at the same
+// time; callers wanting one that is being read wait for it.
+func (st *Store) template(mname string) *template {
+	st.tmu.Lock()
+	if t, found := st.templates[mname]; found {
+		st.tmu.Unlock()
+		return t
+	}
+	once, found := st.reads[mname]
+	if !found {
+		once = new(sync.Once)
+		st.reads[mname] = once
+	}
+	st.tmu.Unlock()
+
+	once.Do(func() {
+		t := st.readTemplate(mname)
+
+		st.tmu.Lock()
+		st.templates[mname] = t
+		delete(st.reads, mname)
+		st.tmu.Unlock()
+	})
+
+	st.tmu.Lock()
+	defer st.tmu.Unlock()
+	return st.templates[mname]
+}
+
+// readTemplate reads the template of module mname from mongo.
+func (st *Store) readTemplate(mname string) *template {
+	t := &template{}
+	prefix := mname + "/"
+
+	ni := st.session.DB("").C(st.nodecoll).Find(bson.M{"module": mname}).Iter()
+	for {
+		node := &Node{}
+		if !ni.Next(node) {
+			break
+		}
+		t.nodes = append(t.nodes, node)
+	}
+	if err := ni.Close(); err != nil {
+		log.Fatalf("Unable to load nodes of %q: %v", mname, err)
+	}
+
+	var link struct {
+		Lfullname, Rfullname string
+	}
+	li := st.session.DB("").C(st.linkcoll).Find(bson.M{"module": mname}).Iter()
+	for li.Next(&link) {
+		t.links = append(t.links, [2]string{
+			strings.TrimPrefix(link.Lfullname, prefix),
+			strings.TrimPrefix(link.Rfullname, prefix),
+		})
+	}
+	if err := li.Close(); err != nil {
+		log.Fatalf("Unable to load links of %q: %v", mname, err)
+	}
+
+	var subnet struct {
+		Name, Type string
+	}
+	si := st.session.DB("").C(st.snetcoll).Find(bson.M{"module": mname}).Iter()
+	for si.Next(&subnet) {
+		t.subnets = append(t.subnets, [2]string{
+			strings.TrimPrefix(subnet.Name, prefix),
+			subnet.Type,
+		})
+	}
+	if err := si.Close(); err != nil {
+		log.Fatalf("Unable to load subnets of %q: %v", mname, err)
+	}
+
+	if len(t.nodes) == 0 {
+		log.Fatalf("No netlist for module %q in cache %q", mname, st.Cache)
+	}
+
+	return t
+}
+
+// Load returns the netlist of module name, instantiated as the top of the
+// design. Only the top level is expanded. Subnets are expanded on demand by
+// Expand, which the walks and Stats do as they descend.
+func (st *Store) Load(name string) *Netlist {
+	st.checkState()
+
+	n := st.instantiate(name, name)
+	n.Expand()
+	return n
+}
+
+// Template returns the template netlist of module mname as saved by New:
+// expanded one level, with node names prefixed by the module name and without
+// any ACE state.
+func (st *Store) Template(mname string) *Netlist {
+	n := st.instantiate(mname, mname)
+	n.Expand()
+	return n
+}
+
+// instantiate returns an unexpanded instance of module mname with instance
+// path name.
+func (st *Store) instantiate(name, mname string) *Netlist {
+	n := NewNetlist(name)
+	n.Type = mname
+	n.store = st
+	n.state = st.loadState(name)
+
+	for _, node := range st.template(mname).nodes {
+		if node.IsPort {
+			n.AddNode(st.node(node, name, n.state))
+		}
+	}
+
+	st.markInst(n)
+	return n
+}
+
+// markInst marks netlist n ACE if an ACE struct selects its instance path,
+// along with its ports, which stand for the whole subtree in walks. As with
+// nodes, the last ACE struct to select it wins.
+func (st *Store) markInst(n *Netlist) {
+	last := -1
+	for i, sel := range st.selectors {
+		if sel.inst != nil && sel.inst.MatchString(n.Name) {
+			last = i
+		}
+	}
+	if last == -1 {
+		return
+	}
+
+	n.IsAce = true
+	for _, node := range n.Nodes {
+		node.IsAce = true
+		node.RpAce = bitfield.New(len(st.selectors))
+		node.WpAce = bitfield.New(len(st.selectors))
+		node.RpAce.Set(last)
+		node.WpAce.Set(last)
+		node.Touched = true
+		st.marked++
+	}
+}
+
+// node returns the node of the instance with path parent that corresponds to
+// template node tn, with its ACE marking applied or, when not marking, its
+// saved ACE state from state.
+func (st *Store) node(tn *Node, parent string, state map[string]*Node) *Node {
+	node := tn.copyTo(parent)
+
+	if len(st.selectors) == 0 {
+		if saved, found := state[node.Name]; found {
+			node.IsAce = saved.IsAce
+			node.RpAce = saved.RpAce
+			node.WpAce = saved.WpAce
+			node.Touched = saved.Touched
+		}
+		return node
+	}
+
+	// Nodes start a marking run without terms. Those that are selected get
+	// bitfields sized to the ACE structs in use. As with the mongo updates this
+	// replaces, a node selected by more than one ACE struct ends up marked with
+	// the last one.
+	node.RpAce, node.WpAce = nil, nil
+	last := -1
+	for i, sel := range st.selectors {
+		if sel.match(node) {
+			last = i
+		}
+	}
+	if last != -1 {
+		node.IsAce = true
+		node.RpAce = bitfield.New(len(st.selectors))
+		node.WpAce = bitfield.New(len(st.selectors))
+		node.RpAce.Set(last)
+		node.WpAce.Set(last)
+		node.Touched = true
+		st.marked++
+	}
+
+	return node
+}
+
+// Expand fills in the nodes, links and subnets of a loaded netlist from the
+// template of its module. Subnets are added unexpanded. Expanding a netlist
+// that is already expanded, that was not loaded from a store or that was
+// marked ACE as a whole does nothing; the ports of an ACE subtree are all of
+// it that walks need.
+func (n *Netlist) Expand() {
+	if n.store == nil || n.expanded || n.IsAce {
+		return
+	}
+	n.expanded = true
+
+	st := n.store
+	t := st.template(n.Type)
+	state := n.state
+	n.state = nil
+
+	// Port nodes are already in place
+	for _, node := range t.nodes {
+		if !node.IsPort {
+			n.AddNode(st.node(node, n.Name, state))
+		}
+	}
+
+	for _, s := range t.subnets {
+		fullname := n.Name + "/" + s[0]
+		n.Subnets[fullname] = st.instantiate(fullname, s[1])
+	}
+
+	for _, l := range t.links {
+		lfullname := n.Name + "/" + l[0]
+		rfullname := n.Name + "/" + l[1]
+		lnode := n.LocateNode(lfullname)
+		rnode := n.LocateNode(rfullname)
+
+		if lnode == nil {
+			log.Fatalf("Could not locate lnode %q in netlist %q", lfullname, n.Name)
+		}
+
+		if rnode == nil {
+			log.Fatalf("Could not locate rnode %q in netlist %q", rfullname, n.Name)
+		}
+
+		n.Connect(lnode, rnode)
+	}
+}
+
+// MarkAceNodes clears the ACE state saved by earlier walks and arranges for
+// nodes selected by acestructs to be marked ACE as they are loaded. It returns
+// the number of nodes whose state was cleared.
+func (st *Store) MarkAceNodes(acestructs []ace.AceStruct) (reset int) {
+	ci, err := st.session.DB("").C(st.statecoll).RemoveAll(bson.M{"touched": true})
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	st.selectors = selectors(acestructs)
+
+	return ci.Removed
+}
+
+// selectors compiles the selectors of acestructs.
+func selectors(acestructs []ace.AceStruct) (sels []selector) {
+	compile := func(a ace.AceStruct, expr string) *regexp.Regexp {
+		if expr == "" {
+			return nil
+		}
+		re, err := regexp.Compile(expr)
+		if err != nil {
+			log.Fatalf("ACE struct %v: %v", a, err)
+		}
+		return re
+	}
+
+	for _, a := range acestructs {
+		if a.Selector.Inst != "" && (a.Selector.Module != "" || a.Selector.Name != "") {
+			log.Fatalf("ACE struct %v: inst selects a subtree and cannot be used with module or name", a)
+		}
+		sels = append(sels, selector{
+			module: compile(a, a.Selector.Module),
+			name:   compile(a, a.Selector.Name),
+			inst:   compile(a, a.Selector.Inst),
+		})
+	}
+	return
+}
+
+// Marked returns the number of loaded nodes that were marked ACE.
+func (st *Store) Marked() int {
+	return st.marked
+}
+
+// checkState looks for ACE state saved by the last walk. There is none to
+// read back when nodes are about to be marked, as MarkAceNodes has cleared it.
+func (st *Store) checkState() {
+	st.hasState = false
+	if len(st.selectors) > 0 {
+		return
+	}
+
+	count, err := st.session.DB("").C(st.statecoll).Find(nil).Limit(1).Count()
+	if err != nil {
+		log.Fatal(err)
+	}
+	st.hasState = count > 0
+}
+
+// loadState reads the ACE state saved by the last walk for the nodes of the
+// instance with path parent, by node name. Only the instances that are loaded
+// are read, through the module and name index of the state collection.
+func (st *Store) loadState(parent string) map[string]*Node {
+	if !st.hasState {
+		return nil
+	}
+
+	var state map[string]*Node
+	iter := st.session.DB("").C(st.statecoll).Find(bson.M{"module": parent}).Iter()
+	for {
+		node := &Node{}
+		if !iter.Next(node) {
+			break
+		}
+		if state == nil {
+			state = make(map[string]*Node)
+		}
+		state[node.Name] = node
+	}
+	if err := iter.Close(); err != nil {
+		log.Fatal(err)
+	}
+	return state
+}
@@ -0,0 +1,271 @@
+package netlist
+
+import (
+	"context"
+	"sart/ace"
+	"sart/bitfield"
+	"strings"
+	"testing"
+	"time"
+
+	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// saved returns the template of netlist n as Save writes it out and template
+// reads it back.
+func saved(n *Netlist) *template {
+	t := &template{}
+	prefix := n.Name + "/"
+	for _, node := range n.Nodes {
+		t.nodes = append(t.nodes, node)
+	}
+	for lfullname, rnodes := range n.Links {
+		for _, rnode := range rnodes {
+			t.links = append(t.links, [2]string{
+				strings.TrimPrefix(lfullname, prefix),
+				strings.TrimPrefix(rnode.Fullname(), prefix),
+			})
+		}
+	}
+	for _, subnet := range n.Subnets {
+		t.subnets = append(t.subnets, [2]string{strings.TrimPrefix(subnet.Name, prefix), subnet.Type})
+	}
+	return t
+}
+
+// templateStore returns a store holding the templates of top and rf, as New
+// would have saved them, and marking nodes with acestructs.
+//
+//	top/in -> top/p -> top/a -> top/rf/i -> top/rf/x -> top/rf/o -> top/z -> top/s -> top/out
+func templateStore(acestructs []ace.AceStruct) *Store {
+	top := newFixture("top", "top", 1)
+	top.Port("INPUT", "in").Port("OUTPUT", "out").Prim("BUF", "p", "s").Wire("a", "z")
+	top.Sub("rf", "rf").Port("INPUT", "i").Port("OUTPUT", "o")
+	top.Link("in", "p", "a", "rf/i").Link("rf/o", "z", "s", "out")
+
+	rf := newFixture("rf", "rf", 1)
+	rf.Port("INPUT", "i").Port("OUTPUT", "o").Prim("BUF", "x").Link("i", "x", "o")
+
+	return &Store{
+		templates: map[string]*template{
+			"top": saved(top.Netlist()),
+			"rf":  saved(rf.Netlist()),
+		},
+		selectors: selectors(acestructs),
+	}
+}
+
+func TestExpand(t *testing.T) {
+	st := templateStore(nil)
+	n := st.instantiate("top", "top")
+
+	if len(n.Nodes) != 2 || len(n.Subnets) != 0 {
+		t.Fatalf("Expecting only the ports of top before expanding. Got %v", n.Nodes)
+	}
+
+	n.Expand()
+	n.Expand()
+	if len(n.Nodes) != 6 {
+		t.Errorf("Expecting 6 nodes in top. Got %d", len(n.Nodes))
+	}
+	if rnodes := n.Links["top/a"]; len(rnodes) != 1 || rnodes[0].Fullname() != "top/rf/i" {
+		t.Errorf("Expecting top/a to drive top/rf/i. Got %v", rnodes)
+	}
+
+	rf := n.Subnets["top/rf"]
+	if rf == nil || rf.Type != "rf" || len(rf.Nodes) != 2 {
+		t.Fatalf("Expecting unexpanded subnet top/rf of rf. Got %v", rf)
+	}
+	rf.Expand()
+	if x := rf.Nodes["top/rf/x"]; x == nil || x.Parent != "top/rf" {
+		t.Errorf("Expecting top/rf/x once top/rf is expanded. Got %v", x)
+	}
+}
+
+func TestSavedState(t *testing.T) {
+	st := templateStore(nil)
+	state := func(name string) *Node {
+		node := NewNode("top", name, "")
+		node.RpAce = bitfield.New(1)
+		node.RpAce.Set(0)
+		node.Touched = true
+		return node
+	}
+
+	var tn *Node
+	for _, node := range st.template("top").nodes {
+		if node.Name == "in" {
+			tn = node
+		}
+	}
+	in := st.node(tn, "top", map[string]*Node{"in": state("in")})
+	if !in.RpAce.IsSet(0) || !in.Touched {
+		t.Errorf("Expecting port top/in to carry its saved state. Got %v", in)
+	}
+
+	n := st.instantiate("top", "top")
+	n.state = map[string]*Node{"a": state("a")}
+	n.Expand()
+
+	if a := n.Nodes["top/a"]; !a.RpAce.IsSet(0) || !a.Touched {
+		t.Errorf("Expecting top/a to carry its saved state. Got %v", a)
+	}
+	if z := n.Nodes["top/z"]; z.Touched {
+		t.Errorf("Expecting top/z to have no saved state. Got %v", z)
+	}
+	if n.state != nil {
+		t.Errorf("Expecting saved state to be dropped once expanded")
+	}
+}
+
+func TestMarking(t *testing.T) {
+	st := templateStore([]ace.AceStruct{
+		ace.New("^top$", "^a$", 1, 1),
+		ace.New("", "^x$", 1, 1),
+	})
+	n := st.instantiate("top", "top")
+	n.state = map[string]*Node{"p": {Name: "p", IsAce: true, Touched: true}}
+	n.Expand()
+	n.Subnets["top/rf"].Expand()
+	g := n.Graph()
+
+	tests := []struct {
+		name string
+		term int
+	}{
+		{"top/a", 0},
+		{"top/rf/x", 1},
+	}
+	for _, test := range tests {
+		node := g.Lookup(test.name)
+		if !node.IsAce || !node.RpAce.IsSet(test.term) || !node.WpAce.IsSet(test.term) {
+			t.Errorf("Expecting %q to be ACE with term %d. Got %v", test.name, test.term, node)
+		}
+	}
+	if p := g.Lookup("top/p"); p.IsAce {
+		t.Errorf("Expecting saved state of top/p to give way to marking. Got %v", p)
+	}
+	if st.Marked() != 2 {
+		t.Errorf("Expecting 2 nodes marked. Got %d", st.Marked())
+	}
+}
+
+func TestMarkAceNodes(t *testing.T) {
+	s, err := mgo.DialWithTimeout("localhost/test", time.Second)
+	if err != nil {
+		t.Skip("no mongo on localhost:", err)
+	}
+	defer s.Close()
+
+	const cname = "test_markacenodes"
+	st := NewStore(context.Background(), s, nil, cname, true)
+	defer st.Close()
+	defer func() {
+		for _, coll := range Collections(cname) {
+			st.dropCollection(coll)
+		}
+	}()
+
+	c := s.DB("").C(st.statecoll)
+	for i, touched := range []bool{true, true, false} {
+		err = c.Insert(bson.M{"module": "top", "name": string(rune('a' + i)), "touched": touched})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if reset := st.MarkAceNodes([]ace.AceStruct{ace.New("", "^a$", 1, 1)}); reset != 2 {
+		t.Errorf("Expecting 2 nodes reset. Got %d", reset)
+	}
+	if n, _ := c.Count(); n != 1 {
+		t.Errorf("Expecting 1 untouched node left. Got %d", n)
+	}
+	if len(st.selectors) != 1 {
+		t.Errorf("Expecting 1 selector. Got %d", len(st.selectors))
+	}
+
+	st.checkState()
+	if st.hasState {
+		t.Errorf("Expecting no saved state to be read back while marking")
+	}
+}
+
+func TestMarkInst(t *testing.T) {
+	st := templateStore([]ace.AceStruct{
+		ace.New("", "^nothing$", 1, 1),
+		ace.NewInst("^top/rf$", 0.5, 0.5),
+	})
+	n := st.instantiate("top", "top")
+	n.Expand()
+	g := n.Graph()
+
+	rf := n.Subnets["top/rf"]
+	if !rf.IsAce || n.IsAce {
+		t.Errorf("Expecting only top/rf to be marked ACE")
+	}
+	if g.Lookup("top/rf/x") != nil {
+		t.Errorf("Expecting the inside of ACE subtree top/rf not to be loaded")
+	}
+	for _, name := range []string{"top/rf/i", "top/rf/o"} {
+		node := g.Lookup(name)
+		if node == nil || !node.IsAce || !node.RpAce.IsSet(1) || !node.WpAce.IsSet(1) {
+			t.Errorf("Expecting port %q to be ACE with term 1. Got %v", name, node)
+		}
+	}
+	if st.Marked() != 2 {
+		t.Errorf("Expecting 2 nodes marked. Got %d", st.Marked())
+	}
+
+	g.WalkDn()
+	g.WalkUp()
+
+	tests := []struct {
+		name   string
+		rp, wp bool
+	}{
+		{"top/in", false, true},
+		{"top/a", false, true},
+		{"top/z", true, false},
+		{"top/out", true, false},
+	}
+	for _, test := range tests {
+		node := g.Lookup(test.name)
+		if node.RpAce.IsSet(1) != test.rp || node.WpAce.IsSet(1) != test.wp {
+			t.Errorf("%s: expecting rp %v wp %v. Got %v", test.name, test.rp, test.wp, node)
+		}
+	}
+}
+
+// Overlapping selectors mark each node once, with the last of them.
+func TestMarkInstOverlap(t *testing.T) {
+	st := templateStore([]ace.AceStruct{
+		ace.NewInst("^top/rf$", 1, 1),
+		ace.NewInst("^top/r", 0.5, 0.5),
+		ace.New("", "^a$", 1, 1),
+		ace.New("^top$", "^a$", 1, 1),
+	})
+	n := st.instantiate("top", "top")
+	n.Expand()
+	g := n.Graph()
+
+	if i := g.Lookup("top/rf/i"); !i.RpAce.IsSet(1) || i.RpAce.IsSet(0) {
+		t.Errorf("Expecting top/rf/i to be marked with term 1 only. Got %v", i)
+	}
+	if a := g.Lookup("top/a"); !a.RpAce.IsSet(3) || a.RpAce.IsSet(2) {
+		t.Errorf("Expecting top/a to be marked with term 3 only. Got %v", a)
+	}
+	if st.Marked() != 3 {
+		t.Errorf("Expecting 3 nodes marked. Got %d", st.Marked())
+	}
+}
+
+func TestMarkInstUnselected(t *testing.T) {
+	st := templateStore([]ace.AceStruct{ace.NewInst("^top/other$", 1, 1)})
+	n := st.instantiate("top", "top")
+	n.Expand()
+
+	if g := n.Graph(); g.Lookup("top/rf/x") == nil || g.Lookup("top/rf/i").IsAce {
+		t.Errorf("Expecting top/rf to be loaded whole and not marked")
+	}
+}
@@ -17,15 +17,18 @@ type parser struct {
     l      *lexer
     token  Item
     tokens chan Item
+    store  *rtl.Store
 }
 
-func New(name string, r io.Reader) { 
+// New parses the verilog netlist read from r and saves every module found in
+// it to store st.
+func New(st *rtl.Store, name string, r io.Reader) {
     bytes, err := ioutil.ReadAll(r)
     if err != nil {
         log.Fatal(err)
     }
 
-    parser := &parser{}
+    parser := &parser{store: st}
     parser.l, parser.tokens = NewLexer(name, string(bytes))
     
     // Load first token
@@ -108,7 +111,7 @@ func (p *parser) module_decl() {
     }
 
     p.expect(EndModule)
-    m.Save()
+    p.store.Save(m)
 }
 
 func (p *parser) list_of_ports(m *rtl.Module) {
@@ -17,9 +17,12 @@ type parser struct {
 	l      *lexer
 	token  Item
 	tokens chan Item
+	store  *rtl.Store
 }
 
-func New(name string, r io.Reader) {
+// New parses the SPICE netlist read from r and saves every subckt found in it
+// as a module to store st.
+func New(st *rtl.Store, name string, r io.Reader) {
 	bytes, err := ioutil.ReadAll(r)
 	if err != nil {
 		log.Fatal(err)
@@ -27,7 +30,7 @@ func New(name string, r io.Reader) {
 
 	log.Println(len(bytes))
 
-	parser := &parser{}
+	parser := &parser{store: st}
 	parser.l, parser.tokens = NewLexer(name, string(bytes))
 
 	// Load first token
@@ -174,7 +177,7 @@ func (p *parser) subckt() {
 	p.accept(Id)
 
 	log.Printf("line: %d subckt: %s", lno, m.Name)
-	m.Save()
+	p.store.Save(m)
 }
 
 func (p *parser) portspec(m *rtl.Module) {
@@ -1,6 +1,7 @@
 package parsesp
 
 import (
+	"context"
 	"io/ioutil"
 	"log"
 	"os"
@@ -11,6 +12,8 @@ import (
 	mgo "gopkg.in/mgo.v2"
 )
 
+var store *rtl.Store
+
 func init() {
 	log.SetFlags(0)
 	log.SetOutput(os.Stdout)
@@ -21,18 +24,18 @@ func init() {
 		log.Fatal(err)
 	}
 
-	rtl.InitMgo(session, "test", true)
+	store = rtl.NewStore(context.Background(), session, "test", true)
 }
 
 func Test1(t *testing.T) {
-	New("test", strings.NewReader(
+	New(store, "test", strings.NewReader(
 		// A very basic subckt
 		`.SUBCKT test1 port
 .ENDS`))
 }
 
 func Test1a(t *testing.T) {
-	New("test", strings.NewReader(
+	New(store, "test", strings.NewReader(
 		// A very basic subckt with comments
 		`* comment
 *----
@@ -43,7 +46,7 @@ func Test1a(t *testing.T) {
 }
 
 func Test2(t *testing.T) {
-	New("test", strings.NewReader(
+	New(store, "test", strings.NewReader(
 		// Basic subckt with multiple ports and one instance
 		`.SUBCKT test2 port1 port2
 Minst1 a b c
@@ -51,7 +54,7 @@ Minst1 a b c
 }
 
 func Test2a(t *testing.T) {
-	New("test", strings.NewReader(
+	New(store, "test", strings.NewReader(
 		// Basic subckt with multiple ports separated by a line break
 		`
 .SUBCKT test2a port1 port2
@@ -61,7 +64,7 @@ Minst1 a b c
 }
 
 func Test2b(t *testing.T) {
-	New("test", strings.NewReader(
+	New(store, "test", strings.NewReader(
 		// Basic subckt with multiple ports separated by a line break immediately after the
 		// module name, and with multiple line breaks
 		`
@@ -73,7 +76,7 @@ Minst1 a b c moduletype
 }
 
 func Test3(t *testing.T) {
-	New("test", strings.NewReader(
+	New(store, "test", strings.NewReader(
 		// Basic subckt with multiple instances
 		`
 .SUBCKT test3 port1 port2
@@ -83,7 +86,7 @@ Minst2 a b c d
 }
 
 func Test4(t *testing.T) {
-	New("test", strings.NewReader(
+	New(store, "test", strings.NewReader(
 		// Multiple basic subckts
 		`
 .SUBCKT test4 port1 port2
@@ -100,7 +103,7 @@ Minst2 a b c d
 }
 
 func Test5(t *testing.T) {
-	New("test", strings.NewReader(
+	New(store, "test", strings.NewReader(
 		// subckt with empty port specifications
 		`
 .SUBCKT test5 port1 port2
@@ -114,7 +117,7 @@ Minst2 a b c d
 }
 
 func Test5a(t *testing.T) {
-	New("test", strings.NewReader(
+	New(store, "test", strings.NewReader(
 		// subckt with valid port specifiers and line breaks
 		`
 .SUBCKT test5a port1 port2
@@ -131,7 +134,7 @@ Minst2 a b c d
 }
 
 func Test6(t *testing.T) {
-	New("test", strings.NewReader(
+	New(store, "test", strings.NewReader(
 		// subckt with line breaks in instantiations
 		`
 .SUBCKT test6 port1 port2
@@ -143,7 +146,7 @@ Minst2 a b c d
 }
 
 func Test7(t *testing.T) {
-	New("test", strings.NewReader(
+	New(store, "test", strings.NewReader(
 		// subckt with properties in instantiations
 		`
 .SUBCKT test7 port1 port2
@@ -154,7 +157,7 @@ Minst2 a b c d
 }
 
 func Test7a(t *testing.T) {
-	New("test", strings.NewReader(
+	New(store, "test", strings.NewReader(
 		// subckt with properties in instantiations and line breaks
 		`
 .SUBCKT test7a port1 port2
@@ -166,7 +169,7 @@ Minst2 a b c d prop3=42
 }
 
 func Test8(t *testing.T) {
-	New("test", strings.NewReader(
+	New(store, "test", strings.NewReader(
 		// other rare directives
 		`
 .PARAM param="1"
@@ -180,7 +183,7 @@ Minst1 a b c
 }
 
 func Test9(t *testing.T) {
-	New("test", strings.NewReader(
+	New(store, "test", strings.NewReader(
 		// other rare directives
 		`
 .PARAM param="1"
@@ -0,0 +1,146 @@
+package rtl
+
+import (
+    "container/list"
+    "fmt"
+    "sync"
+)
+
+// ModuleLoader is implemented by anything that can hand out module
+// definitions: a Store, which fetches them from mongo every time, and a
+// ModuleCache, which keeps the ones used most recently.
+type ModuleLoader interface {
+    LoadModule(name string) *Module
+}
+
+// ModuleCache keeps recently loaded module definitions in memory, up to a cap
+// on their estimated size, so that a module instantiated many times is only
+// fetched once. Modules are shared between all users of the cache and must not
+// be modified. A ModuleCache is safe for concurrent use.
+type ModuleCache struct {
+    loader ModuleLoader
+    limit  int // Bytes
+
+    mu      sync.Mutex
+    lru     *list.List // Front is most recently used
+    entries map[string]*list.Element
+    size    int
+    stats   CacheStats
+}
+
+type cacheEntry struct {
+    m    *Module
+    size int
+}
+
+// NewModuleCache returns a cache of the modules handed out by loader, holding
+// up to limit bytes of them.
+func NewModuleCache(loader ModuleLoader, limit int) *ModuleCache {
+    c := &ModuleCache{
+        loader : loader,
+        limit  : limit,
+        lru    : list.New(),
+        entries: make(map[string]*list.Element),
+    }
+    return c
+}
+
+// LoadModule returns the definition of module name, from memory if it is
+// there and from the underlying loader otherwise.
+func (c *ModuleCache) LoadModule(name string) *Module {
+    c.mu.Lock()
+    if e, found := c.entries[name]; found {
+        c.lru.MoveToFront(e)
+        c.stats.Hits++
+        c.mu.Unlock()
+        return e.Value.(*cacheEntry).m
+    }
+    c.stats.Misses++
+    c.mu.Unlock()
+
+    // Load without holding the lock so that misses can be served in parallel.
+    // Two users missing on the same module both load it; the second copy
+    // simply replaces the first.
+    m := c.loader.LoadModule(name)
+    size := m.size()
+
+    c.mu.Lock()
+    defer c.mu.Unlock()
+
+    if e, found := c.entries[name]; found {
+        c.remove(e)
+    }
+
+    // A module larger than the whole cache is handed out but not kept
+    if size > c.limit {
+        return m
+    }
+
+    c.entries[name] = c.lru.PushFront(&cacheEntry{m, size})
+    c.size += size
+
+    for c.size > c.limit {
+        c.remove(c.lru.Back())
+        c.stats.Evictions++
+    }
+
+    return m
+}
+
+func (c *ModuleCache) remove(e *list.Element) {
+    entry := c.lru.Remove(e).(*cacheEntry)
+    delete(c.entries, entry.m.Name)
+    c.size -= entry.size
+}
+
+// Stats returns the hit and miss counts of the cache so far and what it holds.
+func (c *ModuleCache) Stats() CacheStats {
+    c.mu.Lock()
+    defer c.mu.Unlock()
+
+    s := c.stats
+    s.Modules = c.lru.Len()
+    s.Bytes = c.size
+    return s
+}
+
+type CacheStats struct {
+    Hits, Misses, Evictions int
+    Modules, Bytes          int // Held at the time of the call
+}
+
+func (s CacheStats) String() string {
+    rate := 0.0
+    if s.Hits+s.Misses > 0 {
+        rate = 100 * float64(s.Hits) / float64(s.Hits+s.Misses)
+    }
+    return fmt.Sprintf("hits:%d misses:%d (%.1f%% hit) evictions:%d held:%d modules %.1fMB",
+        s.Hits, s.Misses, rate, s.Evictions, s.Modules, float64(s.Bytes)/(1<<20))
+}
+
+// size estimates the memory held by module m: the strings it refers to plus a
+// rough per-record overhead for structs, pointers and map entries.
+func (m Module) size() int {
+    const overhead = 64
+
+    size := overhead + len(m.Name)
+    for _, p := range m.Ports {
+        size += overhead + len(p.Parent) + len(p.Name) + len(p.Type)
+    }
+    for _, i := range m.Insts {
+        size += overhead + len(i.Parent) + len(i.Name) + len(i.Type)
+    }
+    for _, conns := range m.Conns {
+        for _, c := range conns {
+            size += overhead + len(c.Parent) + len(c.Iname) + len(c.Itype) +
+                len(c.Actual) + len(c.Type)
+        }
+    }
+    for _, props := range m.Props {
+        for _, p := range props {
+            size += overhead + len(p.Parent) + len(p.Iname) + len(p.Itype) +
+                len(p.Key) + len(p.Val)
+        }
+    }
+    return size
+}
@@ -0,0 +1,52 @@
+package rtl
+
+import (
+    "testing"
+)
+
+// countingLoader hands out empty modules and counts how often each is loaded
+type countingLoader map[string]int
+
+func (l countingLoader) LoadModule(name string) *Module {
+    l[name]++
+    return NewModule(name)
+}
+
+func TestModuleCache(t *testing.T) {
+    loads := countingLoader{}
+
+    // Room for exactly two of the single-letter modules
+    c := NewModuleCache(loads, 2*NewModule("a").size())
+
+    for _, name := range []string{"a", "b", "a", "c", "a", "b"} {
+        if m := c.LoadModule(name); m.Name != name {
+            t.Fatalf("Expecting module %q. Got %q", name, m.Name)
+        }
+    }
+
+    // Loading c evicts b, the least recently used, and loading b again
+    // evicts c. Module a stays throughout.
+    exp := map[string]int{"a": 1, "b": 2, "c": 1}
+    for name, n := range exp {
+        if loads[name] != n {
+            t.Errorf("Expecting %q to be loaded %d times. Got %d", name, n, loads[name])
+        }
+    }
+
+    s := c.Stats()
+    if s.Hits != 2 || s.Misses != 4 || s.Evictions != 2 || s.Modules != 2 {
+        t.Errorf("Unexpected stats %+v", s)
+    }
+}
+
+func TestModuleCacheOversize(t *testing.T) {
+    loads := countingLoader{}
+    c := NewModuleCache(loads, 1)
+
+    c.LoadModule("a")
+    c.LoadModule("a")
+
+    if loads["a"] != 2 || c.Stats().Modules != 0 {
+        t.Errorf("Expecting a module larger than the cache not to be kept")
+    }
+}
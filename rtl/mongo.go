@@ -1,82 +1,114 @@
 package rtl
 
 import (
+    "context"
     "log"
+    "sart/bulk"
+    "sort"
     "sync"
     "gopkg.in/mgo.v2"
     "gopkg.in/mgo.v2/bson"
 )
 
-var mgosession *mgo.Session
+// Store holds the modules of one cache. Each Store carries its own session
+// and insert workers, so several caches can be open side by side in one
+// process.
+type Store struct {
+    Cache string
 
-const db = "sart"
+    session *mgo.Session
+    ctx     context.Context
 
-var collection, portcoll, instcoll, conncoll, propcoll string
+    portcoll, instcoll, conncoll, propcoll string
 
-////////////////////////////////////////////////////////////////////////////////
-// Worker pool for insert jobs
-
-const MaxMgoThreads = 8
-
-var wg sync.WaitGroup
-
-type insertjob struct {
-    col string
-    doc interface{}
+    // Batched writer for insert jobs. It is only started on the first Save so
+    // that read-only users do not carry idle workers.
+    once   sync.Once
+    writer *bulk.Writer
 }
 
-var jobs chan insertjob
+// Collections returns the names of all collections that hold the modules of
+// cache cname.
+func Collections(cname string) []string {
+    return []string{
+        cname + "_ports",
+        cname + "_insts",
+        cname + "_conns",
+        cname + "_props",
+    }
+}
 
-func worker() {
-    s := mgosession.Copy()
+// NewStore opens cache cname to save and load modules. The collections live in
+// the default database of session s, i.e. the database it was dialed with.
+// Inserts stop when ctx is cancelled.
+func NewStore(ctx context.Context, s *mgo.Session, cname string, drop bool) *Store {
+    st := &Store{
+        Cache   : cname,
+        session : s.Copy(),
+        ctx     : ctx,
+        portcoll: cname + "_ports",
+        instcoll: cname + "_insts",
+        conncoll: cname + "_conns",
+        propcoll: cname + "_props",
+    }
 
-    for job := range jobs {
-        c := s.DB(db).C(job.col)
-        err := c.Insert(job.doc)
-        if err != nil {
-            log.Fatal(err)
+    if drop {
+        for _, coll := range Collections(cname) {
+            st.dropCollection(coll)
         }
     }
-    wg.Done()
+
+    EnsureIndexes(st.session, cname)
+
+    return st
 }
 
-// Synchronizers
+// Session returns the session the store was opened with.
+func (st *Store) Session() *mgo.Session {
+    return st.session
+}
 
-func DoneMgo() {
-    close(jobs)
+func (st *Store) startWriter() {
+    st.once.Do(func() {
+        st.writer = bulk.New(st.ctx, st.session, bulk.Workers, bulk.BatchSize)
+    })
 }
 
-func WaitMgo() {
-    wg.Wait()
+// Synchronizers
+
+// Done is to be invoked when there are no more modules to save.
+func (st *Store) Done() {
+    st.startWriter()
+    st.writer.Done()
 }
 
-////////////////////////////////////////////////////////////////////////////////
+// Wait waits for all inserts to complete. It returns the error that stopped
+// the inserts early, if any. Wait is to be invoked after Done.
+func (st *Store) Wait() error {
+    stats, err := st.writer.Wait()
+    log.Printf("rtl inserts (%s): %v", st.Cache, stats)
+    return err
+}
 
-func InitMgo(s *mgo.Session, cname string, drop bool) {
-    mgosession = s.Copy()
-    collection = cname
+// Close releases the session of the store.
+func (st *Store) Close() {
+    st.session.Close()
+}
 
-    portcoll = cname + "_ports"
-    instcoll = cname + "_insts"
-    conncoll = cname + "_conns"
-    propcoll = cname + "_props"
+////////////////////////////////////////////////////////////////////////////////
 
+// EnsureIndexes creates the indexes that the module collections of cache
+// cname rely on. It is safe to call on a cache that already has them.
+func EnsureIndexes(s *mgo.Session, cname string) {
     var err error
 
-    if drop {
-        dropCollection(portcoll)
-        dropCollection(instcoll)
-        dropCollection(conncoll)
-        dropCollection(propcoll)
-    }
-
     // Each port in a module must have a unique name
-    n := mgosession.DB(db).C(portcoll)
+    n := s.DB("").C(cname + "_ports")
     err = n.EnsureIndex(mgo.Index{ Key: []string{"module", "name"}, Unique: true })
     if err != nil { log.Fatal(err) }
 
     // Each instance in a module must have a unique name
-    i := mgosession.DB(db).C(instcoll)
+    i := s.DB("").C(cname + "_insts")
     err = i.EnsureIndex(mgo.Index{ Key: []string{"module", "name"}, Unique: true })
     if err != nil { log.Fatal(err) }
 
@@ -86,12 +118,12 @@ func InitMgo(s *mgo.Session, cname string, drop bool) {
     if err != nil { log.Fatal(err) }
 
     // Each formal name of an instance connection in a module must be unique
-    c := mgosession.DB(db).C(conncoll)
+    c := s.DB("").C(cname + "_conns")
     err = c.EnsureIndex(mgo.Index{ Key: []string{"module", "iname", "pos"}, Unique: true })
     if err != nil { log.Fatal(err) }
 
     // Each formal name of an instance connection in a module must be unique
-    p := mgosession.DB(db).C(propcoll)
+    p := s.DB("").C(cname + "_props")
     err = p.EnsureIndex(mgo.Index{ Key: []string{"module", "iname", "key", "val"}, Unique: true })
     if err != nil { log.Fatal(err) }
 
@@ -99,54 +131,51 @@ func InitMgo(s *mgo.Session, cname string, drop bool) {
     // as selector
     err = c.EnsureIndex(mgo.Index{ Key: []string{"itype"} })
     if err != nil { log.Fatal(err) }
-
-    // Initialize worker pool for insert jobs
-    jobs = make(chan insertjob, 100)
-    for i := 0; i < MaxMgoThreads; i++ {
-        wg.Add(1)
-        go worker()
-    }
 }
 
-func dropCollection(coll string) {
-    c := mgosession.DB(db).C(coll)
+func (st *Store) dropCollection(coll string) {
+    c := st.session.DB("").C(coll)
     err := c.DropCollection()
     if err != nil {
         log.Println(err)
     }
 }
 
-func cache() *mgo.Collection {
-    s := mgosession.Copy()
-    c := s.DB(db).C(collection)
-    return c
-}
+// Save queues all parts of module m for insertion.
+func (st *Store) Save(m *Module) {
+    st.startWriter()
 
-func (m *Module) Save() {
     for _, port := range m.Ports {
-        jobs <- insertjob{portcoll, port}
+        st.writer.Insert(st.portcoll, port)
     }
 
     for _, inst := range m.Insts {
-        jobs <- insertjob{instcoll, inst}
+        st.writer.Insert(st.instcoll, inst)
     }
 
     for _, conns := range m.Conns {
         for _, conn := range conns {
-            jobs <- insertjob{conncoll, conn}
+            st.writer.Insert(st.conncoll, conn)
         }
     }
 
     for _, props := range m.Props {
         for _, prop := range props {
-            jobs <- insertjob{propcoll, prop}
+            st.writer.Insert(st.propcoll, prop)
         }
     }
 }
 
-func (m *Module) Load() {
+// Load fills module m, which need only have a name, with its ports, instances
+// and connections.
+func (st *Store) Load(m *Module) {
+    // Each load gets a socket of its own so that modules can be loaded in
+    // parallel.
+    s := st.session.Copy()
+    defer s.Close()
+
     // ports collection, query and iterator
-    wc := mgosession.DB(db).C(portcoll)
+    wc := s.DB("").C(st.portcoll)
     wq := wc.Find(bson.M{"module": m.Name})
     wi := wq.Iter()
 
@@ -170,7 +199,7 @@ func (m *Module) Load() {
     }
 
     // instance collection, query and iterator
-    ic := mgosession.DB(db).C(instcoll)
+    ic := s.DB("").C(st.instcoll)
     iq := ic.Find(bson.M{"module": m.Name})
     ii := iq.Iter()
 
@@ -192,7 +221,7 @@ func (m *Module) Load() {
     }
 
     // connection collection, query and iterator
-    cc := mgosession.DB(db).C(conncoll)
+    cc := s.DB("").C(st.conncoll)
     cq := cc.Find(bson.M{"module": m.Name})
     ci := cq.Iter()
 
@@ -224,10 +253,10 @@ func (m *Module) Load() {
 // 2. https://docs.mongodb.com/manual/reference/operator/aggregation/group
 // 3. https://godoc.org/labix.org/v2/mgo#Collection.Pipe
 //
-func (m Module) InstNames() map[string]string {
+func (st *Store) InstNames(m Module) map[string]string {
     insts := make(map[string]string)
 
-    c := mgosession.DB(db).C(conncoll)
+    c := st.session.DB("").C(st.conncoll)
 
     // Setup the aggregation pipeline
     pipe := c.Pipe([]bson.M{
@@ -256,8 +285,34 @@ func (m Module) InstNames() map[string]string {
     return insts
 }
 
-func LoadModule(top string) *Module {
+// ModuleNames returns the names of all modules in the cache, in order. A
+// module is known by its ports or its instances.
+func (st *Store) ModuleNames() []string {
+    s := st.session.Copy()
+    defer s.Close()
+
+    found := make(map[string]bool)
+    for _, coll := range []string{st.portcoll, st.instcoll} {
+        var names []string
+        err := s.DB("").C(coll).Find(nil).Distinct("module", &names)
+        if err != nil {
+            log.Fatal(err)
+        }
+        for _, name := range names {
+            found[name] = true
+        }
+    }
+
+    names := make([]string, 0, len(found))
+    for name := range found {
+        names = append(names, name)
+    }
+    sort.Strings(names)
+    return names
+}
+
+func (st *Store) LoadModule(top string) *Module {
     m := NewModule(top)
-    m.Load()
+    st.Load(m)
     return m
 }